@@ -0,0 +1,186 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+
+	"github.com/jhampac/picha/importer"
+	"github.com/jhampac/picha/model"
+)
+
+// adminUsage lists the admin commands run with "picha <command>"
+const adminUsage = `usage: picha <command> [flags]
+
+commands:
+  import            import a server directory or ZIP archive into a new gallery
+  plan              move a user to another storage plan
+  reconcile-usage   recompute every user's storage usage from the stored files
+`
+
+// runAdmin runs an admin command against the configured database and returns
+// the process exit code
+func runAdmin(args []string, cfg Config, services *model.Services, out io.Writer) int {
+	switch args[0] {
+	case "import":
+		return runImport(args[1:], cfg, services, out)
+	case "plan":
+		return runPlan(args[1:], services, out)
+	case "reconcile-usage":
+		return runReconcileUsage(args[1:], services, out)
+	default:
+		fmt.Fprint(out, adminUsage)
+		return 2
+	}
+}
+
+// runImport imports a directory on the server, or a ZIP archive, for the user
+// with the given email, printing each file's result as it goes
+func runImport(args []string, cfg Config, services *model.Services, out io.Writer) int {
+	fs := flag.NewFlagSet("import", flag.ContinueOnError)
+	fs.SetOutput(out)
+	userEmail := fs.String("email", "", "email address of the gallery's owner")
+	title := fs.String("title", "", "gallery title, defaults to the directory or archive name")
+	visibility := fs.String("visibility", model.VisibilityPrivate, "gallery visibility: private or public")
+	fs.Usage = func() {
+		fmt.Fprintln(out, "usage: picha import -email EMAIL [-title TITLE] [-visibility VISIBILITY] PATH")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if *userEmail == "" || fs.NArg() != 1 {
+		fs.Usage()
+		return 2
+	}
+	root := fs.Arg(0)
+
+	user, err := services.User.ByEmail(*userEmail)
+	if err != nil {
+		fmt.Fprintf(out, "import: user %s: %v\n", *userEmail, err)
+		return 1
+	}
+
+	var src importer.Source
+	if strings.EqualFold(filepath.Ext(root), ".zip") {
+		src, err = importer.OpenZip(root, importer.DefaultLimits)
+	} else {
+		src, err = importer.OpenDir(root, importer.DefaultLimits)
+	}
+	if err != nil {
+		fmt.Fprintf(out, "import: %v\n", err)
+		return 1
+	}
+	defer src.Close()
+
+	name := filepath.Base(filepath.Clean(root))
+	if *title == "" {
+		*title = strings.TrimSuffix(name, filepath.Ext(name))
+	}
+	gallery := model.Gallery{
+		UserID:     user.ID,
+		Title:      *title,
+		Visibility: *visibility,
+	}
+	if err := services.Gallery.Create(&gallery); err != nil {
+		fmt.Fprintf(out, "import: creating gallery: %v\n", err)
+		return 1
+	}
+	job := model.ImportJob{
+		UserID:    user.ID,
+		GalleryID: gallery.ID,
+		Source:    name,
+	}
+	if err := services.Import.Create(&job); err != nil {
+		fmt.Fprintf(out, "import: %v\n", err)
+		return 1
+	}
+
+	im := importer.Importer{
+		Images:  services.Image,
+		Imports: services.Import,
+		Progress: func(item *model.ImportItem) {
+			if item.Message != "" {
+				fmt.Fprintf(out, "%-9s %s: %s\n", item.Result, item.Name, item.Message)
+				return
+			}
+			fmt.Fprintf(out, "%-9s %s\n", item.Result, item.Name)
+		},
+	}
+	err = im.Run(context.Background(), &job, src)
+
+	// the counters are kept in the database, re-read them for the summary
+	if done, ferr := services.Import.ByID(job.ID); ferr == nil {
+		job = *done
+	}
+	fmt.Fprintf(out, "%d files: %d imported, %d duplicates, %d skipped, %d failed\n",
+		job.Total, job.Imported, job.Duplicates, job.Skipped, job.Failed)
+	fmt.Fprintf(out, "%s/import/%d\n", strings.TrimSuffix(cfg.BaseURL, "/"), job.ID)
+	if err != nil {
+		fmt.Fprintf(out, "import: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+// runPlan sets the storage plan of the user with the given email
+func runPlan(args []string, services *model.Services, out io.Writer) int {
+	fs := flag.NewFlagSet("plan", flag.ContinueOnError)
+	fs.SetOutput(out)
+	userEmail := fs.String("email", "", "email address of the user")
+	fs.Usage = func() {
+		fmt.Fprintln(out, "usage: picha plan -email EMAIL free|pro")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if *userEmail == "" || fs.NArg() != 1 {
+		fs.Usage()
+		return 2
+	}
+
+	user, err := services.User.ByEmail(*userEmail)
+	if err != nil {
+		fmt.Fprintf(out, "plan: user %s: %v\n", *userEmail, err)
+		return 1
+	}
+	user.Plan = model.Plan(fs.Arg(0))
+	if err := services.User.Update(user); err != nil {
+		fmt.Fprintf(out, "plan: %v\n", err)
+		return 1
+	}
+	fmt.Fprintf(out, "%s is on the %s plan: %d of %d bytes used\n", user.Email, user.Plan, user.StorageUsed, user.Plan.Quota())
+	return 0
+}
+
+// runReconcileUsage measures the stored files and corrects the usage of
+// every user whose counter drifted, printing each correction
+func runReconcileUsage(args []string, services *model.Services, out io.Writer) int {
+	fs := flag.NewFlagSet("reconcile-usage", flag.ContinueOnError)
+	fs.SetOutput(out)
+	fs.Usage = func() {
+		fmt.Fprintln(out, "usage: picha reconcile-usage")
+	}
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() != 0 {
+		fs.Usage()
+		return 2
+	}
+
+	changes, err := services.Image.ReconcileUsage(context.Background())
+	for _, c := range changes {
+		fmt.Fprintf(out, "%s: %d -> %d bytes\n", c.Email, c.Before, c.After)
+	}
+	if err != nil {
+		fmt.Fprintf(out, "reconcile-usage: %v\n", err)
+		return 1
+	}
+	fmt.Fprintf(out, "%d users corrected\n", len(changes))
+	return 0
+}
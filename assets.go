@@ -0,0 +1,20 @@
+package main
+
+import (
+	"embed"
+	"io/fs"
+	"os"
+)
+
+// embedded holds the templates and static assets so the binary runs from any directory
+//
+//go:embed templates static
+var embedded embed.FS
+
+// assetsFS returns the embedded files, or the files below dir when it is set
+func assetsFS(dir string) fs.FS {
+	if dir == "" {
+		return embedded
+	}
+	return os.DirFS(dir)
+}
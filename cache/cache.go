@@ -0,0 +1,16 @@
+package cache
+
+// Cache keeps encoded values under string keys for a limited time, so hot
+// database reads can be skipped. Implementations must be safe for concurrent
+// use. One backed by another server, such as Redis, should report its errors
+// as misses: the database stays the source of truth.
+type Cache interface {
+	// Get returns the value stored under key unless it expired or was evicted
+	Get(key string) ([]byte, bool)
+
+	// Set stores value under key for the cache's time to live
+	Set(key string, value []byte)
+
+	// Delete drops the keys; missing keys are ignored
+	Delete(keys ...string)
+}
@@ -0,0 +1,74 @@
+package cache
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLRUEvictsTheLeastRecentlyUsed(t *testing.T) {
+	c := NewLRU(2, time.Minute)
+	c.Set("a", []byte("1"))
+	c.Set("b", []byte("2"))
+	if _, ok := c.Get("a"); !ok {
+		t.Fatal("lost a before the cache was full")
+	}
+	c.Set("c", []byte("3"))
+
+	if _, ok := c.Get("b"); ok {
+		t.Fatal("kept b, the least recently used entry")
+	}
+	for _, key := range []string{"a", "c"} {
+		if _, ok := c.Get(key); !ok {
+			t.Fatalf("evicted %s", key)
+		}
+	}
+}
+
+func TestLRUSetReplacesValues(t *testing.T) {
+	c := NewLRU(2, time.Minute)
+	c.Set("a", []byte("1"))
+	c.Set("a", []byte("2"))
+	c.Set("b", []byte("3"))
+	if v, ok := c.Get("a"); !ok || string(v) != "2" {
+		t.Fatalf("Get(a) = %q, %v, want the new value", v, ok)
+	}
+	if _, ok := c.Get("b"); !ok {
+		t.Fatal("replacing a value took up a second entry")
+	}
+}
+
+func TestLRUExpiresEntries(t *testing.T) {
+	c := NewLRU(2, 20*time.Millisecond)
+	c.Set("a", []byte("1"))
+	if _, ok := c.Get("a"); !ok {
+		t.Fatal("missed a fresh entry")
+	}
+	time.Sleep(40 * time.Millisecond)
+	if _, ok := c.Get("a"); ok {
+		t.Fatal("returned an expired entry")
+	}
+	if len(c.entries) != 0 || c.order.Len() != 0 {
+		t.Fatal("kept an expired entry after reading it")
+	}
+
+	// setting again starts a new time to live
+	c.Set("a", []byte("2"))
+	if v, ok := c.Get("a"); !ok || string(v) != "2" {
+		t.Fatalf("Get(a) = %q, %v after setting it again", v, ok)
+	}
+}
+
+func TestLRUDelete(t *testing.T) {
+	c := NewLRU(3, time.Minute)
+	c.Set("a", []byte("1"))
+	c.Set("b", []byte("2"))
+	c.Delete("a", "b", "missing")
+	for _, key := range []string{"a", "b"} {
+		if _, ok := c.Get(key); ok {
+			t.Fatalf("kept deleted %s", key)
+		}
+	}
+	if c.order.Len() != 0 {
+		t.Fatalf("%d entries left, want 0", c.order.Len())
+	}
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+)
+
+// configFile is read from the working directory when present
+const configFile = ".config"
+
+// The development signing keys are published in the repository, so
+// production must set its own
+const (
+	devCSRFKey  = "dev-csrf-key-of-exactly-32-bytes"
+	devFlashKey = "not-really-a-secret"
+)
+
+// DatabaseConfig holds the connection details for the database
+type DatabaseConfig struct {
+	// Driver is "postgres" or "sqlite3"; SQLite is meant for local development only
+	Driver string `json:"driver"`
+
+	// Path is the SQLite database file
+	Path string `json:"path"`
+
+	Host     string `json:"host"`
+	Port     int    `json:"port"`
+	User     string `json:"user"`
+	Password string `json:"password"`
+	Name     string `json:"name"`
+}
+
+// Dialect is the gorm dialect for this config
+func (c DatabaseConfig) Dialect() string {
+	if c.Driver == "" {
+		return "postgres"
+	}
+	return c.Driver
+}
+
+// ConnectionInfo builds the connection string passed to gorm
+func (c DatabaseConfig) ConnectionInfo() string {
+	if c.Dialect() == "sqlite3" {
+		return c.Path
+	}
+	if c.Password == "" {
+		return fmt.Sprintf("host=%s port=%d user=%s dbname=%s sslmode=disable", c.Host, c.Port, c.User, c.Name)
+	}
+	return fmt.Sprintf("host=%s port=%d user=%s password=%s dbname=%s sslmode=disable", c.Host, c.Port, c.User, c.Password, c.Name)
+}
+
+// DefaultDatabaseConfig is the local development database
+func DefaultDatabaseConfig() DatabaseConfig {
+	return DatabaseConfig{
+		Driver:   "postgres",
+		Path:     "picha.db",
+		Host:     "localhost",
+		Port:     5432,
+		User:     "admin",
+		Password: "testpassword",
+		Name:     "picha_dev",
+	}
+}
+
+// MetricsConfig controls who may scrape /metrics
+type MetricsConfig struct {
+	AllowedIPs []string `json:"allowed_ips"`
+	Username   string   `json:"username"`
+	Password   string   `json:"password"`
+}
+
+// DefaultMetricsConfig only allows scrapes from the local machine
+func DefaultMetricsConfig() MetricsConfig {
+	return MetricsConfig{
+		AllowedIPs: []string{"127.0.0.1", "::1"},
+	}
+}
+
+// MailConfig is the SMTP server used for invitations; without a host emails are only logged
+type MailConfig struct {
+	Host     string `json:"host"`
+	Port     int    `json:"port"`
+	Username string `json:"username"`
+	Password string `json:"password"`
+	From     string `json:"from"`
+}
+
+// DefaultMailConfig logs emails instead of sending them
+func DefaultMailConfig() MailConfig {
+	return MailConfig{
+		Port: 587,
+		From: "Picha <no-reply@picha.com>",
+	}
+}
+
+// CacheConfig sizes the in-process cache of signed in users and galleries
+type CacheConfig struct {
+	// Size is how many entries are kept; 0 turns the cache off
+	Size int `json:"size"`
+
+	// TTL is how many seconds an entry is kept. Changes made by other
+	// processes, such as admin commands, show up after at most this long.
+	TTL int `json:"ttl"`
+}
+
+// DefaultCacheConfig keeps entries for a minute
+func DefaultCacheConfig() CacheConfig {
+	return CacheConfig{
+		Size: 10000,
+		TTL:  60,
+	}
+}
+
+// Config is the top level app configuration
+type Config struct {
+	Port     int            `json:"port"`
+	Env      string         `json:"env"`
+	LogLevel string         `json:"log_level"`
+	Database DatabaseConfig `json:"database"`
+	Metrics  MetricsConfig  `json:"metrics"`
+	Mail     MailConfig     `json:"mail"`
+	Cache    CacheConfig    `json:"cache"`
+
+	// BaseURL is where the app is reached, used for links in emails
+	BaseURL string `json:"base_url"`
+
+	// Workers is how many background jobs this process runs at a time; 0
+	// leaves the jobs to other processes
+	Workers int `json:"workers"`
+
+	// StorageDir is where uploaded files are kept
+	StorageDir string `json:"storage_dir"`
+
+	// UploadDir is where resumable uploads are kept until their last chunk arrives
+	UploadDir string `json:"upload_dir"`
+
+	// CSRFKey signs the CSRF cookie; it must be 32 bytes
+	CSRFKey string `json:"csrf_key"`
+
+	// FlashKey signs the cookie that carries alerts across redirects
+	FlashKey string `json:"flash_key"`
+
+	// AssetsDir reads templates/ and static/ from disk instead of the binary,
+	// e.g. "." in a checkout so template changes are picked up without a rebuild
+	AssetsDir string `json:"assets_dir"`
+
+	// SQLLogLevel is the level gorm statements are logged at; "off" only logs errors
+	SQLLogLevel string `json:"sql_log_level"`
+}
+
+// IsProd reports whether the app is running in production
+func (c Config) IsProd() bool {
+	return c.Env == "prod"
+}
+
+// checkSecrets refuses production configs that leave a signing key unset or
+// at its published development default
+func (c Config) checkSecrets() error {
+	if !c.IsProd() {
+		return nil
+	}
+	if c.CSRFKey == "" || c.CSRFKey == devCSRFKey {
+		return errors.New("config: csrf_key must be set in production")
+	}
+	if c.FlashKey == "" || c.FlashKey == devFlashKey {
+		return errors.New("config: flash_key must be set in production")
+	}
+	return nil
+}
+
+// DefaultConfig is used when no config file is found
+func DefaultConfig() Config {
+	return Config{
+		Port:        9000,
+		Env:         "dev",
+		LogLevel:    "info",
+		SQLLogLevel: "debug",
+		Database:    DefaultDatabaseConfig(),
+		Metrics:     DefaultMetricsConfig(),
+		Mail:        DefaultMailConfig(),
+		Cache:       DefaultCacheConfig(),
+		BaseURL:     "http://localhost:9000",
+		StorageDir:  "images",
+		UploadDir:   "uploads",
+		Workers:     4,
+		CSRFKey:     devCSRFKey,
+		FlashKey:    devFlashKey,
+	}
+}
+
+// LoadConfig reads the config file on top of the defaults; a missing file is not an error
+func LoadConfig() (Config, error) {
+	c := DefaultConfig()
+	f, err := os.Open(configFile)
+	if os.IsNotExist(err) {
+		return c, nil
+	}
+	if err != nil {
+		return c, err
+	}
+	defer f.Close()
+
+	if err := json.NewDecoder(f).Decode(&c); err != nil {
+		return c, fmt.Errorf("config: parsing %s: %w", configFile, err)
+	}
+	return c, nil
+}
+
+// parseLevel turns a config level such as "debug" or "warn" into a slog.Level
+func parseLevel(s string) (slog.Level, error) {
+	var l slog.Level
+	err := l.UnmarshalText([]byte(s))
+	return l, err
+}
@@ -3,17 +3,29 @@ package context
 import (
 	"context"
 
+	"github.com/jhampac/picha/i18n"
 	"github.com/jhampac/picha/model"
 )
 
 type privateContextKey string
 
 const (
-	userKey privateContextKey = "user"
+	userKey    privateContextKey = "user"
+	requestKey privateContextKey = "request"
+	localeKey  privateContextKey = "locale"
 )
 
+// request is the per-request state shared with the middleware that created it
+type request struct {
+	id     string
+	userID uint
+}
+
 // WithUser is a wrapper for a custom context object; this guarantees that the value we get back will always be a user
 func WithUser(ctx context.Context, user *model.User) context.Context {
+	if req, ok := ctx.Value(requestKey).(*request); ok && user != nil {
+		req.userID = user.ID
+	}
 	return context.WithValue(ctx, userKey, user)
 }
 
@@ -26,3 +38,38 @@ func User(ctx context.Context) *model.User {
 	}
 	return nil
 }
+
+// WithRequestID attaches the request id generated by the logging middleware
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestKey, &request{id: id})
+}
+
+// RequestID retrieves the id of the current request, or "" if none was attached
+func RequestID(ctx context.Context) string {
+	if req, ok := ctx.Value(requestKey).(*request); ok {
+		return req.id
+	}
+	return ""
+}
+
+// RequestUserID returns the ID of the user signed in at any point during the
+// request. Unlike User it is visible to middleware that ran before the user was loaded.
+func RequestUserID(ctx context.Context) uint {
+	if req, ok := ctx.Value(requestKey).(*request); ok {
+		return req.userID
+	}
+	return 0
+}
+
+// WithLocale attaches the locale the response should be rendered in
+func WithLocale(ctx context.Context, locale string) context.Context {
+	return context.WithValue(ctx, localeKey, locale)
+}
+
+// Locale retrieves the locale of the request, or i18n.Default if none was attached
+func Locale(ctx context.Context) string {
+	if locale, ok := ctx.Value(localeKey).(string); ok {
+		return locale
+	}
+	return i18n.Default
+}
@@ -0,0 +1,376 @@
+package controller
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	"github.com/jhampac/picha/context"
+	"github.com/jhampac/picha/model"
+	"github.com/jhampac/picha/view"
+)
+
+const (
+	IndexCollections = "index_collections"
+	ShowCollection   = "show_collection"
+	EditCollection   = "edit_collection"
+)
+
+// Collection controller for curated sets of images from the owner's galleries
+type Collection struct {
+	NewView   *view.View
+	ShowView  *view.View
+	EditView  *view.View
+	IndexView *view.View
+	cs        model.CollectionService
+	is        model.ImageService
+	gs        model.GalleryService
+	ms        model.MemberService
+	r         *mux.Router
+}
+
+// NewCollection instantiates a new controller for the collection resource
+func NewCollection(cs model.CollectionService, is model.ImageService, gs model.GalleryService, ms model.MemberService, r *mux.Router) *Collection {
+	return &Collection{
+		NewView:   view.New("appcontainer", "collection/new"),
+		ShowView:  view.New("appcontainer", "collection/show"),
+		EditView:  view.New("appcontainer", "collection/edit"),
+		IndexView: view.New("appcontainer", "collection/index"),
+		cs:        cs,
+		is:        is,
+		gs:        gs,
+		ms:        ms,
+		r:         r,
+	}
+}
+
+// CollectionForm represents the data parsed from the form body
+type CollectionForm struct {
+	Title       string `schema:"title"`
+	Description string `schema:"description"`
+	Visibility  string `schema:"visibility"`
+}
+
+// CollectionPage is yielded to the show and edit templates
+type CollectionPage struct {
+	*model.Collection
+	Images []model.Image
+}
+
+// MoveOrder is the comma separated image order with image i moved by delta
+func (p *CollectionPage) MoveOrder(i, delta int) string {
+	return moveOrder(p.Images, i, delta)
+}
+
+// Create parses the form body and creates a new collection: POST /collection
+func (c *Collection) Create(w http.ResponseWriter, r *http.Request) {
+	var vd view.Data
+	var form CollectionForm
+
+	// re-render the form with what was submitted on errors
+	vd.Yield = &form
+	if err := parseForm(&form, r); err != nil {
+		vd.SetAlert(err)
+		c.NewView.Render(w, r, vd)
+		return
+	}
+
+	user := context.User(r.Context())
+	collection := model.Collection{
+		Title:       form.Title,
+		Description: form.Description,
+		Visibility:  form.Visibility,
+		UserID:      user.ID,
+	}
+	if err := c.cs.Create(&collection); err != nil {
+		vd.SetAlert(err)
+		c.NewView.Render(w, r, vd)
+		return
+	}
+
+	c.redirect(w, r, ShowCollection, &collection, view.Alert{
+		Level:   view.AlertLvlSuccess,
+		Message: "Collection successfully created!",
+	})
+}
+
+// Index lists the collections of the signed in user: GET /collection
+func (c *Collection) Index(w http.ResponseWriter, r *http.Request) {
+	user := context.User(r.Context())
+	collections, err := c.cs.ByUserID(user.ID)
+	if err != nil {
+		view.Error(w, r, "Uh oh! something went wrong", http.StatusInternalServerError)
+		return
+	}
+	var vd view.Data
+	vd.Yield = collections
+	c.IndexView.Render(w, r, vd)
+}
+
+// Show displays a collection with the images the visitor may see: GET /collection/:id
+func (c *Collection) Show(w http.ResponseWriter, r *http.Request) {
+	collection, err := c.collectionByID(w, r)
+	if err != nil {
+		return
+	}
+	if !collection.VisibleTo(context.User(r.Context())) {
+		view.Error(w, r, "Collection not found", http.StatusNotFound)
+		return
+	}
+
+	page, err := c.page(r, collection)
+	if err != nil {
+		view.Error(w, r, "Uh oh! something went wrong", http.StatusInternalServerError)
+		return
+	}
+	var vd view.Data
+	vd.Yield = page
+	c.ShowView.Render(w, r, vd)
+}
+
+// Edit a users collection: GET /collection/:id/edit
+func (c *Collection) Edit(w http.ResponseWriter, r *http.Request) {
+	collection, err := c.ownCollectionByID(w, r)
+	if err != nil {
+		return
+	}
+	c.renderEdit(w, r, collection, view.Data{})
+}
+
+// Update a collection resource: POST /collection/:id/update
+func (c *Collection) Update(w http.ResponseWriter, r *http.Request) {
+	collection, err := c.ownCollectionByID(w, r)
+	if err != nil {
+		return
+	}
+
+	var vd view.Data
+	var form CollectionForm
+	if err := parseForm(&form, r); err != nil {
+		vd.SetAlert(err)
+		c.renderEdit(w, r, collection, vd)
+		return
+	}
+
+	collection.Title = form.Title
+	collection.Description = form.Description
+	collection.Visibility = form.Visibility
+	if err := c.cs.Update(collection); err != nil {
+		vd.SetAlert(err)
+		c.renderEdit(w, r, collection, vd)
+		return
+	}
+
+	c.redirect(w, r, EditCollection, collection, view.Alert{
+		Level:   view.AlertLvlSuccess,
+		Message: "Collection successfully updated!",
+	})
+}
+
+// Delete a collection resource; the images stay in their galleries: POST /collection/:id/delete
+func (c *Collection) Delete(w http.ResponseWriter, r *http.Request) {
+	collection, err := c.ownCollectionByID(w, r)
+	if err != nil {
+		return
+	}
+
+	if err := c.cs.Delete(collection.ID); err != nil {
+		var vd view.Data
+		vd.SetAlert(err)
+		c.renderEdit(w, r, collection, vd)
+		return
+	}
+
+	url, err := c.r.Get(IndexCollections).URL()
+	if err != nil {
+		http.Redirect(w, r, "/", http.StatusFound)
+		return
+	}
+	view.RedirectAlert(w, r, url.Path, http.StatusFound, view.Alert{
+		Level:   view.AlertLvlSuccess,
+		Message: "Collection %q successfully deleted!",
+		Args:    []interface{}{collection.Title},
+	})
+}
+
+// AddImage puts an image the user can see into one of their collections: POST /collection/images
+func (c *Collection) AddImage(w http.ResponseWriter, r *http.Request) {
+	var form struct {
+		CollectionID uint `schema:"collection_id"`
+		ImageID      uint `schema:"image_id"`
+	}
+	if err := parseForm(&form, r); err != nil {
+		view.Error(w, r, "Invalid collection or image", http.StatusBadRequest)
+		return
+	}
+	user := context.User(r.Context())
+
+	// the collection has to be the user's, the image one they may view
+	collection, err := c.cs.ByID(form.CollectionID)
+	if err != nil || collection.UserID != user.ID {
+		view.Error(w, r, "Collection not found", http.StatusNotFound)
+		return
+	}
+	image, err := c.is.ByID(form.ImageID)
+	if err != nil {
+		view.Error(w, r, "Image not found", http.StatusNotFound)
+		return
+	}
+	gallery, err := c.gs.ByID(image.GalleryID)
+	if err == nil {
+		err = c.ms.Authorize(user, gallery, model.ActionView)
+	}
+	if err != nil {
+		switch err {
+		case model.ErrNotFound, model.ErrForbidden:
+			view.Error(w, r, "Image not found", http.StatusNotFound)
+		default:
+			view.Error(w, r, "Uh oh! something went wrong", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	if err := c.cs.AddImage(collection.ID, image.ID); err != nil {
+		view.Error(w, r, "Uh oh! something went wrong", http.StatusInternalServerError)
+		return
+	}
+
+	// back to the image the user was looking at
+	url, err := c.r.Get(ShowImage).URL("id", strconv.Itoa(int(gallery.ID)), "imageID", strconv.Itoa(int(image.ID)))
+	if err != nil {
+		http.Redirect(w, r, "/", http.StatusFound)
+		return
+	}
+	view.RedirectAlert(w, r, url.Path, http.StatusFound, view.Alert{
+		Level:   view.AlertLvlSuccess,
+		Message: "Image added to %q!",
+		Args:    []interface{}{collection.Title},
+	})
+}
+
+// RemoveImage takes an image out of a collection: POST /collection/:id/image/:imageID/remove
+func (c *Collection) RemoveImage(w http.ResponseWriter, r *http.Request) {
+	collection, err := c.ownCollectionByID(w, r)
+	if err != nil {
+		return
+	}
+	imageID, err := strconv.Atoi(mux.Vars(r)["imageID"])
+	if err != nil {
+		view.Error(w, r, "Invalid image ID", http.StatusNotFound)
+		return
+	}
+
+	if err := c.cs.RemoveImage(collection.ID, uint(imageID)); err != nil {
+		var vd view.Data
+		vd.SetAlert(err)
+		c.renderEdit(w, r, collection, vd)
+		return
+	}
+	c.redirect(w, r, EditCollection, collection, view.Alert{
+		Level:   view.AlertLvlSuccess,
+		Message: "Image removed from the collection!",
+	})
+}
+
+// Reorder sets the order of a collection's images from the comma separated ids field: POST /collection/:id/images/order
+func (c *Collection) Reorder(w http.ResponseWriter, r *http.Request) {
+	collection, err := c.ownCollectionByID(w, r)
+	if err != nil {
+		return
+	}
+
+	var vd view.Data
+	if err := r.ParseForm(); err != nil {
+		vd.SetAlert(err)
+		c.renderEdit(w, r, collection, vd)
+		return
+	}
+	ids, ok := parseOrder(r.PostForm.Get("ids"))
+	if !ok {
+		vd.SetAlert(model.ErrCollectionOrderInvalid)
+		c.renderEdit(w, r, collection, vd)
+		return
+	}
+	if err := c.cs.Reorder(collection.ID, ids); err != nil {
+		vd.SetAlert(err)
+		c.renderEdit(w, r, collection, vd)
+		return
+	}
+
+	c.redirect(w, r, EditCollection, collection, view.Alert{
+		Level:   view.AlertLvlSuccess,
+		Message: "Images successfully reordered!",
+	})
+}
+
+// page loads the collection's images visible to the current user
+func (c *Collection) page(r *http.Request, collection *model.Collection) (*CollectionPage, error) {
+	var viewerID uint
+	if user := context.User(r.Context()); user != nil {
+		viewerID = user.ID
+	}
+	images, err := c.cs.Images(collection.ID, viewerID)
+	if err != nil {
+		return nil, err
+	}
+	return &CollectionPage{
+		Collection: collection,
+		Images:     images,
+	}, nil
+}
+
+// renderEdit renders the edit page for collection with the alert in vd
+func (c *Collection) renderEdit(w http.ResponseWriter, r *http.Request, collection *model.Collection, vd view.Data) {
+	page, err := c.page(r, collection)
+	if err != nil {
+		view.Error(w, r, "Uh oh! something went wrong", http.StatusInternalServerError)
+		return
+	}
+	vd.Yield = page
+	c.EditView.Render(w, r, vd)
+}
+
+// redirect sends the user to the named collection route with a flash
+func (c *Collection) redirect(w http.ResponseWriter, r *http.Request, name string, collection *model.Collection, alert view.Alert) {
+	url, err := c.r.Get(name).URL("id", strconv.Itoa(int(collection.ID)))
+	if err != nil {
+		http.Redirect(w, r, "/", http.StatusFound)
+		return
+	}
+	view.RedirectAlert(w, r, url.Path, http.StatusFound, alert)
+}
+
+// ownCollectionByID is collectionByID limited to the signed in user's
+// collections; others are not found, so their existence does not leak
+func (c *Collection) ownCollectionByID(w http.ResponseWriter, r *http.Request) (*model.Collection, error) {
+	collection, err := c.collectionByID(w, r)
+	if err != nil {
+		return nil, err
+	}
+	user := context.User(r.Context())
+	if collection.UserID != user.ID {
+		view.Error(w, r, "Collection not found", http.StatusNotFound)
+		return nil, model.ErrNotFound
+	}
+	return collection, nil
+}
+
+func (c *Collection) collectionByID(w http.ResponseWriter, r *http.Request) (*model.Collection, error) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		view.Error(w, r, "Invalid collection ID", http.StatusNotFound)
+		return nil, err
+	}
+
+	collection, err := c.cs.ByID(uint(id))
+	if err != nil {
+		switch err {
+		case model.ErrNotFound:
+			view.Error(w, r, "Collection not found", http.StatusNotFound)
+		default:
+			view.Error(w, r, "Uh oh! something went wrong", http.StatusInternalServerError)
+		}
+		return nil, err
+	}
+	return collection, nil
+}
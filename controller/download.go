@@ -0,0 +1,189 @@
+package controller
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jhampac/picha/model"
+	"github.com/jhampac/picha/view"
+)
+
+// manifestName is the file in every download describing its images
+const manifestName = "manifest.json"
+
+// stallTimeout is how long a download waits on a client that stopped reading
+const stallTimeout = time.Minute
+
+// ManifestEntry describes one image of a gallery download
+type ManifestEntry struct {
+	File    string   `json:"file"`
+	Title   string   `json:"title,omitempty"`
+	Caption string   `json:"caption,omitempty"`
+	Alt     string   `json:"alt,omitempty"`
+	Tags    []string `json:"tags,omitempty"`
+	Width   int      `json:"width"`
+	Height  int      `json:"height"`
+}
+
+// Download streams a ZIP of the gallery's originals, or with ?w= the variants
+// of that width: GET /gallery/:id/download
+func (g *Gallery) Download(w http.ResponseWriter, r *http.Request) {
+	gallery, err := g.galleryFor(w, r, model.ActionView)
+	if err != nil {
+		return
+	}
+	g.writeZip(w, r, gallery)
+}
+
+// ShareDownload is Download through an unlocked share link: GET /s/:token/download
+func (g *Gallery) ShareDownload(w http.ResponseWriter, r *http.Request) {
+	link, err := g.shareLink(w, r)
+	if err != nil {
+		return
+	}
+	if !g.shareUnlocked(r, link) {
+		view.Error(w, r, "Link not found", http.StatusNotFound)
+		return
+	}
+	gallery, err := g.gs.ByID(link.GalleryID)
+	if err != nil {
+		g.shareError(w, r, err)
+		return
+	}
+	g.writeZip(w, r, gallery)
+}
+
+// writeZip loads everything it needs from the database up front, so no query
+// or transaction stays open while the files are streamed. Entries are stored
+// uncompressed since the images already are compressed.
+func (g *Gallery) writeZip(w http.ResponseWriter, r *http.Request, gallery *model.Gallery) {
+	width, _ := strconv.Atoi(r.URL.Query().Get("w"))
+	images, err := g.is.ByGalleryID(gallery.ID)
+	if err != nil {
+		view.Error(w, r, "Uh oh! something went wrong", http.StatusInternalServerError)
+		return
+	}
+	tags, err := g.ts.ImageTagsByGalleryID(gallery.ID)
+	if err != nil {
+		view.Error(w, r, "Uh oh! something went wrong", http.StatusInternalServerError)
+		return
+	}
+	manifest := make([]ManifestEntry, len(images))
+	for i := range images {
+		manifest[i] = zipEntry(i, &images[i], width)
+		manifest[i].Tags = tagNames(tags[images[i].ID])
+	}
+
+	name := strings.TrimSpace(gallery.Title)
+	if name == "" {
+		name = "gallery"
+	}
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": name + ".zip"}))
+	w.Header().Set("Cache-Control", "private, no-store")
+
+	// the status is sent with the first byte, so failures from here on can
+	// only abort the response; the client sees an incomplete download
+	rc := http.NewResponseController(w)
+	defer rc.SetWriteDeadline(time.Time{})
+	zw := zip.NewWriter(&stallWriter{w: w, rc: rc})
+	for i := range images {
+		if err := g.addToZip(zw, &images[i], manifest[i].File, width); err != nil {
+			slog.ErrorContext(r.Context(), "writing gallery zip", "gallery_id", gallery.ID, "image_id", images[i].ID, "error", err)
+			panic(http.ErrAbortHandler)
+		}
+	}
+	mw, err := zw.CreateHeader(&zip.FileHeader{
+		Name:     manifestName,
+		Method:   zip.Deflate,
+		Modified: time.Now(),
+	})
+	if err == nil {
+		enc := json.NewEncoder(mw)
+		enc.SetIndent("", "  ")
+		err = enc.Encode(manifest)
+	}
+	if err == nil {
+		err = zw.Close()
+	}
+	if err != nil {
+		slog.ErrorContext(r.Context(), "writing gallery zip", "gallery_id", gallery.ID, "error", err)
+		panic(http.ErrAbortHandler)
+	}
+}
+
+func (g *Gallery) addToZip(zw *zip.Writer, image *model.Image, name string, width int) error {
+	rc, err := g.is.Open(image, width)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
+	fw, err := zw.CreateHeader(&zip.FileHeader{
+		Name:     name,
+		Method:   zip.Store,
+		Modified: image.CreatedAt,
+	})
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(fw, rc)
+	return err
+}
+
+// stallWriter moves the write deadline forward before every write, so a
+// download may take as long as the client keeps reading it
+type stallWriter struct {
+	w  io.Writer
+	rc *http.ResponseController
+}
+
+func (sw *stallWriter) Write(b []byte) (int, error) {
+	if err := sw.rc.SetWriteDeadline(time.Now().Add(stallTimeout)); err != nil && !errors.Is(err, http.ErrNotSupported) {
+		return 0, err
+	}
+	return sw.w.Write(b)
+}
+
+// zipEntry names the i-th image so the files sort in gallery order and never
+// collide; variants are always JPEG
+func zipEntry(i int, image *model.Image, width int) ManifestEntry {
+	base := path.Base(strings.ReplaceAll(image.Filename, "\\", "/"))
+	ext := path.Ext(image.Key)
+	entry := ManifestEntry{
+		Title:   image.Title,
+		Caption: image.Caption,
+		Alt:     image.Alt,
+		Width:   image.Width,
+		Height:  image.Height,
+	}
+	if w := image.VariantWidth(width); w > 0 {
+		ext = ".jpg"
+		entry.Width = w
+		entry.Height = image.Height * w / image.Width
+	}
+	base = strings.TrimSuffix(base, path.Ext(base))
+	if base == "" || base == "." || base == "/" {
+		base = "image"
+	}
+	entry.File = fmt.Sprintf("%03d-%s%s", i+1, base, ext)
+	return entry
+}
+
+func tagNames(tags []model.Tag) []string {
+	names := make([]string, len(tags))
+	for i, tag := range tags {
+		names[i] = tag.Name
+	}
+	return names
+}
@@ -1,43 +1,140 @@
 package controller
 
 import (
-	"fmt"
 	"net/http"
 	"strconv"
+	"strings"
 
 	"github.com/gorilla/mux"
 	"github.com/jhampac/picha/context"
+	"github.com/jhampac/picha/metrics"
 	"github.com/jhampac/picha/model"
 	"github.com/jhampac/picha/view"
 )
 
 const (
-	ShowGallery = "show_gallery"
+	IndexGalleries = "index_galleries"
+	ShowGallery    = "show_gallery"
+	EditGallery    = "edit_gallery"
+	ShowImage      = "show_image"
 )
 
 // Gallery controller for all related resources
 type Gallery struct {
-	NewView  *view.View
-	ShowView *view.View
-	EditView *view.View
-	gs       model.GalleryService
-	r        *mux.Router
+	NewView    *view.View
+	ShowView   *view.View
+	EditView   *view.View
+	DeleteView *view.View
+	IndexView  *view.View
+	ImageView  *view.View
+	ShareView  *view.View
+	LockView   *view.View
+	gs         model.GalleryService
+	is         model.ImageService
+	ts         model.TagService
+	cs         model.CollectionService
+	ms         model.MemberService
+	ss         model.ShareLinkService
+	r          *mux.Router
 }
 
 // NewGallery instantiates a new controller for the gallery resource
-func NewGallery(gs model.GalleryService, r *mux.Router) *Gallery {
+func NewGallery(gs model.GalleryService, is model.ImageService, ts model.TagService, cs model.CollectionService, ms model.MemberService, ss model.ShareLinkService, r *mux.Router) *Gallery {
 	return &Gallery{
-		NewView:  view.New("appcontainer", "gallery/new"),
-		ShowView: view.New("appcontainer", "gallery/show"),
-		EditView: view.New("appcontainer", "gallery/edit"),
-		gs:       gs,
-		r:        r,
+		NewView:    view.New("appcontainer", "gallery/new"),
+		ShowView:   view.New("appcontainer", "gallery/show"),
+		EditView:   view.New("appcontainer", "gallery/edit"),
+		DeleteView: view.New("appcontainer", "gallery/delete"),
+		IndexView:  view.New("appcontainer", "gallery/index"),
+		ImageView:  view.New("appcontainer", "gallery/image"),
+		ShareView:  view.New("appcontainer", "share/show"),
+		LockView:   view.New("appcontainer", "share/unlock"),
+		gs:         gs,
+		is:         is,
+		ts:         ts,
+		cs:         cs,
+		ms:         ms,
+		ss:         ss,
+		r:          r,
 	}
 }
 
 // GalleryForm represents the data parsed from the form body
 type GalleryForm struct {
-	Title string `schema:"title"`
+	Title        string `schema:"title"`
+	Description  string `schema:"description"`
+	CoverImageID uint   `schema:"cover_image_id"`
+	Visibility   string `schema:"visibility"`
+	Tags         string `schema:"tags"`
+}
+
+// GalleryPage is yielded to the show and edit templates
+type GalleryPage struct {
+	*model.Gallery
+	Images []model.Image
+	Tags   []model.Tag
+
+	// TagInput fills the tags field: the saved tags, or what was submitted when re-rendering a form
+	TagInput string
+
+	// Role is the visitor's role in the gallery; it decides which controls are shown
+	Role model.Role
+
+	// ShareLinks are listed on the edit page for the owner
+	ShareLinks []model.ShareLink
+
+	// ShareInput refills the share link form when it is re-rendered
+	ShareInput ShareForm
+}
+
+// CanUpload reports whether the visitor may add images
+func (p *GalleryPage) CanUpload() bool { return p.Role.Can(model.ActionUpload) }
+
+// CanEdit reports whether the visitor may change the details, order and images
+func (p *GalleryPage) CanEdit() bool { return p.Role.Can(model.ActionEdit) }
+
+// CanManage reports whether the visitor may share and delete the gallery
+func (p *GalleryPage) CanManage() bool { return p.Role.Can(model.ActionManage) }
+
+// MoveOrder is the comma separated image order with image i moved by delta,
+// posted by the up and down buttons; empty when it cannot move that way
+func (p *GalleryPage) MoveOrder(i, delta int) string {
+	return moveOrder(p.Images, i, delta)
+}
+
+func moveOrder(images []model.Image, i, delta int) string {
+	j := i + delta
+	if i < 0 || j < 0 || i >= len(images) || j >= len(images) {
+		return ""
+	}
+	ids := make([]string, len(images))
+	for k, image := range images {
+		ids[k] = strconv.Itoa(int(image.ID))
+	}
+	ids[i], ids[j] = ids[j], ids[i]
+	return strings.Join(ids, ",")
+}
+
+// parseOrder reads the comma separated ids posted by the reorder forms
+func parseOrder(s string) ([]uint, bool) {
+	var ids []uint
+	for _, part := range strings.Split(s, ",") {
+		id, err := strconv.ParseUint(strings.TrimSpace(part), 10, 64)
+		if err != nil {
+			return nil, false
+		}
+		ids = append(ids, uint(id))
+	}
+	return ids, true
+}
+
+// GalleryList is yielded to the index template
+type GalleryList struct {
+	Galleries []model.Gallery
+
+	// Shared are other people's galleries the user is a member of
+	Shared []model.Gallery
+	Covers map[uint]*model.Image
 }
 
 // Create parses the form body and create an new gallery
@@ -45,22 +142,34 @@ func (g *Gallery) Create(w http.ResponseWriter, r *http.Request) {
 	var vd view.Data
 	var form GalleryForm
 
+	// re-render the form with what was submitted on errors
+	vd.Yield = &form
 	if err := parseForm(&form, r); err != nil {
 		vd.SetAlert(err)
-		g.NewView.Render(w, vd)
+		g.NewView.Render(w, r, vd)
 		return
 	}
 
 	user := context.User(r.Context())
 
 	gallery := model.Gallery{
-		Title:  form.Title,
-		UserID: user.ID,
+		Title:       form.Title,
+		Description: form.Description,
+		Visibility:  form.Visibility,
+		UserID:      user.ID,
 	}
 
 	if err := g.gs.Create(&gallery); err != nil {
 		vd.SetAlert(err)
-		g.NewView.Render(w, vd)
+		g.NewView.Render(w, r, vd)
+		return
+	}
+	metrics.GalleriesCreated.Inc()
+
+	// the gallery exists at this point, so bad tags send the owner to the edit page to fix them
+	if _, err := g.ts.SetGalleryTags(gallery.ID, model.ParseTags(form.Tags)); err != nil {
+		vd.SetAlert(err)
+		g.renderEdit(w, r, &gallery, vd)
 		return
 	}
 
@@ -69,83 +178,144 @@ func (g *Gallery) Create(w http.ResponseWriter, r *http.Request) {
 		http.Redirect(w, r, "/", http.StatusFound)
 		return
 	}
-	http.Redirect(w, r, url.Path, http.StatusFound)
+	view.RedirectAlert(w, r, url.Path, http.StatusFound, view.Alert{
+		Level:   view.AlertLvlSuccess,
+		Message: "Gallery successfully created!",
+	})
 }
 
-// Show will display a gallery that matches the provided ID
-func (g *Gallery) Show(w http.ResponseWriter, r *http.Request) {
-	gallery, err := g.galleryByID(w, r)
+// Index lists the galleries of the signed in user and those shared with them: GET /gallery
+func (g *Gallery) Index(w http.ResponseWriter, r *http.Request) {
+	user := context.User(r.Context())
+	galleries, err := g.gs.ByUserID(user.ID)
 	if err != nil {
+		view.Error(w, r, "Uh oh! something went wrong", http.StatusInternalServerError)
+		return
+	}
+	shared, err := g.ms.SharedWith(user.ID)
+	if err != nil {
+		view.Error(w, r, "Uh oh! something went wrong", http.StatusInternalServerError)
+		return
+	}
+	covers, err := g.is.Covers(append(append([]model.Gallery{}, galleries...), shared...))
+	if err != nil {
+		view.Error(w, r, "Uh oh! something went wrong", http.StatusInternalServerError)
 		return
 	}
 	var vd view.Data
-	vd.Yield = gallery
-	g.ShowView.Render(w, vd)
+	vd.Yield = GalleryList{
+		Galleries: galleries,
+		Shared:    shared,
+		Covers:    covers,
+	}
+	g.IndexView.Render(w, r, vd)
 }
 
-// Edit a users gallery
-func (g *Gallery) Edit(w http.ResponseWriter, r *http.Request) {
-	gallery, err := g.galleryByID(w, r)
+// Show will display a gallery that matches the provided ID
+func (g *Gallery) Show(w http.ResponseWriter, r *http.Request) {
+	gallery, err := g.galleryFor(w, r, model.ActionView)
 	if err != nil {
 		return
 	}
-	user := context.User(r.Context())
-	if gallery.UserID != user.ID {
-		http.Error(w, "You do not have permissions to edit this gallery", http.StatusForbidden)
+	page, err := g.page(r, gallery)
+	if err != nil {
+		view.Error(w, r, "Uh oh! something went wrong", http.StatusInternalServerError)
 		return
 	}
 	var vd view.Data
-	vd.Yield = gallery
-	g.EditView.Render(w, vd)
+	vd.Yield = page
+	g.ShowView.Render(w, r, vd)
 }
 
-// Update a gallery resource: POST /gallery/:id/update
-func (g *Gallery) Update(w http.ResponseWriter, r *http.Request) {
-	// retrieve the gallery by ID and the user from the context
-	gallery, err := g.galleryByID(w, r)
+// Edit a gallery; contributors only get the upload form
+func (g *Gallery) Edit(w http.ResponseWriter, r *http.Request) {
+	gallery, err := g.galleryFor(w, r, model.ActionUpload)
 	if err != nil {
 		return
 	}
-	user := context.User(r.Context())
-	if gallery.UserID != user.ID {
-		http.Error(w, "Gallery not found", http.StatusNotFound)
+	g.renderEdit(w, r, gallery, view.Data{})
+}
+
+// Update a gallery resource: POST /gallery/:id/update
+func (g *Gallery) Update(w http.ResponseWriter, r *http.Request) {
+	// retrieve the gallery by ID if the user may edit it
+	gallery, err := g.galleryFor(w, r, model.ActionEdit)
+	if err != nil {
 		return
 	}
 
 	// parse form from the edit POST call
 	var vd view.Data
-	vd.Yield = gallery
 	var form GalleryForm
 	if err := parseForm(&form, r); err != nil {
 		vd.SetAlert(err)
-		g.EditView.Render(w, vd)
+		g.renderEdit(w, r, gallery, vd)
 		return
 	}
 
-	// update the gallery
+	// the cover has to be one of this gallery's images
+	if form.CoverImageID != 0 {
+		image, err := g.is.ByID(form.CoverImageID)
+		if err != nil || image.GalleryID != gallery.ID {
+			vd.AlertError("The cover must be an image of this gallery")
+			g.renderEdit(w, r, gallery, vd)
+			return
+		}
+	}
+
+	// update the gallery, then its tags
 	gallery.Title = form.Title
-	err = g.gs.Update(gallery)
-	if err != nil {
+	gallery.Description = form.Description
+	gallery.CoverImageID = form.CoverImageID
+	gallery.Visibility = form.Visibility
+	if err := g.gs.Update(gallery); err != nil {
 		vd.SetAlert(err)
-	} else {
-		vd.Alert = &view.Alert{
-			Level:   view.AlertLvlSuccess,
-			Message: "Gallery successfully updated!",
-		}
+		g.renderEdit(w, r, gallery, vd)
+		return
 	}
-	g.EditView.Render(w, vd)
+	if _, err := g.ts.SetGalleryTags(gallery.ID, model.ParseTags(form.Tags)); err != nil {
+		vd.SetAlert(err)
+		g.renderEdit(w, r, gallery, vd)
+		return
+	}
+
+	g.redirectEdit(w, r, gallery, view.Alert{
+		Level:   view.AlertLvlSuccess,
+		Message: "Gallery successfully updated!",
+	})
 }
 
-// Delete a gallery resource: POST /gallery/:id/delete
-func (g *Gallery) Delete(w http.ResponseWriter, r *http.Request) {
-	gallery, err := g.galleryByID(w, r)
+// GalleryDeletion is yielded to the delete confirmation template
+type GalleryDeletion struct {
+	*model.Gallery
+	ImageCount int
+}
+
+// ConfirmDelete asks before moving a gallery to the trash: GET /gallery/:id/delete
+func (g *Gallery) ConfirmDelete(w http.ResponseWriter, r *http.Request) {
+	gallery, err := g.galleryFor(w, r, model.ActionManage)
 	if err != nil {
 		return
 	}
+	images, err := g.is.ByGalleryID(gallery.ID)
+	if err != nil {
+		view.Error(w, r, "Uh oh! something went wrong", http.StatusInternalServerError)
+		return
+	}
 
-	user := context.User(r.Context())
-	if gallery.UserID != user.ID {
-		http.Error(w, "You do not have permission to edit this gallery", http.StatusForbidden)
+	var vd view.Data
+	vd.Yield = GalleryDeletion{
+		Gallery:    gallery,
+		ImageCount: len(images),
+	}
+	g.DeleteView.Render(w, r, vd)
+}
+
+// Delete moves a gallery to the trash; the alert on the gallery list can
+// undo it for a while: POST /gallery/:id/delete
+func (g *Gallery) Delete(w http.ResponseWriter, r *http.Request) {
+	gallery, err := g.galleryFor(w, r, model.ActionManage)
+	if err != nil {
 		return
 	}
 
@@ -153,11 +323,93 @@ func (g *Gallery) Delete(w http.ResponseWriter, r *http.Request) {
 	err = g.gs.Delete(gallery.ID)
 	if err != nil {
 		vd.SetAlert(err)
-		vd.Yield = gallery
-		g.EditView.Render(w, vd)
+		g.renderEdit(w, r, gallery, vd)
+		return
 	}
 
-	fmt.Fprintln(w, "successfully deleted!")
+	url, err := g.r.Get(IndexGalleries).URL()
+	if err != nil {
+		http.Redirect(w, r, "/", http.StatusFound)
+		return
+	}
+	alert := view.Alert{
+		Level:   view.AlertLvlSuccess,
+		Message: "Gallery %q moved to the trash",
+		Args:    []interface{}{gallery.Title},
+	}
+	if undo, err := g.r.Get(UndoDeleteGallery).URL("id", strconv.Itoa(int(gallery.ID))); err == nil {
+		alert.Action = &view.AlertAction{Label: "Undo", URL: undo.Path}
+	}
+	view.RedirectAlert(w, r, url.Path, http.StatusFound, alert)
+}
+
+// page loads the gallery's images in order, its tags and the visitor's role
+func (g *Gallery) page(r *http.Request, gallery *model.Gallery) (*GalleryPage, error) {
+	role, err := g.ms.RoleOf(context.User(r.Context()), gallery)
+	if err != nil {
+		return nil, err
+	}
+	images, err := g.is.ByGalleryID(gallery.ID)
+	if err != nil {
+		return nil, err
+	}
+	tags, err := g.ts.ByGalleryID(gallery.ID)
+	if err != nil {
+		return nil, err
+	}
+	page := &GalleryPage{
+		Gallery:  gallery,
+		Images:   images,
+		Tags:     tags,
+		TagInput: model.JoinTags(tags),
+		Role:     role,
+	}
+	if page.CanManage() {
+		page.ShareLinks, err = g.ss.ByGalleryID(gallery.ID)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return page, nil
+}
+
+// renderEdit renders the edit page for gallery with the alert in vd; a
+// submitted tags field is shown again as typed
+func (g *Gallery) renderEdit(w http.ResponseWriter, r *http.Request, gallery *model.Gallery, vd view.Data) {
+	page, err := g.page(r, gallery)
+	if err != nil {
+		view.Error(w, r, "Uh oh! something went wrong", http.StatusInternalServerError)
+		return
+	}
+	if tags, ok := r.PostForm["tags"]; ok {
+		page.TagInput = tags[0]
+	}
+	page.ShareInput.ExpiresOn = r.PostForm.Get("expires_on")
+	page.ShareInput.MaxViews, _ = strconv.Atoi(r.PostForm.Get("max_views"))
+	vd.Yield = page
+	g.EditView.Render(w, r, vd)
+}
+
+// galleryFor is galleryByID limited to visitors the member service allows to
+// do action; galleries they cannot see are reported as not found
+func (g *Gallery) galleryFor(w http.ResponseWriter, r *http.Request, action model.Action) (*model.Gallery, error) {
+	gallery, err := g.galleryByID(w, r)
+	if err != nil {
+		return nil, err
+	}
+	err = g.ms.Authorize(context.User(r.Context()), gallery, action)
+	if err != nil {
+		switch err {
+		case model.ErrNotFound:
+			view.Error(w, r, "Gallery not found", http.StatusNotFound)
+		case model.ErrForbidden:
+			view.Error(w, r, "You do not have permission to do that in this gallery", http.StatusForbidden)
+		default:
+			view.Error(w, r, "Uh oh! something went wrong", http.StatusInternalServerError)
+		}
+		return nil, err
+	}
+	return gallery, nil
 }
 
 func (g *Gallery) galleryByID(w http.ResponseWriter, r *http.Request) (*model.Gallery, error) {
@@ -165,7 +417,7 @@ func (g *Gallery) galleryByID(w http.ResponseWriter, r *http.Request) (*model.Ga
 	idStr := vars["id"]
 	id, err := strconv.Atoi(idStr)
 	if err != nil {
-		http.Error(w, "Invalid gallery ID", http.StatusNotFound)
+		view.Error(w, r, "Invalid gallery ID", http.StatusNotFound)
 		return nil, err
 	}
 
@@ -173,9 +425,9 @@ func (g *Gallery) galleryByID(w http.ResponseWriter, r *http.Request) (*model.Ga
 	if err != nil {
 		switch err {
 		case model.ErrNotFound:
-			http.Error(w, "Gallery not found", http.StatusNotFound)
+			view.Error(w, r, "Gallery not found", http.StatusNotFound)
 		default:
-			http.Error(w, "Uh oh! something went wrong", http.StatusInternalServerError)
+			view.Error(w, r, "Uh oh! something went wrong", http.StatusInternalServerError)
 		}
 		return nil, err
 	}
@@ -0,0 +1,76 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+// checkTimeout bounds each readiness check so a hung dependency fails fast
+const checkTimeout = 2 * time.Second
+
+// HealthCheck is a named dependency probed by the readiness endpoint
+type HealthCheck struct {
+	Name  string
+	Check func(ctx context.Context) error
+}
+
+// Health serves the liveness and readiness endpoints for the load balancer
+type Health struct {
+	checks []HealthCheck
+}
+
+// NewHealth instantiates a *Health controller that runs checks on every readiness probe
+func NewHealth(checks ...HealthCheck) *Health {
+	return &Health{
+		checks: checks,
+	}
+}
+
+type healthResponse struct {
+	Status string `json:"status"`
+
+	// Checks maps each check's name to "ok" or "fail"; why one failed is only
+	// logged, since the endpoint is public
+	Checks map[string]string `json:"checks,omitempty"`
+}
+
+// Live reports the process is up: GET /healthz
+func (h *Health) Live(w http.ResponseWriter, r *http.Request) {
+	writeHealth(w, http.StatusOK, healthResponse{Status: "ok"})
+}
+
+// Ready runs every check and reports each one's status: GET /readyz
+func (h *Health) Ready(w http.ResponseWriter, r *http.Request) {
+	res := healthResponse{
+		Status: "ok",
+		Checks: make(map[string]string, len(h.checks)),
+	}
+	status := http.StatusOK
+
+	for _, c := range h.checks {
+		ctx, cancel := context.WithTimeout(r.Context(), checkTimeout)
+		start := time.Now()
+		err := c.Check(ctx)
+		cancel()
+
+		res.Checks[c.Name] = "ok"
+		if err != nil {
+			slog.ErrorContext(r.Context(), "readiness check failed", "check", c.Name,
+				"latency_ms", float64(time.Since(start).Microseconds())/1000, "error", err)
+			res.Checks[c.Name] = "fail"
+			res.Status = "fail"
+			status = http.StatusServiceUnavailable
+		}
+	}
+	writeHealth(w, status, res)
+}
+
+func writeHealth(w http.ResponseWriter, status int, res healthResponse) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(res)
+}
@@ -0,0 +1,387 @@
+package controller
+
+import (
+	"io"
+	"log/slog"
+	"mime"
+	"net/http"
+	"path"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	"github.com/jhampac/picha/context"
+	"github.com/jhampac/picha/metrics"
+	"github.com/jhampac/picha/model"
+	"github.com/jhampac/picha/view"
+)
+
+const (
+	// maxUploadBytes caps the size of one upload request
+	maxUploadBytes = 64 << 20
+
+	// maxMultipartMemory is how much of an upload is held in memory before spilling to disk
+	maxMultipartMemory = 1 << 20
+)
+
+// ImageForm represents the image details parsed from the form body
+type ImageForm struct {
+	Title   string `schema:"title"`
+	Caption string `schema:"caption"`
+	Alt     string `schema:"alt"`
+	Tags    string `schema:"tags"`
+}
+
+// ImagePage is yielded to the per-image template
+type ImagePage struct {
+	Gallery *model.Gallery
+	Image   *model.Image
+	Tags    []model.Tag
+
+	// TagInput fills the tags field: the saved tags, or what was submitted when re-rendering the form
+	TagInput string
+
+	// Prev and Next are the neighbours within the gallery, nil at either end
+	Prev *model.Image
+	Next *model.Image
+
+	// Number is the 1-based position of the image out of Total
+	Number int
+	Total  int
+
+	// Role is the visitor's role in the gallery
+	Role model.Role
+
+	// SignedIn visitors can add the image to their Collections
+	SignedIn    bool
+	Collections []model.Collection
+}
+
+// CanEdit reports whether the visitor may change the image details
+func (p *ImagePage) CanEdit() bool { return p.Role.Can(model.ActionEdit) }
+
+// Image is the permalink page of one image: GET /gallery/:id/image/:imageID
+func (g *Gallery) Image(w http.ResponseWriter, r *http.Request) {
+	gallery, err := g.galleryFor(w, r, model.ActionView)
+	if err != nil {
+		return
+	}
+	image, err := g.imageByID(w, r, gallery)
+	if err != nil {
+		return
+	}
+	g.renderImage(w, r, gallery, image, view.Data{})
+}
+
+// UpdateImage saves the title, caption and alt text of an image: POST /gallery/:id/image/:imageID/update
+func (g *Gallery) UpdateImage(w http.ResponseWriter, r *http.Request) {
+	gallery, err := g.galleryFor(w, r, model.ActionEdit)
+	if err != nil {
+		return
+	}
+	image, err := g.imageByID(w, r, gallery)
+	if err != nil {
+		return
+	}
+
+	var vd view.Data
+	var form ImageForm
+	if err := parseForm(&form, r); err != nil {
+		vd.SetAlert(err)
+		g.renderImage(w, r, gallery, image, vd)
+		return
+	}
+
+	// re-render with what was submitted on errors
+	image.Title = form.Title
+	image.Caption = form.Caption
+	image.Alt = form.Alt
+	if err := g.is.Update(image); err != nil {
+		vd.SetAlert(err)
+		g.renderImage(w, r, gallery, image, vd)
+		return
+	}
+	if _, err := g.ts.SetImageTags(image.ID, model.ParseTags(form.Tags)); err != nil {
+		vd.SetAlert(err)
+		g.renderImage(w, r, gallery, image, vd)
+		return
+	}
+
+	url, err := g.r.Get(ShowImage).URL("id", strconv.Itoa(int(gallery.ID)), "imageID", strconv.Itoa(int(image.ID)))
+	if err != nil {
+		http.Redirect(w, r, "/", http.StatusFound)
+		return
+	}
+	view.RedirectAlert(w, r, url.Path, http.StatusFound, view.Alert{
+		Level:   view.AlertLvlSuccess,
+		Message: "Image successfully updated!",
+	})
+}
+
+// renderImage renders the permalink page with image's neighbours in the gallery
+// order; a submitted tags field is shown again as typed
+func (g *Gallery) renderImage(w http.ResponseWriter, r *http.Request, gallery *model.Gallery, image *model.Image, vd view.Data) {
+	images, err := g.is.ByGalleryID(gallery.ID)
+	if err != nil {
+		view.Error(w, r, "Uh oh! something went wrong", http.StatusInternalServerError)
+		return
+	}
+	tags, err := g.ts.ByImageID(image.ID)
+	if err != nil {
+		view.Error(w, r, "Uh oh! something went wrong", http.StatusInternalServerError)
+		return
+	}
+
+	page := ImagePage{
+		Gallery:  gallery,
+		Image:    image,
+		Tags:     tags,
+		TagInput: model.JoinTags(tags),
+		Total:    len(images),
+	}
+	if tags, ok := r.PostForm["tags"]; ok {
+		page.TagInput = tags[0]
+	}
+	for i := range images {
+		if images[i].ID != image.ID {
+			continue
+		}
+		page.Number = i + 1
+		if i > 0 {
+			page.Prev = &images[i-1]
+		}
+		if i < len(images)-1 {
+			page.Next = &images[i+1]
+		}
+	}
+	user := context.User(r.Context())
+	page.Role, err = g.ms.RoleOf(user, gallery)
+	if err != nil {
+		view.Error(w, r, "Uh oh! something went wrong", http.StatusInternalServerError)
+		return
+	}
+	if user != nil {
+		page.SignedIn = true
+		page.Collections, err = g.cs.ByUserID(user.ID)
+		if err != nil {
+			view.Error(w, r, "Uh oh! something went wrong", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	vd.Yield = &page
+	g.ImageView.Render(w, r, vd)
+}
+
+// Upload adds images to a gallery: POST /gallery/:id/images
+func (g *Gallery) Upload(w http.ResponseWriter, r *http.Request) {
+	gallery, err := g.galleryFor(w, r, model.ActionUpload)
+	if err != nil {
+		return
+	}
+
+	var vd view.Data
+	r.Body = http.MaxBytesReader(w, r.Body, maxUploadBytes)
+	if err := r.ParseMultipartForm(maxMultipartMemory); err != nil {
+		vd.AlertError("The upload could not be read, please try fewer or smaller images")
+		g.renderEdit(w, r, gallery, vd)
+		return
+	}
+
+	files := r.MultipartForm.File["images"]
+	if len(files) == 0 {
+		vd.AlertError("Please choose at least one image to upload")
+		g.renderEdit(w, r, gallery, vd)
+		return
+	}
+	user := context.User(r.Context())
+	var duplicates []interface{}
+	var duplicateCount int
+	for _, fh := range files {
+		file, err := fh.Open()
+		if err != nil {
+			vd.SetAlert(err)
+			g.renderEdit(w, r, gallery, vd)
+			return
+		}
+		image, err := g.is.Upload(gallery.ID, fh.Filename, file)
+		file.Close()
+		if err != nil {
+			vd.SetAlert(err)
+			g.renderEdit(w, r, gallery, vd)
+			return
+		}
+		metrics.Upload(image.Size)
+
+		// the file is stored once either way, but the uploader may not know they have it already
+		galleries, err := g.is.Duplicates(image, user.ID)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "finding duplicate images", "image_id", image.ID, "error", err)
+		}
+		if len(galleries) > 0 {
+			if len(duplicates) == 0 {
+				duplicates = []interface{}{image.Filename, galleries[0].Title}
+			}
+			duplicateCount++
+		}
+	}
+
+	alert := view.Alert{
+		Level:   view.AlertLvlSuccess,
+		Message: "Images successfully uploaded!",
+	}
+	switch {
+	case duplicateCount == 1:
+		alert.Level = view.AlertLvlInfo
+		alert.Message = "Images successfully uploaded! %s already exists in the gallery %s."
+		alert.Args = duplicates
+	case duplicateCount > 1:
+		alert.Level = view.AlertLvlInfo
+		alert.Message = "Images successfully uploaded! %s of them already exist in your galleries, e.g. %s in %s."
+		alert.Args = append([]interface{}{strconv.Itoa(duplicateCount)}, duplicates...)
+	}
+	g.redirectEdit(w, r, gallery, alert)
+}
+
+// ImageFile serves an image, resized when ?w= asks for a smaller width: GET /gallery/:id/image/:imageID/file
+func (g *Gallery) ImageFile(w http.ResponseWriter, r *http.Request) {
+	gallery, err := g.galleryFor(w, r, model.ActionView)
+	if err != nil {
+		return
+	}
+	image, err := g.imageByID(w, r, gallery)
+	if err != nil {
+		return
+	}
+	g.serveImage(w, r, image)
+}
+
+// serveImage writes the image file, or the variant for the ?w= width
+func (g *Gallery) serveImage(w http.ResponseWriter, r *http.Request, image *model.Image) {
+	width, _ := strconv.Atoi(r.URL.Query().Get("w"))
+	rc, err := g.is.Open(image, width)
+	if err != nil {
+		view.Error(w, r, "Image not found", http.StatusNotFound)
+		return
+	}
+	defer rc.Close()
+
+	// variants are always JPEG; originals keep their format
+	contentType := "image/jpeg"
+	if image.VariantWidth(width) == 0 {
+		contentType = mime.TypeByExtension(path.Ext(image.Key))
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	if image.Pending {
+		// the original stands in for the variants until they are made
+		w.Header().Set("Cache-Control", "private, no-cache")
+	} else {
+		w.Header().Set("Cache-Control", "private, max-age=3600")
+	}
+
+	if rs, ok := rc.(io.ReadSeeker); ok {
+		http.ServeContent(w, r, image.Filename, image.UpdatedAt, rs)
+		return
+	}
+	io.Copy(w, rc)
+}
+
+// DeleteImage removes an image from a gallery: POST /gallery/:id/image/:imageID/delete
+func (g *Gallery) DeleteImage(w http.ResponseWriter, r *http.Request) {
+	gallery, err := g.galleryFor(w, r, model.ActionEdit)
+	if err != nil {
+		return
+	}
+	image, err := g.imageByID(w, r, gallery)
+	if err != nil {
+		return
+	}
+
+	var vd view.Data
+	if err := g.is.Remove(image); err != nil {
+		vd.SetAlert(err)
+		g.renderEdit(w, r, gallery, vd)
+		return
+	}
+
+	// fall back to the first image when the cover goes away
+	if gallery.CoverImageID == image.ID {
+		gallery.CoverImageID = 0
+		if err := g.gs.Update(gallery); err != nil {
+			vd.SetAlert(err)
+			g.renderEdit(w, r, gallery, vd)
+			return
+		}
+	}
+
+	g.redirectEdit(w, r, gallery, view.Alert{
+		Level:   view.AlertLvlSuccess,
+		Message: "Image %q moved to the trash",
+		Args:    []interface{}{image.Filename},
+	})
+}
+
+// Reorder sets the order of a gallery's images from the comma separated ids field: POST /gallery/:id/images/order
+func (g *Gallery) Reorder(w http.ResponseWriter, r *http.Request) {
+	gallery, err := g.galleryFor(w, r, model.ActionEdit)
+	if err != nil {
+		return
+	}
+
+	var vd view.Data
+	if err := r.ParseForm(); err != nil {
+		vd.SetAlert(err)
+		g.renderEdit(w, r, gallery, vd)
+		return
+	}
+	ids, ok := parseOrder(r.PostForm.Get("ids"))
+	if !ok {
+		vd.SetAlert(model.ErrImageOrderInvalid)
+		g.renderEdit(w, r, gallery, vd)
+		return
+	}
+
+	if err := g.is.Reorder(gallery.ID, ids); err != nil {
+		vd.SetAlert(err)
+		g.renderEdit(w, r, gallery, vd)
+		return
+	}
+
+	g.redirectEdit(w, r, gallery, view.Alert{
+		Level:   view.AlertLvlSuccess,
+		Message: "Images successfully reordered!",
+	})
+}
+
+func (g *Gallery) imageByID(w http.ResponseWriter, r *http.Request, gallery *model.Gallery) (*model.Image, error) {
+	id, err := strconv.Atoi(mux.Vars(r)["imageID"])
+	if err != nil {
+		view.Error(w, r, "Invalid image ID", http.StatusNotFound)
+		return nil, err
+	}
+
+	image, err := g.is.ByID(uint(id))
+	if err == nil && image.GalleryID != gallery.ID {
+		err = model.ErrNotFound
+	}
+	if err != nil {
+		switch err {
+		case model.ErrNotFound:
+			view.Error(w, r, "Image not found", http.StatusNotFound)
+		default:
+			view.Error(w, r, "Uh oh! something went wrong", http.StatusInternalServerError)
+		}
+		return nil, err
+	}
+	return image, nil
+}
+
+// redirectEdit sends the user back to the edit page with a flash
+func (g *Gallery) redirectEdit(w http.ResponseWriter, r *http.Request, gallery *model.Gallery, alert view.Alert) {
+	url, err := g.r.Get(EditGallery).URL("id", strconv.Itoa(int(gallery.ID)))
+	if err != nil {
+		http.Redirect(w, r, "/", http.StatusFound)
+		return
+	}
+	view.RedirectAlert(w, r, url.Path, http.StatusFound, alert)
+}
@@ -0,0 +1,205 @@
+package controller
+
+import (
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/jhampac/picha/context"
+	"github.com/jhampac/picha/importer"
+	"github.com/jhampac/picha/jobs"
+	"github.com/jhampac/picha/model"
+	"github.com/jhampac/picha/view"
+)
+
+const (
+	ShowImport = "show_import"
+
+	// maxImportBytes caps the size of an uploaded archive
+	maxImportBytes = 2 << 30
+)
+
+// Import controller for expanding ZIP archives into new galleries
+type Import struct {
+	NewView  *view.View
+	ShowView *view.View
+	gs       model.GalleryService
+	ims      model.ImportService
+	jobs     jobs.Queue
+	r        *mux.Router
+}
+
+// NewImport instantiates a new controller for imports run through the job queue
+func NewImport(gs model.GalleryService, ims model.ImportService, queue jobs.Queue, r *mux.Router) *Import {
+	return &Import{
+		NewView:  view.New("appcontainer", "import/new"),
+		ShowView: view.New("appcontainer", "import/show"),
+		gs:       gs,
+		ims:      ims,
+		jobs:     queue,
+		r:        r,
+	}
+}
+
+// ImportForm represents the text fields of the import form; the archive is read separately
+type ImportForm struct {
+	Title      string `schema:"title"`
+	Visibility string `schema:"visibility"`
+}
+
+// ImportPage is yielded to the import status template
+type ImportPage struct {
+	*model.ImportJob
+	Gallery *model.Gallery
+	Items   []model.ImportItem
+}
+
+// Create stores the uploaded archive, creates its gallery and queues the
+// import: POST /import
+func (i *Import) Create(w http.ResponseWriter, r *http.Request) {
+	var vd view.Data
+	var form ImportForm
+	vd.Yield = &form
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxImportBytes)
+	if err := r.ParseMultipartForm(maxMultipartMemory); err != nil {
+		vd.AlertError("The archive could not be read. Archives can be at most 2 GB.")
+		i.NewView.Render(w, r, vd)
+		return
+	}
+	defer r.MultipartForm.RemoveAll()
+	if err := parseForm(&form, r); err != nil {
+		vd.SetAlert(err)
+		i.NewView.Render(w, r, vd)
+		return
+	}
+	file, header, err := r.FormFile("archive")
+	if err != nil {
+		vd.AlertError("Choose a ZIP archive to import.")
+		i.NewView.Render(w, r, vd)
+		return
+	}
+	defer file.Close()
+
+	// the multipart files are removed with the request, the import outlives it;
+	// the archive is only opened here to reject files that are no ZIP at all
+	archive, err := saveTemp(file)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "saving import archive", "error", err)
+		vd.AlertError("Uh oh! something went wrong")
+		i.NewView.Render(w, r, vd)
+		return
+	}
+	src, err := importer.OpenZip(archive, importer.DefaultLimits)
+	if err != nil {
+		os.Remove(archive)
+		vd.AlertError("The file is not a valid ZIP archive.")
+		i.NewView.Render(w, r, vd)
+		return
+	}
+	src.Close()
+
+	user := context.User(r.Context())
+	if form.Title == "" {
+		form.Title = strings.TrimSuffix(path.Base(header.Filename), path.Ext(header.Filename))
+	}
+	gallery := model.Gallery{
+		UserID:     user.ID,
+		Title:      form.Title,
+		Visibility: form.Visibility,
+	}
+	if err := i.gs.Create(&gallery); err != nil {
+		os.Remove(archive)
+		vd.SetAlert(err)
+		i.NewView.Render(w, r, vd)
+		return
+	}
+	job := model.ImportJob{
+		UserID:    user.ID,
+		GalleryID: gallery.ID,
+		Source:    path.Base(header.Filename),
+	}
+	if err := i.ims.Create(&job); err != nil {
+		os.Remove(archive)
+		vd.SetAlert(err)
+		i.NewView.Render(w, r, vd)
+		return
+	}
+	if err := importer.Enqueue(r.Context(), i.jobs, &job, archive); err != nil {
+		slog.ErrorContext(r.Context(), "queueing import", "import_id", job.ID, "error", err)
+		os.Remove(archive)
+		job.Status = model.ImportFailed
+		job.Error = "the import stopped unexpectedly"
+		i.ims.Update(&job)
+	}
+
+	url, err := i.r.Get(ShowImport).URL("id", strconv.Itoa(int(job.ID)))
+	if err != nil {
+		http.Redirect(w, r, "/", http.StatusFound)
+		return
+	}
+	http.Redirect(w, r, url.Path, http.StatusFound)
+}
+
+// Show reports the progress and per-file results of an import; the page
+// reloads itself until the job is finished: GET /import/:id
+func (i *Import) Show(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		view.Error(w, r, "Import not found", http.StatusNotFound)
+		return
+	}
+	job, err := i.ims.ByID(uint(id))
+	if err == nil && job.UserID != context.User(r.Context()).ID {
+		err = model.ErrNotFound
+	}
+	if err != nil {
+		switch err {
+		case model.ErrNotFound:
+			view.Error(w, r, "Import not found", http.StatusNotFound)
+		default:
+			view.Error(w, r, "Uh oh! something went wrong", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	page := ImportPage{ImportJob: job}
+	page.Items, err = i.ims.Items(job.ID)
+	if err == nil {
+		page.Gallery, err = i.gs.ByID(job.GalleryID)
+	}
+	if err != nil && err != model.ErrNotFound {
+		view.Error(w, r, "Uh oh! something went wrong", http.StatusInternalServerError)
+		return
+	}
+
+	if !job.Finished() {
+		w.Header().Set("Refresh", "2")
+	}
+	var vd view.Data
+	vd.Yield = page
+	i.ShowView.Render(w, r, vd)
+}
+
+// saveTemp copies r to a new temporary file and returns its name
+func saveTemp(r io.Reader) (string, error) {
+	f, err := os.CreateTemp("", "picha-import-*.zip")
+	if err != nil {
+		return "", err
+	}
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return "", err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return "", err
+	}
+	return f.Name(), nil
+}
@@ -0,0 +1,96 @@
+package controller
+
+import (
+	"log/slog"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	"github.com/jhampac/picha/jobs"
+	"github.com/jhampac/picha/view"
+)
+
+const (
+	AdminJobs = "admin_jobs"
+
+	// adminJobsLimit caps each list of the jobs page
+	adminJobsLimit = 100
+)
+
+// Job controller is the admin view of the background job queue
+type Job struct {
+	IndexView *view.View
+	jobs      jobs.Queue
+	r         *mux.Router
+}
+
+// NewJob instantiates a new controller for the jobs of queue
+func NewJob(queue jobs.Queue, r *mux.Router) *Job {
+	return &Job{
+		IndexView: view.New("appcontainer", "admin/jobs"),
+		jobs:      queue,
+		r:         r,
+	}
+}
+
+// JobsPage is yielded to the admin jobs template
+type JobsPage struct {
+	Running []jobs.Job
+	Queued  []jobs.Job
+	Dead    []jobs.Job
+}
+
+// Index lists the running, queued and dead-lettered jobs: GET /admin/jobs
+func (j *Job) Index(w http.ResponseWriter, r *http.Request) {
+	var page JobsPage
+	var err error
+	lists := []struct {
+		status string
+		jobs   *[]jobs.Job
+	}{
+		{jobs.StatusRunning, &page.Running},
+		{jobs.StatusQueued, &page.Queued},
+		{jobs.StatusDead, &page.Dead},
+	}
+	for _, l := range lists {
+		if *l.jobs, err = j.jobs.List(r.Context(), l.status, adminJobsLimit); err != nil {
+			slog.ErrorContext(r.Context(), "listing jobs", "status", l.status, "error", err)
+			view.Error(w, r, "Uh oh! something went wrong", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	var vd view.Data
+	vd.Yield = page
+	j.IndexView.Render(w, r, vd)
+}
+
+// Retry queues a dead job again: POST /admin/jobs/:id/retry
+func (j *Job) Retry(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		view.Error(w, r, "Job not found", http.StatusNotFound)
+		return
+	}
+	switch err := j.jobs.Retry(r.Context(), uint(id)); err {
+	case nil:
+	case jobs.ErrNotFound:
+		view.Error(w, r, "Job not found", http.StatusNotFound)
+		return
+	default:
+		slog.ErrorContext(r.Context(), "retrying job", "job_id", id, "error", err)
+		view.Error(w, r, "Uh oh! something went wrong", http.StatusInternalServerError)
+		return
+	}
+
+	url, err := j.r.Get(AdminJobs).URL()
+	if err != nil {
+		http.Redirect(w, r, "/", http.StatusFound)
+		return
+	}
+	view.RedirectAlert(w, r, url.Path, http.StatusFound, view.Alert{
+		Level:   view.AlertLvlSuccess,
+		Message: "Job %s queued again",
+		Args:    []interface{}{strconv.Itoa(id)},
+	})
+}
@@ -0,0 +1,373 @@
+package controller
+
+import (
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/jhampac/picha/context"
+	"github.com/jhampac/picha/email"
+	"github.com/jhampac/picha/i18n"
+	"github.com/jhampac/picha/model"
+	"github.com/jhampac/picha/view"
+)
+
+const (
+	GalleryMembers = "gallery_members"
+	ShowInvitation = "show_invitation"
+)
+
+// Member controller for sharing galleries with other users
+type Member struct {
+	IndexView      *view.View
+	InvitationView *view.View
+	gs             model.GalleryService
+	ms             model.MemberService
+	mailer         email.Mailer
+	baseURL        string
+	r              *mux.Router
+}
+
+// NewMember instantiates a new controller for gallery members and
+// invitations; baseURL prefixes the links sent by email
+func NewMember(gs model.GalleryService, ms model.MemberService, mailer email.Mailer, baseURL string, r *mux.Router) *Member {
+	return &Member{
+		IndexView:      view.New("appcontainer", "member/index"),
+		InvitationView: view.New("appcontainer", "member/invitation"),
+		gs:             gs,
+		ms:             ms,
+		mailer:         mailer,
+		baseURL:        strings.TrimSuffix(baseURL, "/"),
+		r:              r,
+	}
+}
+
+// InviteForm represents the data parsed from the invite form body
+type InviteForm struct {
+	Email string `schema:"email"`
+	Role  string `schema:"role"`
+}
+
+// RoleForm represents the data parsed from a member's role form
+type RoleForm struct {
+	Role string `schema:"role"`
+}
+
+// MembersPage is yielded to the members template
+type MembersPage struct {
+	Gallery     *model.Gallery
+	Members     []model.Member
+	Invitations []model.Invitation
+	Roles       []model.Role
+
+	// Form refills the invite form when it is re-rendered
+	Form InviteForm
+}
+
+// InvitationPage is yielded to the invitation template
+type InvitationPage struct {
+	Gallery    *model.Gallery
+	Invitation *model.Invitation
+}
+
+// Index lists the members and pending invitations of a gallery: GET /gallery/:id/members
+func (m *Member) Index(w http.ResponseWriter, r *http.Request) {
+	gallery, err := m.galleryByID(w, r)
+	if err != nil {
+		return
+	}
+	m.render(w, r, gallery, InviteForm{Role: string(model.RoleViewer)}, view.Data{})
+}
+
+// Invite emails a link that gives the role in the gallery to whoever signs in
+// with that address: POST /gallery/:id/members/invite
+func (m *Member) Invite(w http.ResponseWriter, r *http.Request) {
+	gallery, err := m.galleryByID(w, r)
+	if err != nil {
+		return
+	}
+
+	var vd view.Data
+	var form InviteForm
+	if err := parseForm(&form, r); err != nil {
+		vd.SetAlert(err)
+		m.render(w, r, gallery, form, vd)
+		return
+	}
+
+	user := context.User(r.Context())
+	invitation, err := m.ms.Invite(gallery, form.Email, model.Role(form.Role), user)
+	if err != nil {
+		vd.SetAlert(err)
+		m.render(w, r, gallery, form, vd)
+		return
+	}
+
+	// an invitation nobody was told about is useless, so drop it if the email fails
+	if err := m.sendInvitation(r, gallery, invitation, user); err != nil {
+		slog.ErrorContext(r.Context(), "sending invitation", "gallery_id", gallery.ID, "error", err)
+		if err := m.ms.DeleteInvitation(invitation.ID); err != nil {
+			slog.ErrorContext(r.Context(), "deleting unsent invitation", "invitation_id", invitation.ID, "error", err)
+		}
+		vd.AlertError("The invitation email could not be sent. Please try again later.")
+		m.render(w, r, gallery, form, vd)
+		return
+	}
+
+	m.redirect(w, r, gallery, view.Alert{
+		Level:   view.AlertLvlSuccess,
+		Message: "Invitation sent to %s",
+		Args:    []interface{}{invitation.Email},
+	})
+}
+
+// UpdateRole changes a member's role: POST /gallery/:id/members/:userID/role
+func (m *Member) UpdateRole(w http.ResponseWriter, r *http.Request) {
+	gallery, err := m.galleryByID(w, r)
+	if err != nil {
+		return
+	}
+	userID, err := m.memberID(w, r, gallery)
+	if err != nil {
+		return
+	}
+
+	var vd view.Data
+	var form RoleForm
+	if err := parseForm(&form, r); err != nil {
+		vd.SetAlert(err)
+		m.render(w, r, gallery, InviteForm{}, vd)
+		return
+	}
+	if err := m.ms.SetRole(gallery.ID, userID, model.Role(form.Role)); err != nil {
+		vd.SetAlert(err)
+		m.render(w, r, gallery, InviteForm{}, vd)
+		return
+	}
+
+	m.redirect(w, r, gallery, view.Alert{
+		Level:   view.AlertLvlSuccess,
+		Message: "Role successfully updated!",
+	})
+}
+
+// Remove takes a member out of the gallery: POST /gallery/:id/members/:userID/remove
+func (m *Member) Remove(w http.ResponseWriter, r *http.Request) {
+	gallery, err := m.galleryByID(w, r)
+	if err != nil {
+		return
+	}
+	userID, err := m.memberID(w, r, gallery)
+	if err != nil {
+		return
+	}
+
+	if err := m.ms.RemoveMember(gallery.ID, userID); err != nil {
+		var vd view.Data
+		vd.SetAlert(err)
+		m.render(w, r, gallery, InviteForm{}, vd)
+		return
+	}
+
+	m.redirect(w, r, gallery, view.Alert{
+		Level:   view.AlertLvlSuccess,
+		Message: "Member successfully removed!",
+	})
+}
+
+// Revoke cancels a pending invitation: POST /gallery/:id/invitations/:invitationID/revoke
+func (m *Member) Revoke(w http.ResponseWriter, r *http.Request) {
+	gallery, err := m.galleryByID(w, r)
+	if err != nil {
+		return
+	}
+	id, err := strconv.Atoi(mux.Vars(r)["invitationID"])
+	if err != nil {
+		view.Error(w, r, "Invitation not found", http.StatusNotFound)
+		return
+	}
+	invitation, err := m.ms.InvitationByID(uint(id))
+	if err == nil && invitation.GalleryID != gallery.ID {
+		err = model.ErrNotFound
+	}
+	if err != nil {
+		switch err {
+		case model.ErrNotFound:
+			view.Error(w, r, "Invitation not found", http.StatusNotFound)
+		default:
+			view.Error(w, r, "Uh oh! something went wrong", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	if err := m.ms.DeleteInvitation(invitation.ID); err != nil {
+		var vd view.Data
+		vd.SetAlert(err)
+		m.render(w, r, gallery, InviteForm{}, vd)
+		return
+	}
+
+	m.redirect(w, r, gallery, view.Alert{
+		Level:   view.AlertLvlSuccess,
+		Message: "Invitation to %s revoked",
+		Args:    []interface{}{invitation.Email},
+	})
+}
+
+// Invitation shows who is inviting the signed in user to which gallery: GET /invitation/:token
+func (m *Member) Invitation(w http.ResponseWriter, r *http.Request) {
+	token := mux.Vars(r)["token"]
+	invitation, err := m.ms.InvitationByToken(token)
+	if err != nil {
+		m.invitationError(w, r, err)
+		return
+	}
+	invitation.Token = token
+	gallery, err := m.gs.ByID(invitation.GalleryID)
+	if err != nil {
+		m.invitationError(w, r, err)
+		return
+	}
+
+	var vd view.Data
+	vd.Yield = InvitationPage{
+		Gallery:    gallery,
+		Invitation: invitation,
+	}
+	m.InvitationView.Render(w, r, vd)
+}
+
+// Accept joins the signed in user to the invitation's gallery: POST /invitation/:token/accept
+func (m *Member) Accept(w http.ResponseWriter, r *http.Request) {
+	user := context.User(r.Context())
+	invitation, err := m.ms.Accept(mux.Vars(r)["token"], user)
+	if err != nil {
+		m.invitationError(w, r, err)
+		return
+	}
+
+	url, err := m.r.Get(ShowGallery).URL("id", strconv.Itoa(int(invitation.GalleryID)))
+	if err != nil {
+		http.Redirect(w, r, "/", http.StatusFound)
+		return
+	}
+	view.RedirectAlert(w, r, url.Path, http.StatusFound, view.Alert{
+		Level:   view.AlertLvlSuccess,
+		Message: "You joined the gallery as %s",
+		Args:    []interface{}{i18n.T(context.Locale(r.Context()), string(invitation.Role))},
+	})
+}
+
+// sendInvitation emails the invitation link, written in the inviter's language
+func (m *Member) sendInvitation(r *http.Request, gallery *model.Gallery, invitation *model.Invitation, by *model.User) error {
+	url, err := m.r.Get(ShowInvitation).URL("token", invitation.Token)
+	if err != nil {
+		return err
+	}
+	locale := context.Locale(r.Context())
+	name := by.Name
+	if name == "" {
+		name = by.Email
+	}
+	return m.mailer.Send(r.Context(), email.Message{
+		To:      invitation.Email,
+		Subject: i18n.T(locale, "%s shared the gallery %q with you", name, gallery.Title),
+		Body: i18n.T(locale, "You have been invited to the gallery %q as %s. Open this link to accept; it expires in 7 days:", gallery.Title, i18n.T(locale, string(invitation.Role))) +
+			"\n\n" + m.baseURL + url.Path + "\n",
+	})
+}
+
+// invitationError reports an invitation that cannot be used; the model errors
+// are safe to show, anything else is not
+func (m *Member) invitationError(w http.ResponseWriter, r *http.Request, err error) {
+	switch err {
+	case model.ErrNotFound, model.ErrInvitationInvalid:
+		view.Error(w, r, model.ErrInvitationInvalid.Public(), http.StatusNotFound)
+	case model.ErrInvitationEmail:
+		view.Error(w, r, model.ErrInvitationEmail.Public(), http.StatusForbidden)
+	default:
+		view.Error(w, r, "Uh oh! something went wrong", http.StatusInternalServerError)
+	}
+}
+
+// render shows the members page with the alert in vd and form in the invite form
+func (m *Member) render(w http.ResponseWriter, r *http.Request, gallery *model.Gallery, form InviteForm, vd view.Data) {
+	members, err := m.ms.Members(gallery.ID)
+	if err != nil {
+		view.Error(w, r, "Uh oh! something went wrong", http.StatusInternalServerError)
+		return
+	}
+	invitations, err := m.ms.Invitations(gallery.ID)
+	if err != nil {
+		view.Error(w, r, "Uh oh! something went wrong", http.StatusInternalServerError)
+		return
+	}
+	vd.Yield = MembersPage{
+		Gallery:     gallery,
+		Members:     members,
+		Invitations: invitations,
+		Roles:       model.MemberRoles,
+		Form:        form,
+	}
+	m.IndexView.Render(w, r, vd)
+}
+
+func (m *Member) redirect(w http.ResponseWriter, r *http.Request, gallery *model.Gallery, alert view.Alert) {
+	url, err := m.r.Get(GalleryMembers).URL("id", strconv.Itoa(int(gallery.ID)))
+	if err != nil {
+		http.Redirect(w, r, "/", http.StatusFound)
+		return
+	}
+	view.RedirectAlert(w, r, url.Path, http.StatusFound, alert)
+}
+
+// galleryByID loads the gallery in the URL if the signed in user may manage its members
+func (m *Member) galleryByID(w http.ResponseWriter, r *http.Request) (*model.Gallery, error) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		view.Error(w, r, "Invalid gallery ID", http.StatusNotFound)
+		return nil, err
+	}
+
+	gallery, err := m.gs.ByID(uint(id))
+	if err == nil {
+		err = m.ms.Authorize(context.User(r.Context()), gallery, model.ActionManage)
+	}
+	if err != nil {
+		switch err {
+		case model.ErrNotFound:
+			view.Error(w, r, "Gallery not found", http.StatusNotFound)
+		case model.ErrForbidden:
+			view.Error(w, r, "Only the owner can share this gallery", http.StatusForbidden)
+		default:
+			view.Error(w, r, "Uh oh! something went wrong", http.StatusInternalServerError)
+		}
+		return nil, err
+	}
+	return gallery, nil
+}
+
+// memberID reads the user ID in the URL, which must be a member of gallery
+func (m *Member) memberID(w http.ResponseWriter, r *http.Request, gallery *model.Gallery) (uint, error) {
+	id, err := strconv.Atoi(mux.Vars(r)["userID"])
+	if err != nil {
+		view.Error(w, r, "Member not found", http.StatusNotFound)
+		return 0, err
+	}
+	role, err := m.ms.Role(gallery.ID, uint(id))
+	if err == nil && role == model.RoleNone {
+		err = model.ErrNotFound
+	}
+	if err != nil {
+		switch err {
+		case model.ErrNotFound:
+			view.Error(w, r, "Member not found", http.StatusNotFound)
+		default:
+			view.Error(w, r, "Uh oh! something went wrong", http.StatusInternalServerError)
+		}
+		return 0, err
+	}
+	return uint(id), nil
+}
@@ -0,0 +1,57 @@
+package controller
+
+import (
+	"net/http"
+
+	"github.com/jhampac/picha/context"
+	"github.com/jhampac/picha/model"
+	"github.com/jhampac/picha/view"
+)
+
+// Search finds galleries and images the visitor is allowed to see
+type Search struct {
+	View *view.View
+	ss   model.SearchService
+	is   model.ImageService
+}
+
+// NewSearch instantiates a *Search controller
+func NewSearch(ss model.SearchService, is model.ImageService) *Search {
+	return &Search{
+		View: view.New("appcontainer", "search/index"),
+		ss:   ss,
+		is:   is,
+	}
+}
+
+// SearchPage is yielded to the search template
+type SearchPage struct {
+	*model.SearchResults
+	Covers map[uint]*model.Image
+}
+
+// Index runs the query in ?q=: GET /search
+func (s *Search) Index(w http.ResponseWriter, r *http.Request) {
+	var viewerID uint
+	if user := context.User(r.Context()); user != nil {
+		viewerID = user.ID
+	}
+
+	results, err := s.ss.Search(r.URL.Query().Get("q"), viewerID)
+	if err != nil {
+		view.Error(w, r, "Uh oh! something went wrong", http.StatusInternalServerError)
+		return
+	}
+	covers, err := s.is.Covers(results.Galleries)
+	if err != nil {
+		view.Error(w, r, "Uh oh! something went wrong", http.StatusInternalServerError)
+		return
+	}
+
+	var vd view.Data
+	vd.Yield = SearchPage{
+		SearchResults: results,
+		Covers:        covers,
+	}
+	s.View.Render(w, r, vd)
+}
@@ -0,0 +1,250 @@
+package controller
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/jhampac/picha/context"
+	"github.com/jhampac/picha/model"
+	"github.com/jhampac/picha/view"
+)
+
+// ShowShare is the route of a share link's gallery page
+const ShowShare = "show_share"
+
+// shareCookie holds the proof that a share link's password was entered; it is
+// scoped to the link's path
+const shareCookie = "share"
+
+// ShareForm represents the data parsed from the share link form on the edit page
+type ShareForm struct {
+	Password string `schema:"password"`
+
+	// ExpiresOn is the last day the link works, as posted by a date input
+	ExpiresOn string `schema:"expires_on"`
+	MaxViews  int    `schema:"max_views"`
+}
+
+// UnlockForm represents the data parsed from a share link's password form
+type UnlockForm struct {
+	Password string `schema:"password"`
+}
+
+// SharePage is yielded to the share templates
+type SharePage struct {
+	Token   string
+	Gallery *model.Gallery
+	Images  []model.Image
+}
+
+// CreateShare adds a password protected link to the gallery: POST /gallery/:id/shares
+func (g *Gallery) CreateShare(w http.ResponseWriter, r *http.Request) {
+	gallery, err := g.galleryFor(w, r, model.ActionManage)
+	if err != nil {
+		return
+	}
+
+	var vd view.Data
+	var form ShareForm
+	if err := parseForm(&form, r); err != nil {
+		vd.SetAlert(err)
+		g.renderEdit(w, r, gallery, vd)
+		return
+	}
+
+	// the link works through the whole last day, in the server's time zone
+	link := model.ShareLink{
+		GalleryID:   gallery.ID,
+		CreatedByID: context.User(r.Context()).ID,
+		Password:    form.Password,
+		MaxViews:    form.MaxViews,
+	}
+	if day, err := time.ParseInLocation("2006-01-02", form.ExpiresOn, time.Local); err == nil {
+		link.ExpiresAt = day.AddDate(0, 0, 1)
+	}
+	if err := g.ss.Create(&link); err != nil {
+		vd.SetAlert(err)
+		g.renderEdit(w, r, gallery, vd)
+		return
+	}
+
+	g.redirectEdit(w, r, gallery, view.Alert{
+		Level:   view.AlertLvlSuccess,
+		Message: "Share link created!",
+	})
+}
+
+// RevokeShare deletes a share link: POST /gallery/:id/shares/:shareID/revoke
+func (g *Gallery) RevokeShare(w http.ResponseWriter, r *http.Request) {
+	gallery, err := g.galleryFor(w, r, model.ActionManage)
+	if err != nil {
+		return
+	}
+	id, err := strconv.Atoi(mux.Vars(r)["shareID"])
+	if err != nil {
+		view.Error(w, r, "Link not found", http.StatusNotFound)
+		return
+	}
+	link, err := g.ss.ByID(uint(id))
+	if err == nil && link.GalleryID != gallery.ID {
+		err = model.ErrNotFound
+	}
+	if err != nil {
+		switch err {
+		case model.ErrNotFound:
+			view.Error(w, r, "Link not found", http.StatusNotFound)
+		default:
+			view.Error(w, r, "Uh oh! something went wrong", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	var vd view.Data
+	if err := g.ss.Delete(link.ID); err != nil {
+		vd.SetAlert(err)
+		g.renderEdit(w, r, gallery, vd)
+		return
+	}
+
+	g.redirectEdit(w, r, gallery, view.Alert{
+		Level:   view.AlertLvlSuccess,
+		Message: "Share link revoked",
+	})
+}
+
+// Share shows the gallery behind a share link once its password was entered,
+// counting the view: GET /s/:token
+func (g *Gallery) Share(w http.ResponseWriter, r *http.Request) {
+	link, err := g.shareLink(w, r)
+	if err != nil {
+		return
+	}
+	token := mux.Vars(r)["token"]
+	if !g.shareUnlocked(r, link) {
+		if link.UsedUp() {
+			g.shareError(w, r, model.ErrShareLinkUnavailable)
+			return
+		}
+		var vd view.Data
+		vd.Yield = SharePage{Token: token}
+		g.LockView.Render(w, r, vd)
+		return
+	}
+
+	gallery, err := g.gs.ByID(link.GalleryID)
+	if err != nil {
+		g.shareError(w, r, err)
+		return
+	}
+	images, err := g.is.ByGalleryID(gallery.ID)
+	if err != nil {
+		view.Error(w, r, "Uh oh! something went wrong", http.StatusInternalServerError)
+		return
+	}
+	if err := g.ss.AddView(link); err != nil {
+		g.shareError(w, r, err)
+		return
+	}
+
+	var vd view.Data
+	vd.Yield = SharePage{
+		Token:   token,
+		Gallery: gallery,
+		Images:  images,
+	}
+	g.ShareView.Render(w, r, vd)
+}
+
+// Unlock checks a share link's password and remembers it in a cookie for
+// that link only: POST /s/:token
+func (g *Gallery) Unlock(w http.ResponseWriter, r *http.Request) {
+	token := mux.Vars(r)["token"]
+	var vd view.Data
+	vd.Yield = SharePage{Token: token}
+
+	var form UnlockForm
+	if err := parseForm(&form, r); err != nil {
+		vd.SetAlert(err)
+		g.LockView.Render(w, r, vd)
+		return
+	}
+	link, cookie, err := g.ss.Unlock(token, form.Password)
+	if err != nil {
+		if _, ok := err.(model.ValidationError); !ok {
+			g.shareError(w, r, err)
+			return
+		}
+		vd.SetAlert(err)
+		g.LockView.Render(w, r, vd)
+		return
+	}
+
+	url, err := g.r.Get(ShowShare).URL("token", token)
+	if err != nil {
+		http.Redirect(w, r, "/", http.StatusFound)
+		return
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     shareCookie,
+		Value:    cookie,
+		Path:     url.Path,
+		Expires:  link.ExpiresAt,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+	http.Redirect(w, r, url.Path, http.StatusFound)
+}
+
+// ShareImageFile serves the images of an unlocked share link; they do not
+// count as views: GET /s/:token/image/:imageID/file
+func (g *Gallery) ShareImageFile(w http.ResponseWriter, r *http.Request) {
+	link, err := g.shareLink(w, r)
+	if err != nil {
+		return
+	}
+	if !g.shareUnlocked(r, link) {
+		view.Error(w, r, "Image not found", http.StatusNotFound)
+		return
+	}
+	gallery, err := g.gs.ByID(link.GalleryID)
+	if err != nil {
+		g.shareError(w, r, err)
+		return
+	}
+	image, err := g.imageByID(w, r, gallery)
+	if err != nil {
+		return
+	}
+	g.serveImage(w, r, image)
+}
+
+// shareLink loads the usable share link in the URL
+func (g *Gallery) shareLink(w http.ResponseWriter, r *http.Request) (*model.ShareLink, error) {
+	link, err := g.ss.ByToken(mux.Vars(r)["token"])
+	if err != nil {
+		g.shareError(w, r, err)
+		return nil, err
+	}
+	return link, nil
+}
+
+func (g *Gallery) shareUnlocked(r *http.Request, link *model.ShareLink) bool {
+	cookie, err := r.Cookie(shareCookie)
+	return err == nil && g.ss.Unlocked(link, cookie.Value)
+}
+
+// shareError tells visitors whether a link never existed or stopped working
+func (g *Gallery) shareError(w http.ResponseWriter, r *http.Request, err error) {
+	switch err {
+	case model.ErrNotFound:
+		view.Error(w, r, "Link not found", http.StatusNotFound)
+	case model.ErrShareLinkUnavailable:
+		view.Error(w, r, model.ErrShareLinkUnavailable.Public(), http.StatusGone)
+	case model.ErrShareLinkLocked:
+		view.Error(w, r, model.ErrShareLinkLocked.Public(), http.StatusTooManyRequests)
+	default:
+		view.Error(w, r, "Uh oh! something went wrong", http.StatusInternalServerError)
+	}
+}
@@ -4,16 +4,18 @@ import "github.com/jhampac/picha/view"
 
 // Static represents all pages that renders static pages with no model bounded to them
 type Static struct {
-	Home    *view.View
-	Contact *view.View
-	Error   *view.View
+	Home        *view.View
+	Contact     *view.View
+	Error       *view.View
+	ServerError *view.View
 }
 
 // NewStatic instantiates a *Static controller
 func NewStatic() *Static {
 	return &Static{
-		Home:    view.New("appcontainer", "static/home"),
-		Contact: view.New("appcontainer", "static/contact"),
-		Error:   view.New("appcontainer", "static/404"),
+		Home:        view.New("appcontainer", "static/home"),
+		Contact:     view.New("appcontainer", "static/contact"),
+		Error:       view.New("appcontainer", "static/404"),
+		ServerError: view.New("appcontainer", "static/500"),
 	}
 }
@@ -0,0 +1,207 @@
+package controller
+
+import (
+	"log/slog"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/jhampac/picha/context"
+	"github.com/jhampac/picha/model"
+	"github.com/jhampac/picha/view"
+)
+
+const (
+	IndexTrash        = "trash"
+	UndoDeleteGallery = "undo_delete_gallery"
+)
+
+// Trash controller lets gallery owners restore what they deleted, or delete it for good
+type Trash struct {
+	IndexView *view.View
+	ts        model.TrashService
+	r         *mux.Router
+}
+
+// NewTrash instantiates a new controller for the trash
+func NewTrash(ts model.TrashService, r *mux.Router) *Trash {
+	return &Trash{
+		IndexView: view.New("appcontainer", "trash/index"),
+		ts:        ts,
+		r:         r,
+	}
+}
+
+// TrashPage is yielded to the trash template
+type TrashPage struct {
+	Galleries []model.Gallery
+	Images    []model.TrashedImage
+}
+
+// RetentionDays is how long deleted things stay in the trash
+func (p *TrashPage) RetentionDays() int {
+	return int(model.TrashRetention / (24 * time.Hour))
+}
+
+// DaysLeft is how many days are left before something deleted at deletedAt is deleted for good
+func (p *TrashPage) DaysLeft(deletedAt *time.Time) int {
+	left := time.Until(model.PurgeAt(deletedAt)).Hours() / 24
+	return int(math.Max(0, math.Ceil(left)))
+}
+
+// Index lists the user's deleted galleries and images: GET /trash
+func (t *Trash) Index(w http.ResponseWriter, r *http.Request) {
+	user := context.User(r.Context())
+	var page TrashPage
+	var err error
+	if page.Galleries, err = t.ts.Galleries(user.ID); err == nil {
+		page.Images, err = t.ts.Images(user.ID)
+	}
+	if err != nil {
+		slog.ErrorContext(r.Context(), "listing trash", "user_id", user.ID, "error", err)
+		view.Error(w, r, "Uh oh! something went wrong", http.StatusInternalServerError)
+		return
+	}
+
+	var vd view.Data
+	vd.Yield = &page
+	t.IndexView.Render(w, r, vd)
+}
+
+// RestoreGallery brings a gallery back with its images: POST /trash/gallery/:id/restore
+func (t *Trash) RestoreGallery(w http.ResponseWriter, r *http.Request) {
+	gallery, err := t.gallery(w, r)
+	if err != nil {
+		return
+	}
+	if err := t.ts.RestoreGallery(gallery.ID); err != nil {
+		t.failed(w, r, "restoring gallery", err)
+		return
+	}
+	url, err := t.r.Get(ShowGallery).URL("id", strconv.Itoa(int(gallery.ID)))
+	t.redirect(w, r, url, err, "Gallery %q restored", gallery.Title)
+}
+
+// UndoGallery takes back the deletion of a gallery within model.UndoWindow;
+// later it has to be restored from the trash: POST /trash/gallery/:id/undo
+func (t *Trash) UndoGallery(w http.ResponseWriter, r *http.Request) {
+	gallery, err := t.gallery(w, r)
+	if err != nil {
+		return
+	}
+	if time.Since(*gallery.DeletedAt) > model.UndoWindow {
+		url, err := t.r.Get(IndexTrash).URL()
+		if err != nil {
+			http.Redirect(w, r, "/", http.StatusFound)
+			return
+		}
+		view.RedirectAlert(w, r, url.Path, http.StatusFound, view.Alert{
+			Level:   view.AlertLvlWarning,
+			Message: "It is too late to undo, but %q can still be restored from the trash",
+			Args:    []interface{}{gallery.Title},
+		})
+		return
+	}
+	t.RestoreGallery(w, r)
+}
+
+// PurgeGallery deletes a gallery and its images for good: POST /trash/gallery/:id/purge
+func (t *Trash) PurgeGallery(w http.ResponseWriter, r *http.Request) {
+	gallery, err := t.gallery(w, r)
+	if err != nil {
+		return
+	}
+	if err := t.ts.PurgeGallery(gallery.ID); err != nil {
+		t.failed(w, r, "purging gallery", err)
+		return
+	}
+	url, err := t.r.Get(IndexTrash).URL()
+	t.redirect(w, r, url, err, "Gallery %q deleted for good", gallery.Title)
+}
+
+// RestoreImage puts an image back in its gallery: POST /trash/image/:id/restore
+func (t *Trash) RestoreImage(w http.ResponseWriter, r *http.Request) {
+	image, err := t.image(w, r)
+	if err != nil {
+		return
+	}
+	if err := t.ts.RestoreImage(image.ID); err != nil {
+		t.failed(w, r, "restoring image", err)
+		return
+	}
+	url, err := t.r.Get(ShowImage).URL("id", strconv.Itoa(int(image.GalleryID)), "imageID", strconv.Itoa(int(image.ID)))
+	t.redirect(w, r, url, err, "Image %q restored", image.Filename)
+}
+
+// PurgeImage deletes an image for good: POST /trash/image/:id/purge
+func (t *Trash) PurgeImage(w http.ResponseWriter, r *http.Request) {
+	image, err := t.image(w, r)
+	if err != nil {
+		return
+	}
+	if err := t.ts.PurgeImage(image.ID); err != nil {
+		t.failed(w, r, "purging image", err)
+		return
+	}
+	url, err := t.r.Get(IndexTrash).URL()
+	t.redirect(w, r, url, err, "Image %q deleted for good", image.Filename)
+}
+
+// gallery loads the deleted gallery in the URL if the user owns it
+func (t *Trash) gallery(w http.ResponseWriter, r *http.Request) (*model.Gallery, error) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		view.Error(w, r, "Gallery not found", http.StatusNotFound)
+		return nil, err
+	}
+	gallery, err := t.ts.Gallery(uint(id), context.User(r.Context()).ID)
+	switch err {
+	case nil:
+		return gallery, nil
+	case model.ErrNotFound:
+		view.Error(w, r, "Gallery not found", http.StatusNotFound)
+	default:
+		view.Error(w, r, "Uh oh! something went wrong", http.StatusInternalServerError)
+	}
+	return nil, err
+}
+
+// image loads the deleted image in the URL if the user owns its gallery
+func (t *Trash) image(w http.ResponseWriter, r *http.Request) (*model.Image, error) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		view.Error(w, r, "Image not found", http.StatusNotFound)
+		return nil, err
+	}
+	image, err := t.ts.Image(uint(id), context.User(r.Context()).ID)
+	switch err {
+	case nil:
+		return image, nil
+	case model.ErrNotFound:
+		view.Error(w, r, "Image not found", http.StatusNotFound)
+	default:
+		view.Error(w, r, "Uh oh! something went wrong", http.StatusInternalServerError)
+	}
+	return nil, err
+}
+
+func (t *Trash) failed(w http.ResponseWriter, r *http.Request, msg string, err error) {
+	slog.ErrorContext(r.Context(), msg, "error", err)
+	view.Error(w, r, "Uh oh! something went wrong", http.StatusInternalServerError)
+}
+
+// redirect goes to u with a success alert, or home when the URL could not be built
+func (t *Trash) redirect(w http.ResponseWriter, r *http.Request, u *url.URL, err error, msg string, args ...interface{}) {
+	if err != nil {
+		http.Redirect(w, r, "/", http.StatusFound)
+		return
+	}
+	view.RedirectAlert(w, r, u.Path, http.StatusFound, view.Alert{
+		Level:   view.AlertLvlSuccess,
+		Message: msg,
+		Args:    args,
+	})
+}
@@ -0,0 +1,325 @@
+package controller
+
+import (
+	"encoding/base64"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/jhampac/picha/context"
+	"github.com/jhampac/picha/i18n"
+	"github.com/jhampac/picha/metrics"
+	"github.com/jhampac/picha/model"
+	"github.com/jhampac/picha/view"
+)
+
+const (
+	ShowUpload = "show_upload"
+
+	// tusVersion is the only version of the tus protocol spoken
+	tusVersion = "1.0.0"
+
+	// tusExtensions are the optional parts of the protocol supported
+	tusExtensions = "creation,expiration,termination"
+)
+
+// Upload controller speaks the tus resumable upload protocol (https://tus.io),
+// so large files survive flaky connections: a client creates an upload, sends
+// it in PATCH chunks and asks with HEAD where to resume. Finished uploads go
+// through the same pipeline as form uploads.
+type Upload struct {
+	gs model.GalleryService
+	ms model.MemberService
+	is model.ImageService
+	us model.UploadService
+	r  *mux.Router
+}
+
+// NewUpload instantiates a new controller for resumable uploads
+func NewUpload(gs model.GalleryService, ms model.MemberService, is model.ImageService, us model.UploadService, r *mux.Router) *Upload {
+	return &Upload{
+		gs: gs,
+		ms: ms,
+		is: is,
+		us: us,
+		r:  r,
+	}
+}
+
+// Options tells clients what the server supports: OPTIONS /gallery/:id/uploads
+func (u *Upload) Options(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Tus-Resumable", tusVersion)
+	w.Header().Set("Tus-Version", tusVersion)
+	w.Header().Set("Tus-Extension", tusExtensions)
+	w.Header().Set("Tus-Max-Size", strconv.Itoa(model.MaxUploadBytes))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Create starts an upload of Upload-Length bytes into the gallery. The
+// gallery owner's storage quota is checked now rather than after the bytes
+// were sent: POST /gallery/:id/uploads
+func (u *Upload) Create(w http.ResponseWriter, r *http.Request) {
+	if !u.tusRequest(w, r) {
+		return
+	}
+	gallery, ok := u.gallery(w, r, mux.Vars(r)["id"])
+	if !ok {
+		return
+	}
+	length, err := strconv.ParseInt(r.Header.Get("Upload-Length"), 10, 64)
+	if err != nil {
+		u.error(w, r, "The Upload-Length header is required", http.StatusBadRequest)
+		return
+	}
+
+	user := context.User(r.Context())
+	upload := model.Upload{
+		UserID:    user.ID,
+		GalleryID: gallery.ID,
+		Filename:  uploadFilename(r.Header.Get("Upload-Metadata")),
+		Length:    length,
+	}
+	if err := u.us.Create(&upload); err != nil {
+		u.modelError(w, r, err)
+		return
+	}
+
+	url, err := u.r.Get(ShowUpload).URL("token", upload.Token)
+	if err != nil {
+		u.error(w, r, "Uh oh! something went wrong", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Location", url.Path)
+	w.Header().Set("Upload-Expires", upload.ExpiresAt().UTC().Format(http.TimeFormat))
+	w.WriteHeader(http.StatusCreated)
+}
+
+// Head reports how many bytes arrived, i.e. where to resume: HEAD /uploads/:token
+func (u *Upload) Head(w http.ResponseWriter, r *http.Request) {
+	if !u.tusRequest(w, r) {
+		return
+	}
+	upload, ok := u.upload(w, r)
+	if !ok {
+		return
+	}
+	u.offsetHeaders(w, upload)
+	w.Header().Set("Upload-Length", strconv.FormatInt(upload.Length, 10))
+	w.WriteHeader(http.StatusOK)
+}
+
+// Patch appends a chunk starting at Upload-Offset. The upload that receives
+// the last byte creates the image: PATCH /uploads/:token
+func (u *Upload) Patch(w http.ResponseWriter, r *http.Request) {
+	if !u.tusRequest(w, r) {
+		return
+	}
+	if r.Header.Get("Content-Type") != "application/offset+octet-stream" {
+		u.error(w, r, "Chunks must be sent as application/offset+octet-stream", http.StatusUnsupportedMediaType)
+		return
+	}
+	offset, err := strconv.ParseInt(r.Header.Get("Upload-Offset"), 10, 64)
+	if err != nil || offset < 0 {
+		u.error(w, r, "The Upload-Offset header is required", http.StatusBadRequest)
+		return
+	}
+	upload, ok := u.upload(w, r)
+	if !ok {
+		return
+	}
+	// membership may have changed since the upload was created
+	gallery, ok := u.gallery(w, r, strconv.Itoa(int(upload.GalleryID)))
+	if !ok {
+		return
+	}
+
+	if !upload.Complete() {
+		if err := u.us.Append(upload, offset, r.Body); err != nil {
+			if err != model.ErrUploadOffset {
+				// the bytes before the failure are kept; HEAD tells the client where to resume
+				slog.WarnContext(r.Context(), "appending upload chunk", "upload_id", upload.ID, "error", err)
+			}
+			u.modelError(w, r, err)
+			return
+		}
+	} else if offset != upload.Offset {
+		u.modelError(w, r, model.ErrUploadOffset)
+		return
+	}
+
+	if upload.Complete() && upload.ImageID == 0 {
+		if err := u.finish(r, gallery, upload); err != nil {
+			u.modelError(w, r, err)
+			return
+		}
+	}
+	u.offsetHeaders(w, upload)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Delete cancels an upload and frees the space it claimed: DELETE /uploads/:token
+func (u *Upload) Delete(w http.ResponseWriter, r *http.Request) {
+	if !u.tusRequest(w, r) {
+		return
+	}
+	upload, ok := u.upload(w, r)
+	if !ok {
+		return
+	}
+	if err := u.us.Remove(upload); err != nil {
+		u.error(w, r, "Uh oh! something went wrong", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// finish adds the received file to the gallery. Images that cannot be used
+// are dropped with their upload; other failures keep it, so sending the
+// empty last chunk again retries. The upload's lock keeps concurrent
+// retries from creating the image twice.
+func (u *Upload) finish(r *http.Request, gallery *model.Gallery, upload *model.Upload) error {
+	if err := u.us.Lock(upload); err != nil {
+		return err
+	}
+	defer func() {
+		if err := u.us.Unlock(upload); err != nil {
+			slog.ErrorContext(r.Context(), "unlocking upload", "upload_id", upload.ID, "error", err)
+		}
+	}()
+	if upload.ImageID != 0 {
+		return nil
+	}
+
+	rc, err := u.us.Open(upload)
+	if err != nil {
+		return err
+	}
+	image, err := u.is.Upload(gallery.ID, upload.Filename, rc)
+	rc.Close()
+	switch err {
+	case nil:
+	case model.ErrImageUnsupported, model.ErrImageTooLarge:
+		if rerr := u.us.Remove(upload); rerr != nil {
+			slog.ErrorContext(r.Context(), "removing unusable upload", "upload_id", upload.ID, "error", rerr)
+		}
+		return err
+	default:
+		return err
+	}
+	metrics.Upload(image.Size)
+
+	return u.us.Finish(upload, image.ID)
+}
+
+// tusRequest checks the client speaks our protocol version and marks the response
+func (u *Upload) tusRequest(w http.ResponseWriter, r *http.Request) bool {
+	w.Header().Set("Tus-Resumable", tusVersion)
+	if r.Header.Get("Tus-Resumable") != tusVersion {
+		w.Header().Set("Tus-Version", tusVersion)
+		u.error(w, r, "Unsupported version of the tus protocol", http.StatusPreconditionFailed)
+		return false
+	}
+	return true
+}
+
+// gallery loads the gallery with id if the user may upload to it
+func (u *Upload) gallery(w http.ResponseWriter, r *http.Request, id string) (*model.Gallery, bool) {
+	galleryID, err := strconv.Atoi(id)
+	if err != nil {
+		u.error(w, r, "Gallery not found", http.StatusNotFound)
+		return nil, false
+	}
+	gallery, err := u.gs.ByID(uint(galleryID))
+	if err == nil {
+		err = u.ms.Authorize(context.User(r.Context()), gallery, model.ActionUpload)
+	}
+	switch err {
+	case nil:
+		return gallery, true
+	case model.ErrNotFound:
+		u.error(w, r, "Gallery not found", http.StatusNotFound)
+	case model.ErrForbidden:
+		u.error(w, r, "You do not have permission to do that in this gallery", http.StatusForbidden)
+	default:
+		u.error(w, r, "Uh oh! something went wrong", http.StatusInternalServerError)
+	}
+	return nil, false
+}
+
+// upload loads the user's upload with the token in the URL
+func (u *Upload) upload(w http.ResponseWriter, r *http.Request) (*model.Upload, bool) {
+	upload, err := u.us.ByToken(mux.Vars(r)["token"])
+	if err == nil && upload.UserID != context.User(r.Context()).ID {
+		err = model.ErrNotFound
+	}
+	switch err {
+	case nil:
+		return upload, true
+	case model.ErrNotFound:
+		u.error(w, r, "Upload not found", http.StatusNotFound)
+	default:
+		u.error(w, r, "Uh oh! something went wrong", http.StatusInternalServerError)
+	}
+	return nil, false
+}
+
+func (u *Upload) offsetHeaders(w http.ResponseWriter, upload *model.Upload) {
+	w.Header().Set("Upload-Offset", strconv.FormatInt(upload.Offset, 10))
+	w.Header().Set("Cache-Control", "no-store")
+	if !upload.Complete() {
+		w.Header().Set("Upload-Expires", upload.ExpiresAt().UTC().Format(http.TimeFormat))
+	}
+}
+
+// modelError maps an error of the upload pipeline to its status
+func (u *Upload) modelError(w http.ResponseWriter, r *http.Request, err error) {
+	code := http.StatusInternalServerError
+	switch err {
+	case model.ErrUploadOffset:
+		code = http.StatusConflict
+	case model.ErrUploadLocked:
+		code = http.StatusLocked
+	case model.ErrUploadTooLarge, model.ErrQuotaExceeded:
+		code = http.StatusRequestEntityTooLarge
+	case model.ErrUploadLengthInvalid:
+		code = http.StatusBadRequest
+	case model.ErrImageUnsupported, model.ErrImageTooLarge:
+		code = http.StatusUnprocessableEntity
+	}
+	msg := "Uh oh! something went wrong"
+	if pErr, ok := err.(view.PublicError); ok && code != http.StatusInternalServerError {
+		msg = pErr.Public()
+	}
+	u.error(w, r, msg, code)
+}
+
+// error answers in plain text, since tus clients show the body as is
+func (u *Upload) error(w http.ResponseWriter, r *http.Request, msg string, code int) {
+	http.Error(w, i18n.T(context.Locale(r.Context()), msg), code)
+}
+
+// uploadFilename reads the file name from the Upload-Metadata header, a
+// comma separated list of keys with base64 values; clients use "filename" or "name"
+func uploadFilename(metadata string) string {
+	values := make(map[string]string)
+	for _, pair := range strings.Split(metadata, ",") {
+		parts := strings.Fields(pair)
+		if len(parts) != 2 {
+			continue
+		}
+		b, err := base64.StdEncoding.DecodeString(parts[1])
+		if err != nil {
+			continue
+		}
+		values[parts[0]] = string(b)
+	}
+	if name := values["filename"]; name != "" {
+		return name
+	}
+	if name := values["name"]; name != "" {
+		return name
+	}
+	return "upload"
+}
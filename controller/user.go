@@ -3,8 +3,12 @@ package controller
 import (
 	"fmt"
 	"net/http"
+	"net/url"
 	"strings"
 
+	"github.com/jhampac/picha/context"
+	"github.com/jhampac/picha/i18n"
+	"github.com/jhampac/picha/metrics"
 	"github.com/jhampac/picha/model"
 	"github.com/jhampac/picha/rand"
 	"github.com/jhampac/picha/view"
@@ -23,25 +27,32 @@ type SignupForm struct {
 	Password string `schema:"password"`
 }
 
+// LocaleForm captures the language picked in the footer
+type LocaleForm struct {
+	Lang string `schema:"lang"`
+}
+
 // User represents a user in our application
 type User struct {
-	NewView   *view.View
-	LoginView *view.View
-	us        model.UserService
+	NewView     *view.View
+	LoginView   *view.View
+	AccountView *view.View
+	us          model.UserService
 }
 
 // NewUser instantiates and returns a *User type
 func NewUser(us model.UserService) *User {
 	return &User{
-		NewView:   view.New("appcontainer", "user/new"),
-		LoginView: view.New("appcontainer", "user/login"),
-		us:        us,
+		NewView:     view.New("appcontainer", "user/new"),
+		LoginView:   view.New("appcontainer", "user/login"),
+		AccountView: view.New("appcontainer", "user/account"),
+		us:          us,
 	}
 }
 
 // New is the handler used to sign a new user up
 func (u *User) New(w http.ResponseWriter, r *http.Request) {
-	u.NewView.Render(w, nil)
+	u.NewView.Render(w, r, nil)
 }
 
 // Create a new user by handling the request with form data
@@ -52,28 +63,37 @@ func (u *User) Create(w http.ResponseWriter, r *http.Request) {
 	// parse the form and place the results at the address *form
 	// gorilla mux schema
 	// I like pointers at call-site
-	if err := parseForm(&form, r); err != nil {
+	err := parseForm(&form, r)
+
+	// re-render the form with what was submitted, minus the password
+	vd.Yield = SignupForm{
+		Name:  form.Name,
+		Email: form.Email,
+	}
+	if err != nil {
 		vd.SetAlert(err)
-		u.NewView.Render(w, vd)
+		u.NewView.Render(w, r, vd)
 		return
 	}
 
-	// instantiate a user model with the values from the form
+	// instantiate a user model with the values from the form; remember the language they signed up in
 	user := model.User{
 		Name:     strings.ToLower(form.Name),
 		Email:    form.Email,
 		Password: form.Password,
+		Locale:   context.Locale(r.Context()),
 	}
 
 	// create the user in the db with the provided UserService
 	if err := u.us.Create(&user); err != nil {
 		vd.SetAlert(err)
-		u.NewView.Render(w, vd)
+		u.NewView.Render(w, r, vd)
 		return
 	}
+	metrics.Signups.Inc()
 
 	// remember me token
-	err := u.signIn(w, &user)
+	err = u.signIn(w, &user)
 	if err != nil {
 		http.Redirect(w, r, "/login", http.StatusFound)
 		return
@@ -91,9 +111,15 @@ func (u *User) Login(w http.ResponseWriter, r *http.Request) {
 
 	// parse the form and place the results at the address *form
 	// gorilla mux schema
-	if err := parseForm(&form, r); err != nil {
+	err := parseForm(&form, r)
+
+	// re-render the form with the email address that was submitted
+	vd.Yield = LoginForm{
+		Email: form.Email,
+	}
+	if err != nil {
 		vd.SetAlert(err)
-		u.LoginView.Render(w, vd)
+		u.LoginView.Render(w, r, vd)
 		return
 	}
 
@@ -101,14 +127,18 @@ func (u *User) Login(w http.ResponseWriter, r *http.Request) {
 	user, err := u.us.Authenticate(form.Email, form.Password)
 
 	// check for errors
+	metrics.Login(err == nil)
 	if err != nil {
 		switch err {
 		case model.ErrNotFound:
 			vd.AlertError("No user exists with that email address")
+			vd.AddFieldError("email", "No user exists with that email address")
+		case model.ErrPasswordIncorrect:
+			vd.SetAlert(model.NewValidationError("password", model.ErrPasswordIncorrect))
 		default:
 			vd.SetAlert(err)
 		}
-		u.LoginView.Render(w, vd)
+		u.LoginView.Render(w, r, vd)
 		return
 	}
 
@@ -116,24 +146,65 @@ func (u *User) Login(w http.ResponseWriter, r *http.Request) {
 	err = u.signIn(w, user)
 	if err != nil {
 		vd.SetAlert(err)
-		u.LoginView.Render(w, vd)
+		u.LoginView.Render(w, r, vd)
 		return
 	}
 
 	http.Redirect(w, r, "/cookietest", http.StatusFound)
 }
 
+// Account shows the signed in user's plan and how much of its storage they use: GET /account
+func (u *User) Account(w http.ResponseWriter, r *http.Request) {
+	// reloaded, since the signed in user may come from a cache with an older storage count
+	user, err := u.us.ByID(context.User(r.Context()).ID)
+	if err != nil {
+		view.Error(w, r, "Uh oh! something went wrong", http.StatusInternalServerError)
+		return
+	}
+	var vd view.Data
+	vd.Yield = user
+	u.AccountView.Render(w, r, vd)
+}
+
+// SetLocale saves the language picked in the footer as the signed in user's
+// preference and goes back to the page with a ?lang= switch, which sets the
+// cookie visitors are remembered by: POST /locale
+func (u *User) SetLocale(w http.ResponseWriter, r *http.Request) {
+	var form LocaleForm
+	if err := parseForm(&form, r); err != nil || !i18n.Supported(form.Lang) {
+		view.Error(w, r, "Unsupported language", http.StatusBadRequest)
+		return
+	}
+	if user := context.User(r.Context()); user != nil && user.Locale != form.Lang {
+		if err := u.us.UpdateLocale(user, form.Lang); err != nil {
+			view.Error(w, r, "Uh oh! something went wrong", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	// only the path and query of the referring page, so this cannot redirect off the site
+	back := url.URL{Path: "/"}
+	if ref, err := url.Parse(r.Referer()); err == nil && strings.HasPrefix(ref.Path, "/") && !strings.HasPrefix(ref.Path, "//") {
+		back.Path = ref.Path
+		back.RawQuery = ref.RawQuery
+	}
+	q := back.Query()
+	q.Set("lang", form.Lang)
+	back.RawQuery = q.Encode()
+	http.Redirect(w, r, back.String(), http.StatusFound)
+}
+
 // CookieTest is a debug route for cookies
 func (u *User) CookieTest(w http.ResponseWriter, r *http.Request) {
 	cookie, err := r.Cookie("remember_token")
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		view.Error(w, r, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
 	user, err := u.us.ByRemember(cookie.Value)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		view.Error(w, r, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
@@ -11,7 +11,9 @@ func parseForm(dst interface{}, r *http.Request) error {
 		return err
 	}
 
+	// forms carry fields the handlers never decode, such as the CSRF token
 	dec := schema.NewDecoder()
+	dec.IgnoreUnknownKeys(true)
 	if err := dec.Decode(dst, r.PostForm); err != nil {
 		return err
 	}
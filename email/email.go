@@ -0,0 +1,96 @@
+package email
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/smtp"
+	"strings"
+
+	"github.com/jhampac/picha/jobs"
+)
+
+// Message is a plain text email
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer sends email
+type Mailer interface {
+	Send(ctx context.Context, msg Message) error
+}
+
+// Log is a Mailer for development that writes messages to the log instead of sending them
+type Log struct {
+	Log *slog.Logger
+}
+
+// Send logs msg
+func (l Log) Send(ctx context.Context, msg Message) error {
+	l.Log.InfoContext(ctx, "email not sent, no SMTP server configured", "to", msg.To, "subject", msg.Subject, "body", msg.Body)
+	return nil
+}
+
+// SMTP sends mail through an SMTP server with PLAIN auth when a username is set
+type SMTP struct {
+	Host     string
+	Port     int
+	Username string
+	Password string
+	From     string
+}
+
+// Send delivers msg; net/smtp has no context support so ctx is only checked up front
+func (s SMTP) Send(ctx context.Context, msg Message) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	var auth smtp.Auth
+	if s.Username != "" {
+		auth = smtp.PlainAuth("", s.Username, s.Password, s.Host)
+	}
+	addr := fmt.Sprintf("%s:%d", s.Host, s.Port)
+	return smtp.SendMail(addr, auth, s.From, []string{msg.To}, s.format(msg))
+}
+
+// format builds the RFC 5322 message; header values are stripped of line breaks
+func (s SMTP) format(msg Message) []byte {
+	clean := strings.NewReplacer("\r", "", "\n", "")
+	var b strings.Builder
+	fmt.Fprintf(&b, "From: %s\r\n", clean.Replace(s.From))
+	fmt.Fprintf(&b, "To: %s\r\n", clean.Replace(msg.To))
+	fmt.Fprintf(&b, "Subject: %s\r\n", clean.Replace(msg.Subject))
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	b.WriteString("\r\n")
+	b.WriteString(strings.ReplaceAll(msg.Body, "\n", "\r\n"))
+	return []byte(b.String())
+}
+
+// JobSend is the kind of job that delivers a queued message
+const JobSend = "email.send"
+
+// Queued is a Mailer that only enqueues messages; Deliver sends them from a
+// worker, retrying while the SMTP server is unavailable
+type Queued struct {
+	Queue jobs.Queue
+}
+
+// Send enqueues msg
+func (q Queued) Send(ctx context.Context, msg Message) error {
+	_, err := q.Queue.Enqueue(ctx, JobSend, msg)
+	return err
+}
+
+// Deliver returns the handler for JobSend jobs, sending through m
+func Deliver(m Mailer) jobs.Handler {
+	return func(ctx context.Context, job *jobs.Job) error {
+		var msg Message
+		if err := job.Decode(&msg); err != nil {
+			return err
+		}
+		return m.Send(ctx, msg)
+	}
+}
@@ -0,0 +1,96 @@
+package i18n
+
+import (
+	"embed"
+	"encoding/json"
+	"fmt"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Default is the locale the source strings are written in
+const Default = "en"
+
+// Names maps every supported locale to its name in that language, for language pickers
+var Names = map[string]string{
+	"en": "English",
+	"fr": "Français",
+	"sw": "Kiswahili",
+}
+
+// locales holds one catalog per non-default locale, keyed by the English source string
+//
+//go:embed locales/*.json
+var locales embed.FS
+
+var catalogs = make(map[string]map[string]string)
+
+func init() {
+	files, err := locales.ReadDir("locales")
+	if err != nil {
+		panic(err)
+	}
+	for _, f := range files {
+		b, err := locales.ReadFile("locales/" + f.Name())
+		if err != nil {
+			panic(err)
+		}
+		catalog := make(map[string]string)
+		if err := json.Unmarshal(b, &catalog); err != nil {
+			panic(fmt.Errorf("i18n: parsing %s: %w", f.Name(), err))
+		}
+		catalogs[strings.TrimSuffix(f.Name(), path.Ext(f.Name()))] = catalog
+	}
+}
+
+// Supported reports whether locale has a catalog
+func Supported(locale string) bool {
+	_, ok := Names[locale]
+	return ok
+}
+
+// T translates msg into locale, falling back to msg itself. When args are
+// given the translation is used as a fmt format string.
+func T(locale, msg string, args ...interface{}) string {
+	if tr, ok := catalogs[locale][msg]; ok && tr != "" {
+		msg = tr
+	}
+	if len(args) == 0 {
+		return msg
+	}
+	return fmt.Sprintf(msg, args...)
+}
+
+// Match picks the best supported locale for an Accept-Language header such as
+// "fr-CH, fr;q=0.9, en;q=0.8", or Default if nothing matches
+func Match(acceptLanguage string) string {
+	type candidate struct {
+		locale string
+		q      float64
+	}
+	var candidates []candidate
+	for _, part := range strings.Split(acceptLanguage, ",") {
+		tag, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		q := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			parsed, err := strconv.ParseFloat(v, 64)
+			if err != nil {
+				continue
+			}
+			q = parsed
+		}
+		base, _, _ := strings.Cut(strings.ToLower(tag), "-")
+		if Supported(base) && q > 0 {
+			candidates = append(candidates, candidate{locale: base, q: q})
+		}
+	}
+	if len(candidates) == 0 {
+		return Default
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].q > candidates[j].q
+	})
+	return candidates[0].locale
+}
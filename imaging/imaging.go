@@ -0,0 +1,92 @@
+package imaging
+
+import (
+	"errors"
+	"image"
+	"image/jpeg"
+	"io"
+
+	// decoders for the formats we accept
+	_ "image/gif"
+	_ "image/png"
+
+	"golang.org/x/image/draw"
+)
+
+// Widths are the variants generated for every upload, smallest first.
+// Images narrower than a width do not get that variant.
+var Widths = []int{320, 1024}
+
+// MaxPixels is the largest image accepted, as width times height. Decoding
+// needs about four bytes per pixel, so this bounds memory for small files that
+// claim huge dimensions.
+const MaxPixels = 100 * 1000 * 1000
+
+// JPEGQuality is used when encoding variants
+const JPEGQuality = 85
+
+// ErrUnsupported is returned for files that are not JPEG, PNG or GIF images
+var ErrUnsupported = errors.New("imaging: unsupported image format")
+
+// Decode reads a JPEG, PNG or GIF image
+func Decode(r io.Reader) (image.Image, error) {
+	img, _, err := image.Decode(r)
+	if err == image.ErrFormat {
+		return nil, ErrUnsupported
+	}
+	return img, err
+}
+
+// DecodeConfig reads just the format and dimensions of an image
+func DecodeConfig(r io.Reader) (image.Config, string, error) {
+	cfg, format, err := image.DecodeConfig(r)
+	if err == image.ErrFormat {
+		return cfg, "", ErrUnsupported
+	}
+	return cfg, format, err
+}
+
+// Resize scales src down to width, keeping the aspect ratio
+func Resize(src image.Image, width int) image.Image {
+	b := src.Bounds()
+	if b.Dx() <= width {
+		return src
+	}
+	height := b.Dy() * width / b.Dx()
+	if height < 1 {
+		height = 1
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.CatmullRom.Scale(dst, dst.Bounds(), src, b, draw.Over, nil)
+	return dst
+}
+
+// EncodeJPEG writes img as a JPEG at JPEGQuality
+func EncodeJPEG(w io.Writer, img image.Image) error {
+	return jpeg.Encode(w, img, &jpeg.Options{Quality: JPEGQuality})
+}
+
+// VariantWidths returns the variant widths an image of the given width gets
+func VariantWidths(width int) []int {
+	var widths []int
+	for _, w := range Widths {
+		if w < width {
+			widths = append(widths, w)
+		}
+	}
+	return widths
+}
+
+// BestWidth picks the smallest variant at least want pixels wide for an image
+// of the given width; 0 means the original should be used
+func BestWidth(width, want int) int {
+	if want <= 0 {
+		return 0
+	}
+	for _, w := range VariantWidths(width) {
+		if w >= want {
+			return w
+		}
+	}
+	return 0
+}
@@ -0,0 +1,154 @@
+// Package importer expands ZIP archives and server directories into galleries
+package importer
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"log/slog"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/jhampac/picha/jobs"
+	"github.com/jhampac/picha/model"
+)
+
+// Importer adds the files of a source to the gallery of an import job,
+// recording a result for every file
+type Importer struct {
+	Images  model.ImageService
+	Imports model.ImportService
+
+	// Progress, when set, is called after each file, e.g. to print it
+	Progress func(item *model.ImportItem)
+}
+
+// JobImport is the kind of job that imports an uploaded archive
+const JobImport = "import.archive"
+
+// Archive is the payload of JobImport jobs
+type Archive struct {
+	ImportID uint   `json:"import_id"`
+	Path     string `json:"path"`
+}
+
+// Enqueue queues the import of the archive at path for job. The import is not
+// retried: files imported before a failure would be reported as duplicates.
+func Enqueue(ctx context.Context, q jobs.Queue, job *model.ImportJob, path string) error {
+	_, err := q.Enqueue(ctx, JobImport, Archive{ImportID: job.ID, Path: path}, jobs.MaxAttempts(1))
+	return err
+}
+
+// HandleArchive is the handler for JobImport jobs; it removes the archive
+// when done
+func (im *Importer) HandleArchive(ctx context.Context, j *jobs.Job) error {
+	var archive Archive
+	if err := j.Decode(&archive); err != nil {
+		return err
+	}
+	defer os.Remove(archive.Path)
+
+	job, err := im.Imports.ByID(archive.ImportID)
+	if err != nil {
+		return err
+	}
+	src, err := OpenZip(archive.Path, DefaultLimits)
+	if err != nil {
+		job.Status = model.ImportFailed
+		job.Error = "the import stopped unexpectedly"
+		im.Imports.Update(job)
+		return err
+	}
+	defer src.Close()
+	return im.Run(ctx, job, src)
+}
+
+// Run imports src into job's gallery. Problems with single files are recorded
+// as items; the returned error is why the whole job failed. The job keeps a
+// message fit for its owner.
+func (im *Importer) Run(ctx context.Context, job *model.ImportJob, src Source) error {
+	job.Status = model.ImportRunning
+	if err := im.Imports.Update(job); err != nil {
+		return err
+	}
+
+	err := src.Walk(func(name string, r io.Reader, err error) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		item := model.ImportItem{ImportJobID: job.ID, Name: name}
+		if err != nil {
+			item.Result = model.ItemSkipped
+			item.Message = err.Error()
+		} else {
+			im.importFile(&item, job.GalleryID, r)
+		}
+		if err := im.Imports.AddItem(&item); err != nil {
+			return err
+		}
+		if im.Progress != nil {
+			im.Progress(&item)
+		}
+		return nil
+	})
+
+	job.Status = model.ImportDone
+	if err != nil {
+		slog.ErrorContext(ctx, "import failed", "import_id", job.ID, "error", err)
+		job.Status = model.ImportFailed
+		job.Error = "the import stopped unexpectedly"
+		if err == ErrTooManyFiles || err == ErrTooLarge {
+			job.Error = strings.TrimPrefix(err.Error(), "importer: ")
+		}
+	}
+	if uerr := im.Imports.Update(job); uerr != nil {
+		return uerr
+	}
+	return err
+}
+
+// importFile uploads one file unless the gallery already has its content
+func (im *Importer) importFile(item *model.ImportItem, galleryID uint, r io.Reader) {
+	b, err := io.ReadAll(r)
+	if err != nil {
+		item.Result = model.ItemFailed
+		if err == errFileTooLarge {
+			item.Result = model.ItemSkipped
+		}
+		item.Message = err.Error()
+		return
+	}
+
+	existing, err := im.Images.ByHash(galleryID, model.HashBytes(b))
+	switch err {
+	case nil:
+		item.Result = model.ItemDuplicate
+		item.ImageID = existing.ID
+		return
+	case model.ErrNotFound:
+	default:
+		item.Result = model.ItemFailed
+		item.Message = err.Error()
+		return
+	}
+
+	image, err := im.Images.Upload(galleryID, path.Base(item.Name), bytes.NewReader(b))
+	switch err {
+	case nil:
+		item.Result = model.ItemImported
+		item.ImageID = image.ID
+	case model.ErrImageUnsupported:
+		item.Result = model.ItemSkipped
+		item.Message = "not an image"
+	case model.ErrImageTooLarge:
+		item.Result = model.ItemSkipped
+		item.Message = "image is too large"
+	case model.ErrQuotaExceeded:
+		item.Result = model.ItemSkipped
+		item.Message = "not enough storage space left"
+	default:
+		item.Result = model.ItemFailed
+		item.Message = err.Error()
+	}
+}
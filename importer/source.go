@@ -0,0 +1,266 @@
+package importer
+
+import (
+	"archive/zip"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+var (
+	// ErrTooManyFiles is returned when a source has more than Limits.MaxFiles entries
+	ErrTooManyFiles = errors.New("importer: too many files")
+
+	// ErrTooLarge is returned when a source expands past Limits.MaxTotalBytes
+	ErrTooLarge = errors.New("importer: the files are too large in total")
+
+	// errFileTooLarge is recorded for single files over Limits.MaxFileBytes
+	errFileTooLarge = errors.New("file is too large")
+
+	// errUnsafePath is recorded for archive entries that would escape the archive, e.g. "../x"
+	errUnsafePath = errors.New("unsafe file path")
+
+	// errCompressionRatio is recorded for archive entries that expand suspiciously much
+	errCompressionRatio = errors.New("file is compressed too much to be a photo")
+)
+
+// Limits bound what an import may expand to, so an archive cannot exhaust
+// memory or disk (a decompression bomb)
+type Limits struct {
+	MaxFiles      int
+	MaxFileBytes  int64
+	MaxTotalBytes int64
+
+	// MaxRatio is the largest uncompressed to compressed size of an archive
+	// entry; photos barely compress, bombs compress a thousandfold
+	MaxRatio int64
+}
+
+// DefaultLimits suit a photographer's shoot
+var DefaultLimits = Limits{
+	MaxFiles:      5000,
+	MaxFileBytes:  64 << 20,
+	MaxTotalBytes: 8 << 30,
+	MaxRatio:      100,
+}
+
+// Source is a set of files to import
+type Source interface {
+	// Walk calls fn for every regular file with its slash separated name and
+	// either its contents or why it cannot be read. Returning an error from
+	// fn, or hitting a limit, stops the walk.
+	Walk(fn func(name string, r io.Reader, err error) error) error
+	Close() error
+}
+
+// OpenZip opens the ZIP archive at name
+func OpenZip(name string, limits Limits) (Source, error) {
+	zr, err := zip.OpenReader(name)
+	if err != nil {
+		return nil, err
+	}
+	return &zipSource{zr: zr, limits: limits}, nil
+}
+
+type zipSource struct {
+	zr     *zip.ReadCloser
+	limits Limits
+}
+
+func (s *zipSource) Close() error {
+	return s.zr.Close()
+}
+
+// Walk never writes entries to disk, but still refuses names that would if
+// they were extracted (zip-slip). Sizes in the archive can lie, so reads are
+// capped as well as the declared sizes checked.
+func (s *zipSource) Walk(fn func(name string, r io.Reader, err error) error) error {
+	var files []*zip.File
+	for _, f := range s.zr.File {
+		if !f.Mode().IsRegular() || hidden(f.Name) {
+			continue
+		}
+		files = append(files, f)
+	}
+	if len(files) > s.limits.MaxFiles {
+		return ErrTooManyFiles
+	}
+
+	var total int64
+	for _, f := range files {
+		name, ok := safeName(f.Name)
+		if !ok {
+			if err := fn(f.Name, nil, errUnsafePath); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := s.checkSize(f); err != nil {
+			if err := fn(name, nil, err); err != nil {
+				return err
+			}
+			continue
+		}
+		total += int64(f.UncompressedSize64)
+		if total > s.limits.MaxTotalBytes {
+			return ErrTooLarge
+		}
+
+		rc, err := f.Open()
+		if err != nil {
+			if err := fn(name, nil, err); err != nil {
+				return err
+			}
+			continue
+		}
+		err = fn(name, &capReader{r: rc, left: s.limits.MaxFileBytes}, nil)
+		rc.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *zipSource) checkSize(f *zip.File) error {
+	size := int64(f.UncompressedSize64)
+	if size > s.limits.MaxFileBytes {
+		return errFileTooLarge
+	}
+	if f.CompressedSize64 > 0 && size/int64(f.CompressedSize64) > s.limits.MaxRatio {
+		return errCompressionRatio
+	}
+	return nil
+}
+
+// OpenDir reads the files under the server directory root. Symbolic links
+// are not followed, so the import cannot reach outside root.
+func OpenDir(root string, limits Limits) (Source, error) {
+	info, err := os.Stat(root)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return nil, fmt.Errorf("importer: %s is not a directory", root)
+	}
+	return &dirSource{root: root, limits: limits}, nil
+}
+
+type dirSource struct {
+	root   string
+	limits Limits
+}
+
+func (s *dirSource) Close() error {
+	return nil
+}
+
+func (s *dirSource) Walk(fn func(name string, r io.Reader, err error) error) error {
+	var count int
+	var total int64
+	return filepath.WalkDir(s.root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, relErr := filepath.Rel(s.root, p)
+		if relErr != nil {
+			return relErr
+		}
+		name := filepath.ToSlash(rel)
+		if d.IsDir() {
+			if p != s.root && hidden(name) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !d.Type().IsRegular() || hidden(name) {
+			return nil
+		}
+
+		count++
+		if count > s.limits.MaxFiles {
+			return ErrTooManyFiles
+		}
+		info, err := d.Info()
+		if err != nil {
+			return fn(name, nil, err)
+		}
+		if info.Size() > s.limits.MaxFileBytes {
+			return fn(name, nil, errFileTooLarge)
+		}
+		total += info.Size()
+		if total > s.limits.MaxTotalBytes {
+			return ErrTooLarge
+		}
+
+		f, err := os.Open(p)
+		if err != nil {
+			return fn(name, nil, err)
+		}
+		defer f.Close()
+		// the file may have grown since it was stat'ed
+		return fn(name, &capReader{r: f, left: s.limits.MaxFileBytes}, nil)
+	})
+}
+
+// capReader fails with errFileTooLarge once a file turns out longer than
+// left, where io.LimitReader would quietly cut it short
+type capReader struct {
+	r    io.Reader
+	left int64
+}
+
+func (c *capReader) Read(p []byte) (int, error) {
+	if c.left < 0 {
+		return 0, errFileTooLarge
+	}
+	// one byte past the cap tells a file that ends there from a longer one
+	if int64(len(p)) > c.left+1 {
+		p = p[:c.left+1]
+	}
+	n, err := c.r.Read(p)
+	c.left -= int64(n)
+	if c.left < 0 {
+		return n + int(c.left), errFileTooLarge
+	}
+	return n, err
+}
+
+// safeName cleans an archive entry name, refusing absolute paths and any
+// that climb out of the archive. Drive letters are refused whatever the
+// server runs on, since filepath only knows them on Windows.
+func safeName(name string) (string, bool) {
+	name = strings.ReplaceAll(name, "\\", "/")
+	if path.IsAbs(name) || hasDrive(name) {
+		return "", false
+	}
+	clean := path.Clean(name)
+	if clean == ".." || strings.HasPrefix(clean, "../") {
+		return "", false
+	}
+	return clean, true
+}
+
+// hasDrive reports whether name starts with a Windows drive, e.g. "C:"
+func hasDrive(name string) bool {
+	if len(name) < 2 || name[1] != ':' {
+		return false
+	}
+	c := name[0] | 0x20
+	return 'a' <= c && c <= 'z'
+}
+
+// hidden skips dotfiles and the metadata folders macOS adds to archives
+func hidden(name string) bool {
+	for _, part := range strings.Split(strings.ReplaceAll(name, "\\", "/"), "/") {
+		if strings.HasPrefix(part, ".") && part != "." && part != ".." || part == "__MACOSX" {
+			return true
+		}
+	}
+	return false
+}
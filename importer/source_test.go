@@ -0,0 +1,286 @@
+package importer
+
+import (
+	"archive/zip"
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// testLimits are small enough to reach in a test
+var testLimits = Limits{
+	MaxFiles:      10,
+	MaxFileBytes:  1 << 10,
+	MaxTotalBytes: 4 << 10,
+	MaxRatio:      50,
+}
+
+// walked is what a Walk handed to its callback for one file
+type walked struct {
+	data string
+	err  error
+}
+
+func walk(t *testing.T, src Source) (map[string]walked, error) {
+	t.Helper()
+	files := make(map[string]walked)
+	err := src.Walk(func(name string, r io.Reader, err error) error {
+		if err == nil {
+			var b []byte
+			b, err = io.ReadAll(r)
+			files[name] = walked{data: string(b), err: err}
+			return nil
+		}
+		files[name] = walked{err: err}
+		return nil
+	})
+	return files, err
+}
+
+type zipFile struct {
+	name   string
+	data   []byte
+	method uint16
+}
+
+// writeZip builds an archive without the checks an unzip tool would make
+func writeZip(t *testing.T, files ...zipFile) string {
+	t.Helper()
+	name := filepath.Join(t.TempDir(), "import.zip")
+	f, err := os.Create(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	zw := zip.NewWriter(f)
+	for _, zf := range files {
+		w, err := zw.CreateHeader(&zip.FileHeader{Name: zf.name, Method: zf.method})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write(zf.data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return name
+}
+
+func openZip(t *testing.T, name string, limits Limits) Source {
+	t.Helper()
+	src, err := OpenZip(name, limits)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { src.Close() })
+	return src
+}
+
+func TestSafeName(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+		ok   bool
+	}{
+		{"photo.jpg", "photo.jpg", true},
+		{"shoot/./photo.jpg", "shoot/photo.jpg", true},
+		{"shoot/../photo.jpg", "photo.jpg", true},
+		{`shoot\photo.jpg`, "shoot/photo.jpg", true},
+		{"..", "", false},
+		{"../x", "", false},
+		{"shoot/../../x", "", false},
+		{`..\x`, "", false},
+		{"/etc/passwd", "", false},
+		{`\x`, "", false},
+		{`C:\x`, "", false},
+		{"c:/x", "", false},
+		{"C:x", "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := safeName(tt.name)
+			if got != tt.want || ok != tt.ok {
+				t.Fatalf("safeName(%q) = %q, %v, want %q, %v", tt.name, got, ok, tt.want, tt.ok)
+			}
+		})
+	}
+}
+
+func TestCheckSize(t *testing.T) {
+	s := &zipSource{limits: testLimits}
+	tests := []struct {
+		name         string
+		size, packed uint64
+		want         error
+	}{
+		{"photo", 1000, 990, nil},
+		{"empty", 0, 0, nil},
+		{"at the ratio", 1000, 20, nil},
+		{"too large", uint64(testLimits.MaxFileBytes) + 1, uint64(testLimits.MaxFileBytes), errFileTooLarge},
+		{"past the ratio", 1000, 19, errCompressionRatio},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := &zip.File{FileHeader: zip.FileHeader{UncompressedSize64: tt.size, CompressedSize64: tt.packed}}
+			if err := s.checkSize(f); err != tt.want {
+				t.Fatalf("checkSize of %d bytes packed into %d = %v, want %v", tt.size, tt.packed, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestZipSourceWalk(t *testing.T) {
+	name := writeZip(t,
+		zipFile{name: "photo.jpg", data: []byte("photo")},
+		zipFile{name: "shoot/", method: zip.Store},
+		zipFile{name: "shoot/other.jpg", data: []byte("other")},
+		zipFile{name: "../x", data: []byte("escapes")},
+		zipFile{name: "/etc/cron.d/x", data: []byte("absolute")},
+		zipFile{name: `C:\x`, data: []byte("drive")},
+		zipFile{name: ".DS_Store", data: []byte("hidden")},
+		zipFile{name: "__MACOSX/._photo.jpg", data: []byte("metadata")},
+		zipFile{name: "bomb.jpg", data: make([]byte, testLimits.MaxFileBytes), method: zip.Deflate},
+	)
+	files, err := walk(t, openZip(t, name, testLimits))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]walked{
+		"photo.jpg":       {data: "photo"},
+		"shoot/other.jpg": {data: "other"},
+		"../x":            {err: errUnsafePath},
+		"/etc/cron.d/x":   {err: errUnsafePath},
+		`C:\x`:            {err: errUnsafePath},
+		"bomb.jpg":        {err: errCompressionRatio},
+	}
+	if len(files) != len(want) {
+		t.Errorf("walked %v, want %v", files, want)
+	}
+	for name, w := range want {
+		if got, ok := files[name]; !ok || got != w {
+			t.Errorf("%s: got %+v, want %+v", name, got, w)
+		}
+	}
+}
+
+func TestZipSourceLimits(t *testing.T) {
+	var many []zipFile
+	for i := 0; i <= testLimits.MaxFiles; i++ {
+		many = append(many, zipFile{name: strings.Repeat("x", i+1) + ".jpg", data: []byte("photo")})
+	}
+	if _, err := walk(t, openZip(t, writeZip(t, many...), testLimits)); err != ErrTooManyFiles {
+		t.Fatalf("Walk of %d files = %v, want ErrTooManyFiles", len(many), err)
+	}
+
+	var large []zipFile
+	for i := int64(0); i <= testLimits.MaxTotalBytes/testLimits.MaxFileBytes; i++ {
+		large = append(large, zipFile{name: strings.Repeat("x", int(i)+1) + ".jpg", data: bytes.Repeat([]byte("x"), int(testLimits.MaxFileBytes))})
+	}
+	limits := testLimits
+	limits.MaxRatio = 1 << 20
+	if _, err := walk(t, openZip(t, writeZip(t, large...), limits)); err != ErrTooLarge {
+		t.Fatalf("Walk of %d full sized files = %v, want ErrTooLarge", len(large), err)
+	}
+}
+
+func writeFile(t *testing.T, name string, data []byte) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(name, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDirSourceWalk(t *testing.T) {
+	outside := t.TempDir()
+	writeFile(t, filepath.Join(outside, "secret.jpg"), []byte("secret"))
+
+	root := t.TempDir()
+	writeFile(t, filepath.Join(root, "photo.jpg"), []byte("photo"))
+	writeFile(t, filepath.Join(root, "shoot", "other.jpg"), []byte("other"))
+	writeFile(t, filepath.Join(root, ".hidden", "photo.jpg"), []byte("hidden"))
+	writeFile(t, filepath.Join(root, "large.jpg"), make([]byte, testLimits.MaxFileBytes+1))
+	if err := os.Symlink(filepath.Join(outside, "secret.jpg"), filepath.Join(root, "link.jpg")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(outside, filepath.Join(root, "linked")); err != nil {
+		t.Fatal(err)
+	}
+
+	src, err := OpenDir(root, testLimits)
+	if err != nil {
+		t.Fatal(err)
+	}
+	files, err := walk(t, src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]walked{
+		"photo.jpg":       {data: "photo"},
+		"shoot/other.jpg": {data: "other"},
+		"large.jpg":       {err: errFileTooLarge},
+	}
+	if len(files) != len(want) {
+		t.Errorf("walked %v, want %v", files, want)
+	}
+	for name, w := range want {
+		if got, ok := files[name]; !ok || got != w {
+			t.Errorf("%s: got %+v, want %+v", name, got, w)
+		}
+	}
+}
+
+func TestDirSourceWalkFileGrowingPastTheLimit(t *testing.T) {
+	root := t.TempDir()
+	name := filepath.Join(root, "growing.jpg")
+	writeFile(t, name, []byte("photo"))
+
+	src, err := OpenDir(root, testLimits)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var readErr error
+	err = src.Walk(func(_ string, r io.Reader, err error) error {
+		if err != nil {
+			t.Fatalf("Walk refused the file before it grew: %v", err)
+		}
+		// written to after the size was checked
+		writeFile(t, name, make([]byte, 2*testLimits.MaxFileBytes))
+		b, err := io.ReadAll(r)
+		if int64(len(b)) > testLimits.MaxFileBytes {
+			t.Errorf("read %d bytes past the limit", len(b))
+		}
+		readErr = err
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if readErr != errFileTooLarge {
+		t.Fatalf("reading a file that grew past the limit = %v, want errFileTooLarge", readErr)
+	}
+}
+
+func TestDirSourceLimits(t *testing.T) {
+	root := t.TempDir()
+	for i := 0; i <= testLimits.MaxFiles; i++ {
+		writeFile(t, filepath.Join(root, strings.Repeat("x", i+1)+".jpg"), []byte("photo"))
+	}
+	src, err := OpenDir(root, testLimits)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := walk(t, src); err != ErrTooManyFiles {
+		t.Fatalf("Walk of %d files = %v, want ErrTooManyFiles", testLimits.MaxFiles+1, err)
+	}
+}
@@ -0,0 +1,164 @@
+package jobs
+
+import (
+	"context"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+// DB is a Queue in the jobs table, shared by every app process. On Postgres
+// workers claim jobs with SELECT ... FOR UPDATE SKIP LOCKED, so they never
+// wait on each other; other databases such as SQLite in development claim
+// with a conditional update instead.
+type DB struct {
+	db       *gorm.DB
+	postgres bool
+}
+
+// NewDB instantiates a queue on db; the jobs table is migrated with the models
+func NewDB(db *gorm.DB) *DB {
+	return &DB{
+		db:       db,
+		postgres: db.Dialect().GetName() == "postgres",
+	}
+}
+
+func (q *DB) Enqueue(ctx context.Context, kind string, payload interface{}, opts ...Option) (*Job, error) {
+	job, err := newJob(kind, payload, opts)
+	if err != nil {
+		return nil, err
+	}
+	if job.Key != nil && q.keyTaken(*job.Key) {
+		return nil, ErrDuplicate
+	}
+	if err := q.db.Create(job).Error; err != nil {
+		// another process may have taken the key since it was checked
+		if job.Key != nil && q.keyTaken(*job.Key) {
+			return nil, ErrDuplicate
+		}
+		return nil, err
+	}
+	return job, nil
+}
+
+func (q *DB) keyTaken(key string) bool {
+	var count int
+	q.db.Model(&Job{}).Where("key = ?", key).Count(&count)
+	return count > 0
+}
+
+// due matches jobs ready to run, including ones abandoned by a dead worker
+const due = `(status = ? AND run_at <= ?) OR (status = ? AND locked_at < ?)`
+
+func (q *DB) Claim(ctx context.Context) (*Job, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	args := []interface{}{StatusQueued, now, StatusRunning, now.Add(-Timeout - time.Minute)}
+	if q.postgres {
+		return q.claimSkipLocked(now, args)
+	}
+	return q.claimConditional(now, args)
+}
+
+func (q *DB) claimSkipLocked(now time.Time, args []interface{}) (*Job, error) {
+	var jobs []Job
+	err := q.db.Raw(`UPDATE jobs SET status = ?, attempts = attempts + 1, locked_at = ?, updated_at = ?
+		WHERE id = (SELECT id FROM jobs WHERE `+due+` ORDER BY run_at, id LIMIT 1 FOR UPDATE SKIP LOCKED)
+		RETURNING *`, append([]interface{}{StatusRunning, now, now}, args...)...).Scan(&jobs).Error
+	if err != nil && err != gorm.ErrRecordNotFound {
+		return nil, err
+	}
+	if len(jobs) == 0 {
+		return nil, nil
+	}
+	return &jobs[0], nil
+}
+
+// claimConditional picks a due job and only takes it if no other worker
+// changed it in between, trying the next one otherwise
+func (q *DB) claimConditional(now time.Time, args []interface{}) (*Job, error) {
+	for i := 0; i < 5; i++ {
+		var job Job
+		err := q.db.Where(due, args...).Order("run_at, id").First(&job).Error
+		if err == gorm.ErrRecordNotFound {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		res := q.db.Model(&Job{}).
+			Where("id = ? AND status = ? AND attempts = ?", job.ID, job.Status, job.Attempts).
+			UpdateColumns(map[string]interface{}{
+				"status":     StatusRunning,
+				"attempts":   job.Attempts + 1,
+				"locked_at":  now,
+				"updated_at": now,
+			})
+		if res.Error != nil {
+			return nil, res.Error
+		}
+		if res.RowsAffected == 1 {
+			job.Status = StatusRunning
+			job.Attempts++
+			job.LockedAt = &now
+			job.UpdatedAt = now
+			return &job, nil
+		}
+	}
+	return nil, nil
+}
+
+func (q *DB) Complete(ctx context.Context, job *Job) error {
+	job.Status = StatusDone
+	job.LockedAt = nil
+	return q.save(job)
+}
+
+func (q *DB) Fail(ctx context.Context, job *Job, err error) error {
+	failed(job, err, time.Now())
+	return q.save(job)
+}
+
+// save writes the outcome of an attempt
+func (q *DB) save(job *Job) error {
+	return q.db.Model(job).UpdateColumns(map[string]interface{}{
+		"status":     job.Status,
+		"run_at":     job.RunAt,
+		"last_error": job.LastError,
+		"locked_at":  job.LockedAt,
+		"updated_at": time.Now(),
+	}).Error
+}
+
+func (q *DB) List(ctx context.Context, status string, limit int) ([]Job, error) {
+	var jobs []Job
+	err := q.db.Where("status = ?", status).Order("updated_at DESC, id DESC").Limit(limit).Find(&jobs).Error
+	if err != nil {
+		return nil, err
+	}
+	return jobs, nil
+}
+
+func (q *DB) Retry(ctx context.Context, id uint) error {
+	res := q.db.Model(&Job{}).Where("id = ? AND status = ?", id, StatusDead).UpdateColumns(map[string]interface{}{
+		"status":     StatusQueued,
+		"attempts":   0,
+		"run_at":     time.Now(),
+		"updated_at": time.Now(),
+	})
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (q *DB) Prune(ctx context.Context, before time.Time) (int64, error) {
+	res := q.db.Where("status IN (?) AND updated_at < ?", []string{StatusDone, StatusDead}, before).Delete(&Job{})
+	return res.RowsAffected, res.Error
+}
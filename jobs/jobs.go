@@ -0,0 +1,172 @@
+// Package jobs runs work outside the request path: a durable queue with
+// retries, dead-lettering and scheduled jobs, and a pool of workers draining it
+package jobs
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+)
+
+// Job statuses
+const (
+	StatusQueued  = "queued"
+	StatusRunning = "running"
+	StatusDone    = "done"
+
+	// StatusDead jobs failed their last attempt and wait for someone to retry them
+	StatusDead = "dead"
+)
+
+const (
+	// DefaultMaxAttempts is how often a job is tried before it is dead-lettered
+	DefaultMaxAttempts = 5
+
+	// Timeout cancels a running job; a job locked for longer than this is
+	// assumed to belong to a worker that died, and is claimed again
+	Timeout = time.Hour
+)
+
+var (
+	// ErrDuplicate is returned when enqueueing a job with a key that is already taken
+	ErrDuplicate = errors.New("jobs: a job with this key already exists")
+
+	// ErrNotFound is returned for unknown job IDs, and when retrying a job that is not dead
+	ErrNotFound = errors.New("jobs: job not found")
+)
+
+// Job is a unit of work of a kind, e.g. "email.send", with a JSON payload
+type Job struct {
+	ID      uint   `gorm:"primary_key"`
+	Kind    string `gorm:"not_null;index"`
+	Payload string `gorm:"type:text"`
+
+	// Key, when set, makes the job unique, e.g. one purge per day
+	Key *string `gorm:"unique_index"`
+
+	Status      string    `gorm:"not_null;index:idx_jobs_due"`
+	RunAt       time.Time `gorm:"not_null;index:idx_jobs_due"`
+	Attempts    int       `gorm:"not_null;default:0"`
+	MaxAttempts int       `gorm:"not_null"`
+
+	// LastError is why the last attempt failed
+	LastError string `gorm:"type:text"`
+	LockedAt  *time.Time
+
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+// Decode unmarshals the payload into v
+func (j *Job) Decode(v interface{}) error {
+	return json.Unmarshal([]byte(j.Payload), v)
+}
+
+// Handler does the work of one job; returning an error retries it later
+type Handler func(ctx context.Context, job *Job) error
+
+// Queue stores jobs until a worker claims them
+type Queue interface {
+	// Enqueue adds a job of kind with payload encoded as JSON
+	Enqueue(ctx context.Context, kind string, payload interface{}, opts ...Option) (*Job, error)
+
+	// Claim locks the next due job for the caller, or returns nil when none is due
+	Claim(ctx context.Context) (*Job, error)
+
+	// Complete marks a claimed job as done
+	Complete(ctx context.Context, job *Job) error
+
+	// Fail records err on a claimed job and schedules it again, or
+	// dead-letters it after its last attempt
+	Fail(ctx context.Context, job *Job, err error) error
+
+	// List returns up to limit jobs with the status, most recently changed first
+	List(ctx context.Context, status string, limit int) ([]Job, error)
+
+	// Retry queues a dead job again with fresh attempts
+	Retry(ctx context.Context, id uint) error
+
+	// Prune deletes done and dead jobs last changed before t
+	Prune(ctx context.Context, before time.Time) (int64, error)
+}
+
+// Option changes how a job is enqueued
+type Option func(*Job)
+
+// At delays a job until t
+func At(t time.Time) Option {
+	return func(j *Job) {
+		j.RunAt = t
+	}
+}
+
+// MaxAttempts sets how often a job is tried; 1 never retries it
+func MaxAttempts(n int) Option {
+	return func(j *Job) {
+		j.MaxAttempts = n
+	}
+}
+
+// Key makes the job unique; enqueueing another job with it fails with ErrDuplicate
+func Key(key string) Option {
+	return func(j *Job) {
+		j.Key = &key
+	}
+}
+
+// newJob builds a queued job from the Enqueue arguments
+func newJob(kind string, payload interface{}, opts []Option) (*Job, error) {
+	b, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+	job := Job{
+		Kind:        kind,
+		Payload:     string(b),
+		Status:      StatusQueued,
+		RunAt:       time.Now(),
+		MaxAttempts: DefaultMaxAttempts,
+	}
+	for _, opt := range opts {
+		opt(&job)
+	}
+	return &job, nil
+}
+
+// Backoff is the delay before retrying a job that failed attempt times:
+// 30 seconds, doubling up to an hour
+func Backoff(attempt int) time.Duration {
+	d := 30 * time.Second
+	for i := 1; i < attempt && d < time.Hour; i++ {
+		d *= 2
+	}
+	if d > time.Hour {
+		d = time.Hour
+	}
+	return d
+}
+
+// failed applies a failed attempt to job: it is retried after Backoff until
+// it runs out of attempts
+func failed(job *Job, err error, now time.Time) {
+	job.LastError = err.Error()
+	job.LockedAt = nil
+	if job.Attempts >= job.MaxAttempts {
+		job.Status = StatusDead
+		return
+	}
+	job.Status = StatusQueued
+	job.RunAt = now.Add(Backoff(job.Attempts))
+}
+
+// JobPrune is the kind of the scheduled job that deletes old finished jobs
+const JobPrune = "jobs.prune"
+
+// Prune returns the handler for JobPrune jobs, keeping finished jobs for retention
+func Prune(q Queue, retention time.Duration) Handler {
+	return func(ctx context.Context, job *Job) error {
+		_, err := q.Prune(ctx, time.Now().Add(-retention))
+		return err
+	}
+}
@@ -0,0 +1,149 @@
+package jobs
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestBackoff(t *testing.T) {
+	cases := []struct {
+		attempt int
+		want    time.Duration
+	}{
+		{1, 30 * time.Second},
+		{2, time.Minute},
+		{3, 2 * time.Minute},
+		{7, 32 * time.Minute},
+		{8, time.Hour},
+		{50, time.Hour},
+	}
+	for _, c := range cases {
+		if got := Backoff(c.attempt); got != c.want {
+			t.Errorf("Backoff(%d) = %s, want %s", c.attempt, got, c.want)
+		}
+	}
+}
+
+func TestFailDeadLettersAfterMaxAttempts(t *testing.T) {
+	ctx := context.Background()
+	q := NewMemory()
+	queued, err := q.Enqueue(ctx, "test", nil, MaxAttempts(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	job := claim(t, q)
+	if err := q.Fail(ctx, job, errors.New("first")); err != nil {
+		t.Fatal(err)
+	}
+	if job.Status != StatusQueued {
+		t.Fatalf("status after attempt 1 = %q, want %q", job.Status, StatusQueued)
+	}
+	if job, _ := q.Claim(ctx); job != nil {
+		t.Fatal("claimed a job before its backoff ran out")
+	}
+
+	makeDue(q, queued.ID)
+	job = claim(t, q)
+	if err := q.Fail(ctx, job, errors.New("second")); err != nil {
+		t.Fatal(err)
+	}
+	stored := q.jobs[queued.ID]
+	if stored.Status != StatusDead || stored.LastError != "second" {
+		t.Fatalf("after the last attempt got status %q error %q, want dead with the last error", stored.Status, stored.LastError)
+	}
+	makeDue(q, queued.ID)
+	if job, _ := q.Claim(ctx); job != nil {
+		t.Fatal("claimed a dead job")
+	}
+}
+
+func TestRetryQueuesDeadJobsOnly(t *testing.T) {
+	ctx := context.Background()
+	q := NewMemory()
+	queued, err := q.Enqueue(ctx, "test", nil, MaxAttempts(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := q.Retry(ctx, queued.ID); err != ErrNotFound {
+		t.Fatalf("Retry of a queued job = %v, want ErrNotFound", err)
+	}
+
+	job := claim(t, q)
+	if err := q.Fail(ctx, job, errors.New("boom")); err != nil {
+		t.Fatal(err)
+	}
+	if err := q.Retry(ctx, queued.ID); err != nil {
+		t.Fatal(err)
+	}
+	job = claim(t, q)
+	if job.ID != queued.ID || job.Attempts != 1 {
+		t.Fatalf("claimed job %d on attempt %d, want job %d with fresh attempts", job.ID, job.Attempts, queued.ID)
+	}
+	if err := q.Retry(ctx, 99); err != ErrNotFound {
+		t.Fatalf("Retry of an unknown job = %v, want ErrNotFound", err)
+	}
+}
+
+func TestPruneDeletesOldFinishedJobs(t *testing.T) {
+	ctx := context.Background()
+	q := NewMemory()
+	for _, kind := range []string{"done", "dead", "queued"} {
+		if _, err := q.Enqueue(ctx, kind, nil, MaxAttempts(1)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	done := claim(t, q)
+	if err := q.Complete(ctx, done); err != nil {
+		t.Fatal(err)
+	}
+	dead := claim(t, q)
+	if err := q.Fail(ctx, dead, errors.New("boom")); err != nil {
+		t.Fatal(err)
+	}
+
+	n, err := q.Prune(ctx, time.Now().Add(-time.Hour))
+	if err != nil || n != 0 {
+		t.Fatalf("Prune of recent jobs = %d, %v, want nothing pruned", n, err)
+	}
+	n, err = q.Prune(ctx, time.Now().Add(time.Hour))
+	if err != nil || n != 2 {
+		t.Fatalf("Prune = %d, %v, want the done and dead jobs pruned", n, err)
+	}
+	if len(q.jobs) != 1 || q.jobs[3] == nil {
+		t.Fatalf("left %d jobs, want only the queued one", len(q.jobs))
+	}
+}
+
+func TestEnqueueDuplicateKey(t *testing.T) {
+	ctx := context.Background()
+	q := NewMemory()
+	if _, err := q.Enqueue(ctx, "test", nil, Key("once")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := q.Enqueue(ctx, "test", nil, Key("once")); err != ErrDuplicate {
+		t.Fatalf("second Enqueue = %v, want ErrDuplicate", err)
+	}
+}
+
+// claim claims the next job, failing the test when none is due
+func claim(t *testing.T, q *Memory) *Job {
+	t.Helper()
+	job, err := q.Claim(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if job == nil {
+		t.Fatal("no job due")
+	}
+	return job
+}
+
+// makeDue skips the backoff of a queued job
+func makeDue(q *Memory, id uint) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.jobs[id].RunAt = time.Now()
+}
@@ -0,0 +1,149 @@
+package jobs
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Memory is a Queue for tests and single process tools; its jobs are lost
+// when the process exits
+type Memory struct {
+	mu     sync.Mutex
+	nextID uint
+	jobs   map[uint]*Job
+}
+
+// NewMemory instantiates an empty in-memory queue
+func NewMemory() *Memory {
+	return &Memory{jobs: make(map[uint]*Job)}
+}
+
+func (q *Memory) Enqueue(ctx context.Context, kind string, payload interface{}, opts ...Option) (*Job, error) {
+	job, err := newJob(kind, payload, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if job.Key != nil {
+		for _, j := range q.jobs {
+			if j.Key != nil && *j.Key == *job.Key {
+				return nil, ErrDuplicate
+			}
+		}
+	}
+	q.nextID++
+	job.ID = q.nextID
+	job.CreatedAt = time.Now()
+	job.UpdatedAt = job.CreatedAt
+	q.jobs[job.ID] = job
+	queued := *job
+	return &queued, nil
+}
+
+func (q *Memory) Claim(ctx context.Context) (*Job, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	now := time.Now()
+	var next *Job
+	for _, j := range q.jobs {
+		ready := j.Status == StatusQueued && !j.RunAt.After(now) ||
+			j.Status == StatusRunning && j.LockedAt != nil && j.LockedAt.Before(now.Add(-Timeout-time.Minute))
+		if !ready {
+			continue
+		}
+		if next == nil || j.RunAt.Before(next.RunAt) || j.RunAt.Equal(next.RunAt) && j.ID < next.ID {
+			next = j
+		}
+	}
+	if next == nil {
+		return nil, nil
+	}
+	next.Status = StatusRunning
+	next.Attempts++
+	next.LockedAt = &now
+	next.UpdatedAt = now
+	claimed := *next
+	return &claimed, nil
+}
+
+func (q *Memory) Complete(ctx context.Context, job *Job) error {
+	job.Status = StatusDone
+	job.LockedAt = nil
+	return q.save(job)
+}
+
+func (q *Memory) Fail(ctx context.Context, job *Job, err error) error {
+	failed(job, err, time.Now())
+	return q.save(job)
+}
+
+func (q *Memory) save(job *Job) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	j, ok := q.jobs[job.ID]
+	if !ok {
+		return ErrNotFound
+	}
+	j.Status = job.Status
+	j.RunAt = job.RunAt
+	j.LastError = job.LastError
+	j.LockedAt = job.LockedAt
+	j.UpdatedAt = time.Now()
+	return nil
+}
+
+func (q *Memory) List(ctx context.Context, status string, limit int) ([]Job, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	var jobs []Job
+	for _, j := range q.jobs {
+		if j.Status == status {
+			jobs = append(jobs, *j)
+		}
+	}
+	sort.Slice(jobs, func(a, b int) bool {
+		if !jobs[a].UpdatedAt.Equal(jobs[b].UpdatedAt) {
+			return jobs[a].UpdatedAt.After(jobs[b].UpdatedAt)
+		}
+		return jobs[a].ID > jobs[b].ID
+	})
+	if len(jobs) > limit {
+		jobs = jobs[:limit]
+	}
+	return jobs, nil
+}
+
+func (q *Memory) Retry(ctx context.Context, id uint) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	j, ok := q.jobs[id]
+	if !ok || j.Status != StatusDead {
+		return ErrNotFound
+	}
+	j.Status = StatusQueued
+	j.Attempts = 0
+	j.RunAt = time.Now()
+	j.UpdatedAt = j.RunAt
+	return nil
+}
+
+func (q *Memory) Prune(ctx context.Context, before time.Time) (int64, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	var n int64
+	for id, j := range q.jobs {
+		if (j.Status == StatusDone || j.Status == StatusDead) && j.UpdatedAt.Before(before) {
+			delete(q.jobs, id)
+			n++
+		}
+	}
+	return n, nil
+}
@@ -0,0 +1,160 @@
+package jobs
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/jhampac/picha/metrics"
+)
+
+// Pool runs the jobs of a queue with a fixed number of workers, and enqueues
+// scheduled jobs when they are due
+type Pool struct {
+	Queue   Queue
+	Workers int
+
+	// Poll is how long an idle worker waits before looking for jobs again
+	Poll time.Duration
+	Log  *slog.Logger
+
+	handlers  map[string]Handler
+	schedules []schedule
+}
+
+// schedule enqueues a job of kind once per interval
+type schedule struct {
+	kind     string
+	interval time.Duration
+}
+
+// NewPool instantiates a pool of workers for q
+func NewPool(q Queue, workers int, log *slog.Logger) *Pool {
+	return &Pool{
+		Queue:    q,
+		Workers:  workers,
+		Poll:     time.Second,
+		Log:      log,
+		handlers: make(map[string]Handler),
+	}
+}
+
+// Handle registers the handler for jobs of kind; it must be called before Run
+func (p *Pool) Handle(kind string, h Handler) {
+	p.handlers[kind] = h
+}
+
+// Every enqueues a job of kind with an empty payload once per interval. The
+// job's key is the start of the interval, so several app processes running
+// the same schedule still only enqueue it once.
+func (p *Pool) Every(kind string, interval time.Duration) {
+	p.schedules = append(p.schedules, schedule{kind: kind, interval: interval})
+}
+
+// Run works on jobs until ctx is cancelled, then waits for the running jobs
+// to return
+func (p *Pool) Run(ctx context.Context) {
+	var wg sync.WaitGroup
+	for _, s := range p.schedules {
+		wg.Add(1)
+		go func(s schedule) {
+			defer wg.Done()
+			p.schedule(ctx, s)
+		}(s)
+	}
+	for i := 0; i < p.Workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			p.work(ctx)
+		}()
+	}
+	wg.Wait()
+}
+
+func (p *Pool) schedule(ctx context.Context, s schedule) {
+	for {
+		slot := time.Now().Truncate(s.interval)
+		_, err := p.Queue.Enqueue(ctx, s.kind, struct{}{}, Key(fmt.Sprintf("%s@%s", s.kind, slot.UTC().Format(time.RFC3339))))
+		if err != nil && err != ErrDuplicate {
+			p.Log.ErrorContext(ctx, "scheduling job", "kind", s.kind, "error", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(time.Until(slot.Add(s.interval))):
+		}
+	}
+}
+
+// work claims jobs until none is due, then sleeps for Poll
+func (p *Pool) work(ctx context.Context) {
+	for {
+		job, err := p.Queue.Claim(ctx)
+		if err != nil && ctx.Err() == nil {
+			p.Log.ErrorContext(ctx, "claiming job", "error", err)
+		}
+		if job != nil {
+			p.run(ctx, job)
+			continue
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(p.Poll):
+		}
+	}
+}
+
+// run handles one claimed job and records the outcome. The outcome is saved
+// even when ctx was cancelled meanwhile, so the job is retried rather than
+// waiting for its lock to time out.
+func (p *Pool) run(ctx context.Context, job *Job) {
+	log := p.Log.With("job_id", job.ID, "kind", job.Kind, "attempt", job.Attempts)
+	start := time.Now()
+	err := p.handle(ctx, job)
+
+	save := context.Background()
+	if err == nil {
+		if err := p.Queue.Complete(save, job); err != nil {
+			log.Error("completing job", "error", err)
+		}
+		metrics.Jobs.WithLabelValues(job.Kind, "done").Inc()
+		log.Info("job done", "latency_ms", float64(time.Since(start).Microseconds())/1000)
+		return
+	}
+
+	if ferr := p.Queue.Fail(save, job, err); ferr != nil {
+		log.Error("failing job", "error", ferr)
+	}
+	if job.Status == StatusDead {
+		metrics.Jobs.WithLabelValues(job.Kind, "dead").Inc()
+		log.Error("job dead-lettered", "error", err)
+		return
+	}
+	metrics.Jobs.WithLabelValues(job.Kind, "retry").Inc()
+	log.Warn("job failed, retrying", "error", err, "retry_at", job.RunAt)
+}
+
+// handle calls the job's handler with a Timeout, turning panics into errors.
+// A job claimed again after its worker died has used up an attempt already.
+func (p *Pool) handle(ctx context.Context, job *Job) (err error) {
+	h, ok := p.handlers[job.Kind]
+	if !ok {
+		return fmt.Errorf("jobs: no handler for %q", job.Kind)
+	}
+	if job.Attempts > job.MaxAttempts {
+		job.Attempts = job.MaxAttempts
+		return fmt.Errorf("jobs: abandoned by its worker")
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("jobs: panic: %v", r)
+		}
+	}()
+	ctx, cancel := context.WithTimeout(ctx, Timeout)
+	defer cancel()
+	return h(ctx, job)
+}
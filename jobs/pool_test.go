@@ -0,0 +1,112 @@
+package jobs
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"testing"
+	"time"
+)
+
+func newTestPool(q Queue) *Pool {
+	return NewPool(q, 1, slog.New(slog.NewTextHandler(io.Discard, nil)))
+}
+
+func TestPoolRetriesFailedJobs(t *testing.T) {
+	ctx := context.Background()
+	q := NewMemory()
+	p := newTestPool(q)
+	calls := 0
+	p.Handle("flaky", func(ctx context.Context, job *Job) error {
+		calls++
+		if calls == 1 {
+			return errors.New("try again")
+		}
+		return nil
+	})
+	queued, err := q.Enqueue(ctx, "flaky", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	before := time.Now()
+	p.run(ctx, claim(t, q))
+	stored := q.jobs[queued.ID]
+	if stored.Status != StatusQueued || stored.LastError != "try again" {
+		t.Fatalf("after a failure got status %q error %q, want it queued with the error", stored.Status, stored.LastError)
+	}
+	if wait := stored.RunAt.Sub(before); wait < Backoff(1) || wait > Backoff(1)+time.Minute {
+		t.Fatalf("retry scheduled in %s, want about %s", wait, Backoff(1))
+	}
+
+	makeDue(q, queued.ID)
+	p.run(ctx, claim(t, q))
+	if stored.Status != StatusDone || calls != 2 {
+		t.Fatalf("after a success got status %q after %d calls, want done after 2", stored.Status, calls)
+	}
+}
+
+func TestPoolTurnsPanicsIntoFailures(t *testing.T) {
+	ctx := context.Background()
+	q := NewMemory()
+	p := newTestPool(q)
+	p.Handle("panics", func(ctx context.Context, job *Job) error {
+		panic("oops")
+	})
+	queued, err := q.Enqueue(ctx, "panics", nil, MaxAttempts(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.run(ctx, claim(t, q))
+	if stored := q.jobs[queued.ID]; stored.Status != StatusDead || stored.LastError != "jobs: panic: oops" {
+		t.Fatalf("got status %q error %q, want the panic dead-lettered", stored.Status, stored.LastError)
+	}
+}
+
+func TestPoolDeadLettersJobsAbandonedOnTheirLastAttempt(t *testing.T) {
+	ctx := context.Background()
+	q := NewMemory()
+	p := newTestPool(q)
+	called := false
+	p.Handle("slow", func(ctx context.Context, job *Job) error {
+		called = true
+		return nil
+	})
+	queued, err := q.Enqueue(ctx, "slow", nil, MaxAttempts(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the worker holding the last attempt died: the lock times out and the
+	// job is claimed once more
+	claim(t, q)
+	stale := time.Now().Add(-Timeout - 2*time.Minute)
+	q.jobs[queued.ID].LockedAt = &stale
+	job := claim(t, q)
+	if job.Attempts != 2 {
+		t.Fatalf("reclaimed on attempt %d, want 2", job.Attempts)
+	}
+
+	p.run(ctx, job)
+	if called {
+		t.Fatal("ran a job that used up its attempts")
+	}
+	stored := q.jobs[queued.ID]
+	if stored.Status != StatusDead || stored.LastError != "jobs: abandoned by its worker" {
+		t.Fatalf("got status %q error %q, want it dead-lettered as abandoned", stored.Status, stored.LastError)
+	}
+}
+
+func TestPoolFailsJobsWithoutHandler(t *testing.T) {
+	ctx := context.Background()
+	q := NewMemory()
+	queued, err := q.Enqueue(ctx, "unknown", nil, MaxAttempts(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	newTestPool(q).run(ctx, claim(t, q))
+	if stored := q.jobs[queued.ID]; stored.Status != StatusDead {
+		t.Fatalf("got status %q, want dead", stored.Status)
+	}
+}
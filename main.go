@@ -1,72 +1,293 @@
 package main
 
 import (
+	"context"
 	"fmt"
+	"log/slog"
 	"net/http"
+	"os"
+	"time"
 
+	"github.com/gorilla/csrf"
 	"github.com/gorilla/mux"
+	"github.com/jhampac/picha/cache"
 	"github.com/jhampac/picha/controller"
+	"github.com/jhampac/picha/email"
+	"github.com/jhampac/picha/importer"
+	"github.com/jhampac/picha/jobs"
+	"github.com/jhampac/picha/metrics"
 	"github.com/jhampac/picha/middleware"
 	"github.com/jhampac/picha/model"
-)
-
-const (
-	host     = "localhost"
-	port     = 5432
-	user     = "admin"
-	password = "testpassword"
-	dbname   = "picha_dev"
+	"github.com/jhampac/picha/storage"
+	"github.com/jhampac/picha/view"
 )
 
 func main() {
+	cfg, err := LoadConfig()
+	if err != nil {
+		panic(err)
+	}
+	if err := cfg.checkSecrets(); err != nil {
+		panic(err)
+	}
+
+	// structured JSON logging for the app and the data layer
+	level, err := parseLevel(cfg.LogLevel)
+	if err != nil {
+		panic(err)
+	}
+	logger := slog.New(slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{Level: level}))
+	slog.SetDefault(logger)
+
+	var sqlLevel *slog.Level
+	if cfg.SQLLogLevel != "off" {
+		l, err := parseLevel(cfg.SQLLogLevel)
+		if err != nil {
+			panic(err)
+		}
+		sqlLevel = &l
+	}
+
+	// file storage for uploads
+	store, err := storage.NewDisk(cfg.StorageDir)
+	if err != nil {
+		panic(err)
+	}
+	chunks, err := storage.NewChunks(cfg.UploadDir)
+	if err != nil {
+		panic(err)
+	}
+
+	// hot reads such as the signed in user are cached in process
+	var hot cache.Cache
+	if cfg.Cache.Size > 0 {
+		hot = cache.NewLRU(cfg.Cache.Size, time.Duration(cfg.Cache.TTL)*time.Second)
+	}
+
 	// db connection and service creation; data layer
-	psqlInfo := fmt.Sprintf("host=%s port=%d user=%s password=%s dbname=%s sslmode=disable", host, port, user, password, dbname)
-	services, err := model.NewServices(psqlInfo)
+	dbCfg := cfg.Database
+	services, err := model.NewServices(
+		model.WithGorm(dbCfg.Dialect(), dbCfg.ConnectionInfo()),
+		model.WithLogger(logger, sqlLevel),
+		model.WithJobs(),
+		model.WithCache(hot),
+		model.WithUser(),
+		model.WithGallery(),
+		model.WithImage(store),
+		model.WithTrash(),
+		model.WithUpload(chunks),
+		model.WithTag(),
+		model.WithCollection(),
+		model.WithMember(),
+		model.WithShareLink(),
+		model.WithImport(),
+		model.WithSearch(),
+	)
 	if err != nil {
 		panic(err)
 	}
 	defer services.Close()
 	services.AutoMigrate()
 
+	// admin commands, e.g. "picha import", run against the database and exit
+	if len(os.Args) > 1 {
+		code := runAdmin(os.Args[1:], cfg, services, os.Stdout)
+		services.Close()
+		os.Exit(code)
+	}
+
+	// outgoing mail; without an SMTP server messages are only logged
+	var mailer email.Mailer = email.Log{Log: logger}
+	if cfg.Mail.Host != "" {
+		mailer = email.SMTP{
+			Host:     cfg.Mail.Host,
+			Port:     cfg.Mail.Port,
+			Username: cfg.Mail.Username,
+			Password: cfg.Mail.Password,
+			From:     cfg.Mail.From,
+		}
+	}
+
+	// background jobs; requests only enqueue mail, resizing and imports
+	im := importer.Importer{
+		Images:  services.Image,
+		Imports: services.Import,
+	}
+	pool := jobs.NewPool(services.Jobs, cfg.Workers, logger)
+	pool.Handle(email.JobSend, email.Deliver(mailer))
+	pool.Handle(model.JobImageVariants, services.Image.MakeVariants)
+	pool.Handle(model.JobSweepBlobs, services.Image.SweepBlobs)
+	pool.Handle(model.JobExpireUploads, services.Upload.ExpireUploads)
+	pool.Handle(importer.JobImport, im.HandleArchive)
+	pool.Handle(model.JobPurgeTrash, services.Trash.PurgeTrash)
+	pool.Handle(jobs.JobPrune, jobs.Prune(services.Jobs, 7*24*time.Hour))
+	pool.Every(jobs.JobPrune, 24*time.Hour)
+	pool.Every(model.JobSweepBlobs, time.Hour)
+	pool.Every(model.JobExpireUploads, time.Hour)
+	pool.Every(model.JobPurgeTrash, time.Hour)
+	go pool.Run(context.Background())
+
+	// templates and static assets; re-parse templates on change everywhere but production
+	fsys := assetsFS(cfg.AssetsDir)
+	view.FS = fsys
+	view.Reload = !cfg.IsProd()
+	assets, err := view.NewAssets(fsys, "static", "/assets/")
+	if err != nil {
+		panic(err)
+	}
+	view.SetAssets(assets)
+	view.SetFlashKey(cfg.FlashKey)
+
 	// mux router
 	r := mux.NewRouter()
+	view.SetRouter(r)
 
 	// instatantiate controllers
 	staticC := controller.NewStatic()
 	userC := controller.NewUser(services.User)
-	galleryC := controller.NewGallery(services.Gallery, r)
+	galleryC := controller.NewGallery(services.Gallery, services.Image, services.Tag, services.Collection, services.Member, services.Share, r)
+	collectionC := controller.NewCollection(services.Collection, services.Image, services.Gallery, services.Member, r)
+	memberC := controller.NewMember(services.Gallery, services.Member, email.Queued{Queue: services.Jobs}, cfg.BaseURL, r)
+	uploadC := controller.NewUpload(services.Gallery, services.Member, services.Image, services.Upload, r)
+	importC := controller.NewImport(services.Gallery, services.Import, services.Jobs, r)
+	trashC := controller.NewTrash(services.Trash, r)
+	jobC := controller.NewJob(services.Jobs, r)
+	searchC := controller.NewSearch(services.Search, services.Image)
+	healthC := controller.NewHealth(
+		controller.HealthCheck{Name: "database", Check: services.Ping},
+		controller.HealthCheck{Name: "templates", Check: func(context.Context) error { return view.Check() }},
+		controller.HealthCheck{Name: "storage", Check: func(context.Context) error { return store.Check() }},
+	)
 
 	// middleware
+	logMw := middleware.Logger{
+		Log: logger,
+	}
+	userMw := middleware.User{
+		UserService: services.User,
+	}
+	localeMw := middleware.Locale{}
 	requireUserMw := middleware.RequireUser{
 		UserService: services.User,
 	}
+	recoverMw := middleware.Recover{
+		Log:  logger,
+		View: staticC.ServerError,
+	}
+	metricsMw := middleware.Metrics{}
+	protectMw, err := middleware.NewProtect(cfg.Metrics.AllowedIPs, cfg.Metrics.Username, cfg.Metrics.Password)
+	if err != nil {
+		panic(err)
+	}
+	r.Use(logMw.Apply, metricsMw.Apply, recoverMw.Apply, userMw.Apply, localeMw.Apply)
 
 	// // routing
-	r.Handle("/", staticC.Home).Methods("GET")
-	r.Handle("/contact", staticC.Contact).Methods("GET")
+	r.Handle("/", staticC.Home).Methods("GET").Name("home")
+	r.Handle("/contact", staticC.Contact).Methods("GET").Name("contact")
 
-	r.HandleFunc("/signup", userC.New).Methods("GET")
-	r.HandleFunc("/signup", userC.Create).Methods("POST")
+	r.HandleFunc("/signup", userC.New).Methods("GET").Name("signup")
+	r.HandleFunc("/signup", userC.Create).Methods("POST").Name("create_user")
 
-	r.Handle("/login", userC.LoginView).Methods("GET")
-	r.HandleFunc("/login", userC.Login).Methods("POST")
+	r.Handle("/login", userC.LoginView).Methods("GET").Name("login")
+	r.HandleFunc("/login", userC.Login).Methods("POST").Name("create_session")
+	r.HandleFunc("/account", requireUserMw.ApplyFn(userC.Account)).Methods("GET").Name("account")
+	r.HandleFunc("/locale", userC.SetLocale).Methods("POST").Name("set_locale")
 
 	newGallery := requireUserMw.Apply(galleryC.NewView)
 	createGallery := requireUserMw.ApplyFn(galleryC.Create)
-	r.Handle("/gallery/new", newGallery).Methods("GET")
-	r.HandleFunc("/gallery", createGallery).Methods("POST")
+	r.Handle("/gallery/new", newGallery).Methods("GET").Name("new_gallery")
+	r.HandleFunc("/gallery", requireUserMw.ApplyFn(galleryC.Index)).Methods("GET").Name(controller.IndexGalleries)
+	r.HandleFunc("/gallery", createGallery).Methods("POST").Name("create_gallery")
 	r.HandleFunc("/gallery/{id:[0-9]+}", galleryC.Show).Methods("GET").Name(controller.ShowGallery)
-	r.HandleFunc("/gallery/{id:[0-9]+}/edit", requireUserMw.ApplyFn(galleryC.Edit)).Methods("GET")
-	r.HandleFunc("/gallery/{id:[0-9]+}/update", requireUserMw.ApplyFn(galleryC.Update)).Methods("POST")
-	r.HandleFunc("/gallery/{id:[0-9]+}/delete", requireUserMw.ApplyFn(galleryC.Delete)).Methods("POST")
+	r.HandleFunc("/gallery/{id:[0-9]+}/edit", requireUserMw.ApplyFn(galleryC.Edit)).Methods("GET").Name(controller.EditGallery)
+	r.HandleFunc("/gallery/{id:[0-9]+}/update", requireUserMw.ApplyFn(galleryC.Update)).Methods("POST").Name("update_gallery")
+	r.HandleFunc("/gallery/{id:[0-9]+}/delete", requireUserMw.ApplyFn(galleryC.ConfirmDelete)).Methods("GET").Name("confirm_delete_gallery")
+	r.HandleFunc("/gallery/{id:[0-9]+}/delete", requireUserMw.ApplyFn(galleryC.Delete)).Methods("POST").Name("delete_gallery")
+	r.HandleFunc("/gallery/{id:[0-9]+}/images", requireUserMw.ApplyFn(galleryC.Upload)).Methods("POST").Name("upload_images")
+	r.HandleFunc("/gallery/{id:[0-9]+}/images/order", requireUserMw.ApplyFn(galleryC.Reorder)).Methods("POST").Name("order_images")
+	r.HandleFunc("/gallery/{id:[0-9]+}/image/{imageID:[0-9]+}", galleryC.Image).Methods("GET").Name(controller.ShowImage)
+	r.HandleFunc("/gallery/{id:[0-9]+}/image/{imageID:[0-9]+}/update", requireUserMw.ApplyFn(galleryC.UpdateImage)).Methods("POST").Name("update_image")
+	r.HandleFunc("/gallery/{id:[0-9]+}/image/{imageID:[0-9]+}/file", galleryC.ImageFile).Methods("GET").Name("image_file")
+	r.HandleFunc("/gallery/{id:[0-9]+}/image/{imageID:[0-9]+}/delete", requireUserMw.ApplyFn(galleryC.DeleteImage)).Methods("POST").Name("delete_image")
+	r.HandleFunc("/gallery/{id:[0-9]+}/download", galleryC.Download).Methods("GET").Name("download_gallery")
+	r.HandleFunc("/gallery/{id:[0-9]+}/shares", requireUserMw.ApplyFn(galleryC.CreateShare)).Methods("POST").Name("create_share")
+	r.HandleFunc("/gallery/{id:[0-9]+}/shares/{shareID:[0-9]+}/revoke", requireUserMw.ApplyFn(galleryC.RevokeShare)).Methods("POST").Name("revoke_share")
+
+	r.HandleFunc("/s/{token}", galleryC.Share).Methods("GET").Name(controller.ShowShare)
+	r.HandleFunc("/s/{token}", galleryC.Unlock).Methods("POST").Name("unlock_share")
+	r.HandleFunc("/s/{token}/download", galleryC.ShareDownload).Methods("GET").Name("download_share")
+	r.HandleFunc("/s/{token}/image/{imageID:[0-9]+}/file", galleryC.ShareImageFile).Methods("GET").Name("share_image_file")
+
+	r.HandleFunc("/gallery/{id:[0-9]+}/members", requireUserMw.ApplyFn(memberC.Index)).Methods("GET").Name(controller.GalleryMembers)
+	r.HandleFunc("/gallery/{id:[0-9]+}/members/invite", requireUserMw.ApplyFn(memberC.Invite)).Methods("POST").Name("invite_member")
+	r.HandleFunc("/gallery/{id:[0-9]+}/members/{userID:[0-9]+}/role", requireUserMw.ApplyFn(memberC.UpdateRole)).Methods("POST").Name("update_member_role")
+	r.HandleFunc("/gallery/{id:[0-9]+}/members/{userID:[0-9]+}/remove", requireUserMw.ApplyFn(memberC.Remove)).Methods("POST").Name("remove_member")
+	r.HandleFunc("/gallery/{id:[0-9]+}/invitations/{invitationID:[0-9]+}/revoke", requireUserMw.ApplyFn(memberC.Revoke)).Methods("POST").Name("revoke_invitation")
+	r.HandleFunc("/invitation/{token}", requireUserMw.ApplyFn(memberC.Invitation)).Methods("GET").Name(controller.ShowInvitation)
+	r.HandleFunc("/invitation/{token}/accept", requireUserMw.ApplyFn(memberC.Accept)).Methods("POST").Name("accept_invitation")
+
+	r.HandleFunc("/gallery/{id:[0-9]+}/uploads", requireUserMw.ApplyFn(uploadC.Options)).Methods("OPTIONS").Name("upload_options")
+	r.HandleFunc("/gallery/{id:[0-9]+}/uploads", requireUserMw.ApplyFn(uploadC.Create)).Methods("POST").Name("create_upload")
+	r.HandleFunc("/uploads/{token}", requireUserMw.ApplyFn(uploadC.Head)).Methods("HEAD").Name(controller.ShowUpload)
+	r.HandleFunc("/uploads/{token}", requireUserMw.ApplyFn(uploadC.Patch)).Methods("PATCH").Name("patch_upload")
+	r.HandleFunc("/uploads/{token}", requireUserMw.ApplyFn(uploadC.Delete)).Methods("DELETE").Name("delete_upload")
+
+	r.Handle("/import/new", requireUserMw.Apply(importC.NewView)).Methods("GET").Name("new_import")
+	r.HandleFunc("/import", requireUserMw.ApplyFn(importC.Create)).Methods("POST").Name("create_import")
+	r.HandleFunc("/import/{id:[0-9]+}", requireUserMw.ApplyFn(importC.Show)).Methods("GET").Name(controller.ShowImport)
+
+	r.HandleFunc("/trash", requireUserMw.ApplyFn(trashC.Index)).Methods("GET").Name(controller.IndexTrash)
+	r.HandleFunc("/trash/gallery/{id:[0-9]+}/restore", requireUserMw.ApplyFn(trashC.RestoreGallery)).Methods("POST").Name("restore_gallery")
+	r.HandleFunc("/trash/gallery/{id:[0-9]+}/undo", requireUserMw.ApplyFn(trashC.UndoGallery)).Methods("POST").Name(controller.UndoDeleteGallery)
+	r.HandleFunc("/trash/gallery/{id:[0-9]+}/purge", requireUserMw.ApplyFn(trashC.PurgeGallery)).Methods("POST").Name("purge_gallery")
+	r.HandleFunc("/trash/image/{id:[0-9]+}/restore", requireUserMw.ApplyFn(trashC.RestoreImage)).Methods("POST").Name("restore_image")
+	r.HandleFunc("/trash/image/{id:[0-9]+}/purge", requireUserMw.ApplyFn(trashC.PurgeImage)).Methods("POST").Name("purge_image")
 
-	r.HandleFunc("/cookietest", userC.CookieTest).Methods("GET")
+	r.Handle("/collection/new", requireUserMw.Apply(collectionC.NewView)).Methods("GET").Name("new_collection")
+	r.HandleFunc("/collection", requireUserMw.ApplyFn(collectionC.Index)).Methods("GET").Name(controller.IndexCollections)
+	r.HandleFunc("/collection", requireUserMw.ApplyFn(collectionC.Create)).Methods("POST").Name("create_collection")
+	r.HandleFunc("/collection/images", requireUserMw.ApplyFn(collectionC.AddImage)).Methods("POST").Name("add_collection_image")
+	r.HandleFunc("/collection/{id:[0-9]+}", collectionC.Show).Methods("GET").Name(controller.ShowCollection)
+	r.HandleFunc("/collection/{id:[0-9]+}/edit", requireUserMw.ApplyFn(collectionC.Edit)).Methods("GET").Name(controller.EditCollection)
+	r.HandleFunc("/collection/{id:[0-9]+}/update", requireUserMw.ApplyFn(collectionC.Update)).Methods("POST").Name("update_collection")
+	r.HandleFunc("/collection/{id:[0-9]+}/delete", requireUserMw.ApplyFn(collectionC.Delete)).Methods("POST").Name("delete_collection")
+	r.HandleFunc("/collection/{id:[0-9]+}/images/order", requireUserMw.ApplyFn(collectionC.Reorder)).Methods("POST").Name("order_collection_images")
+	r.HandleFunc("/collection/{id:[0-9]+}/image/{imageID:[0-9]+}/remove", requireUserMw.ApplyFn(collectionC.RemoveImage)).Methods("POST").Name("remove_collection_image")
 
-	r.NotFoundHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		w.WriteHeader(http.StatusNotFound)
-		staticC.Error.ServeHTTP(w, r)
-	})
+	r.HandleFunc("/search", searchC.Index).Methods("GET").Name("search")
+
+	r.PathPrefix("/assets/").Handler(assets).Methods("GET").Name("assets")
+
+	r.HandleFunc("/healthz", healthC.Live).Methods("GET").Name("healthz")
+	r.HandleFunc("/readyz", healthC.Ready).Methods("GET").Name("readyz")
+	r.Handle("/metrics", protectMw.Apply(metrics.Handler(services.SQL()))).Methods("GET").Name("metrics")
+	r.HandleFunc("/admin/jobs", protectMw.ApplyFn(jobC.Index)).Methods("GET").Name(controller.AdminJobs)
+	r.HandleFunc("/admin/jobs/{id:[0-9]+}/retry", protectMw.ApplyFn(jobC.Retry)).Methods("POST").Name("retry_job")
+
+	r.HandleFunc("/cookietest", userC.CookieTest).Methods("GET").Name("cookie_test")
+
+	// the router only runs its middleware on matched routes, so log 404s explicitly
+	r.NotFoundHandler = logMw.Apply(userMw.Apply(localeMw.Apply(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		staticC.Error.RenderStatus(w, r, http.StatusNotFound, nil)
+	}))))
+
+	// csrf protection for every form; the token is rendered with {{csrfField}}
+	csrfMw := csrf.Protect([]byte(cfg.CSRFKey),
+		csrf.Secure(cfg.IsProd()),
+		csrf.ErrorHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			view.Error(w, r, "Your form expired. Please go back, reload the page and try again.", http.StatusForbidden)
+		})),
+	)
+	handler := csrfMw(r)
+	if !cfg.IsProd() {
+		// without TLS in development the csrf origin checks must be told the scheme is http
+		secure := handler
+		handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			secure.ServeHTTP(w, csrf.PlaintextHTTPRequest(r))
+		})
+	}
 
 	// initiate app; serve app; accept connections
-	http.ListenAndServe(":9000", r)
+	logger.Info("starting server", "port", cfg.Port, "env", cfg.Env)
+	http.ListenAndServe(fmt.Sprintf(":%d", cfg.Port), handler)
 }
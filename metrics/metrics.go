@@ -0,0 +1,106 @@
+package metrics
+
+import (
+	"database/sql"
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "picha"
+
+var (
+	// Requests counts handled requests by gorilla route name, method and status code
+	Requests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "http_requests_total",
+		Help:      "HTTP requests handled, by route name, method and status.",
+	}, []string{"route", "method", "status"})
+
+	// RequestDuration observes handler latency by gorilla route name and method
+	RequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "Time spent handling HTTP requests, by route name and method.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"route", "method"})
+
+	// Logins counts login attempts; result is "success" or "failure"
+	Logins = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "logins_total",
+		Help:      "Login attempts, by result.",
+	}, []string{"result"})
+
+	// Signups counts users created through the sign up form
+	Signups = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "signups_total",
+		Help:      "Users created through sign up.",
+	})
+
+	// GalleriesCreated counts new galleries
+	GalleriesCreated = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "galleries_created_total",
+		Help:      "Galleries created.",
+	})
+
+	// ImageUploads counts images accepted by the upload pipeline
+	ImageUploads = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "image_uploads_total",
+		Help:      "Images uploaded.",
+	})
+
+	// ImageUploadBytes counts the bytes of the images accepted by the upload pipeline
+	ImageUploadBytes = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "image_upload_bytes_total",
+		Help:      "Bytes of uploaded images.",
+	})
+
+	// Jobs counts background job attempts by kind; result is "done", "retry" or "dead"
+	Jobs = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "jobs_total",
+		Help:      "Background job attempts, by kind and result.",
+	}, []string{"kind", "result"})
+)
+
+// Login records the outcome of a login attempt
+func Login(ok bool) {
+	if ok {
+		Logins.WithLabelValues("success").Inc()
+		return
+	}
+	Logins.WithLabelValues("failure").Inc()
+}
+
+// Upload records one accepted image of n bytes
+func Upload(n int64) {
+	ImageUploads.Inc()
+	ImageUploadBytes.Add(float64(n))
+}
+
+// Handler registers the app collectors along with the DB pool stats of db and
+// returns the handler for /metrics
+func Handler(db *sql.DB) http.Handler {
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		collectors.NewDBStatsCollector(db, namespace),
+		Requests,
+		RequestDuration,
+		Logins,
+		Signups,
+		GalleriesCreated,
+		ImageUploads,
+		ImageUploadBytes,
+		Jobs,
+	)
+	return promhttp.HandlerFor(reg, promhttp.HandlerOpts{})
+}
@@ -0,0 +1,51 @@
+package middleware
+
+import (
+	"net/http"
+
+	"github.com/jhampac/picha/context"
+	"github.com/jhampac/picha/i18n"
+	"github.com/jhampac/picha/model"
+)
+
+const localeCookie = "locale"
+
+// Locale decides which language to render each request in. In order of
+// preference: a ?lang= switch, the signed in user's setting, the locale cookie
+// of anonymous visitors and finally the Accept-Language header. A ?lang=
+// switch only sets the cookie; signed in users save their setting by posting
+// the footer's language form.
+type Locale struct{}
+
+// Apply is meant to be registered with mux.Router.Use after User
+func (mw *Locale) Apply(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user := context.User(r.Context())
+
+		if lang := r.URL.Query().Get("lang"); i18n.Supported(lang) {
+			http.SetCookie(w, &http.Cookie{
+				Name:     localeCookie,
+				Value:    lang,
+				Path:     "/",
+				MaxAge:   365 * 24 * 60 * 60,
+				HttpOnly: true,
+			})
+		}
+
+		r = r.WithContext(context.WithLocale(r.Context(), mw.detect(r, user)))
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (mw *Locale) detect(r *http.Request, user *model.User) string {
+	if lang := r.URL.Query().Get("lang"); i18n.Supported(lang) {
+		return lang
+	}
+	if user != nil && i18n.Supported(user.Locale) {
+		return user.Locale
+	}
+	if cookie, err := r.Cookie(localeCookie); err == nil && i18n.Supported(cookie.Value) {
+		return cookie.Value
+	}
+	return i18n.Match(r.Header.Get("Accept-Language"))
+}
@@ -0,0 +1,93 @@
+package middleware
+
+import (
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/jhampac/picha/context"
+	"github.com/jhampac/picha/rand"
+)
+
+// Logger assigns every request an ID and writes one structured line per request
+type Logger struct {
+	Log *slog.Logger
+}
+
+// Apply is meant to be registered with mux.Router.Use so the matched route is known
+func (mw *Logger) Apply(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+
+		id, err := rand.RequestID()
+		if err != nil {
+			mw.Log.Error("generating request id", "error", err)
+		}
+		w.Header().Set("X-Request-ID", id)
+		r = r.WithContext(context.WithRequestID(r.Context(), id))
+
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+
+		// deferred so requests aborted with http.ErrAbortHandler, which
+		// unwinds past here, are logged too
+		completed := false
+		defer func() {
+			attrs := []interface{}{
+				"request_id", id,
+				"method", r.Method,
+				"route", routeName(r),
+				"status", rec.status,
+				"latency_ms", float64(time.Since(start).Microseconds()) / 1000,
+				"user_id", context.RequestUserID(r.Context()),
+			}
+			if !completed {
+				attrs = append(attrs, "aborted", true)
+			}
+			mw.Log.Info("request", attrs...)
+		}()
+		next.ServeHTTP(rec, r)
+		completed = true
+	})
+}
+
+// routeName prefers the gorilla route name and falls back to its path template
+func routeName(r *http.Request) string {
+	route := mux.CurrentRoute(r)
+	if route == nil {
+		return "not_found"
+	}
+	if name := route.GetName(); name != "" {
+		return name
+	}
+	if tpl, err := route.GetPathTemplate(); err == nil {
+		return tpl
+	}
+	return "unknown"
+}
+
+// statusRecorder remembers the status code written by the wrapped handler
+type statusRecorder struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+}
+
+func (rec *statusRecorder) WriteHeader(code int) {
+	if !rec.wroteHeader {
+		rec.status = code
+		rec.wroteHeader = true
+	}
+	rec.ResponseWriter.WriteHeader(code)
+}
+
+func (rec *statusRecorder) Write(b []byte) (int, error) {
+	rec.wroteHeader = true
+	return rec.ResponseWriter.Write(b)
+}
+
+// Unwrap lets http.NewResponseController reach the write deadline of the
+// real writer, which gallery downloads move forward as they stream
+func (rec *statusRecorder) Unwrap() http.ResponseWriter {
+	return rec.ResponseWriter
+}
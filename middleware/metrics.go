@@ -0,0 +1,108 @@
+package middleware
+
+import (
+	"crypto/subtle"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/jhampac/picha/metrics"
+)
+
+// Metrics records request counts and latencies per gorilla route name
+type Metrics struct{}
+
+// Apply is meant to be registered with mux.Router.Use so the matched route is known
+func (mw *Metrics) Apply(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rec, r)
+
+		route := routeName(r)
+		metrics.Requests.WithLabelValues(route, r.Method, strconv.Itoa(rec.status)).Inc()
+		metrics.RequestDuration.WithLabelValues(route, r.Method).Observe(time.Since(start).Seconds())
+	})
+}
+
+// Protect only lets through requests from an allowed network or with matching basic auth credentials
+type Protect struct {
+	AllowedNets []*net.IPNet
+	Username    string
+	Password    string
+}
+
+// NewProtect parses the allowlist; entries may be CIDRs or single IPs
+func NewProtect(allowed []string, username, password string) (*Protect, error) {
+	mw := &Protect{
+		Username: username,
+		Password: password,
+	}
+	for _, a := range allowed {
+		if ip := net.ParseIP(a); ip != nil {
+			bits := 8 * len(ip.To4())
+			if bits == 0 {
+				bits = 8 * net.IPv6len
+			}
+			a = ip.String() + "/" + strconv.Itoa(bits)
+		}
+		_, ipNet, err := net.ParseCIDR(a)
+		if err != nil {
+			return nil, err
+		}
+		mw.AllowedNets = append(mw.AllowedNets, ipNet)
+	}
+	return mw, nil
+}
+
+// Apply guards next; denied requests get a basic auth challenge when credentials are configured
+func (mw *Protect) Apply(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if mw.allowedIP(r) || mw.validAuth(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if mw.Username != "" {
+			w.Header().Set("WWW-Authenticate", `Basic realm="picha"`)
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		http.Error(w, "Forbidden", http.StatusForbidden)
+	})
+}
+
+// ApplyFn guards a handler func, e.g. an admin page
+func (mw *Protect) ApplyFn(next http.HandlerFunc) http.HandlerFunc {
+	return mw.Apply(next).ServeHTTP
+}
+
+func (mw *Protect) allowedIP(r *http.Request) bool {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return false
+	}
+	for _, n := range mw.AllowedNets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func (mw *Protect) validAuth(r *http.Request) bool {
+	if mw.Username == "" {
+		return false
+	}
+	user, pass, ok := r.BasicAuth()
+	if !ok {
+		return false
+	}
+	userOK := subtle.ConstantTimeCompare([]byte(user), []byte(mw.Username)) == 1
+	passOK := subtle.ConstantTimeCompare([]byte(pass), []byte(mw.Password)) == 1
+	return userOK && passOK
+}
@@ -0,0 +1,43 @@
+package middleware
+
+import (
+	"log/slog"
+	"net/http"
+	"runtime/debug"
+
+	"github.com/jhampac/picha/context"
+	"github.com/jhampac/picha/view"
+)
+
+// Recover turns panics in handlers into a logged stack trace and a rendered 500 page
+type Recover struct {
+	Log  *slog.Logger
+	View *view.View
+}
+
+// Apply is meant to be registered with mux.Router.Use after Logger so the request ID is set
+func (mw *Recover) Apply(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			rvr := recover()
+			if rvr == nil {
+				return
+			}
+			// the server uses this to abort a response on purpose; let it through
+			if rvr == http.ErrAbortHandler {
+				panic(rvr)
+			}
+
+			mw.Log.Error("panic",
+				"request_id", context.RequestID(r.Context()),
+				"panic", rvr,
+				"stack", string(debug.Stack()),
+			)
+			mw.View.RenderStatus(w, r, http.StatusInternalServerError, view.Data{
+				Yield: view.NewErrorData(r, "", http.StatusInternalServerError),
+			})
+		}()
+
+		next.ServeHTTP(w, r)
+	})
+}
@@ -7,6 +7,36 @@ import (
 	"github.com/jhampac/picha/model"
 )
 
+// User attaches the signed in user, if any, to the context of every request
+type User struct {
+	model.UserService
+}
+
+// Apply is meant to be registered with mux.Router.Use
+func (mw *User) Apply(next http.Handler) http.Handler {
+	return mw.ApplyFn(next.ServeHTTP)
+}
+
+// ApplyFn looks the user up from the remember token cookie; requests without one pass through untouched
+func (mw *User) ApplyFn(next http.HandlerFunc) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cookie, err := r.Cookie("remember_token")
+		if err != nil {
+			next(w, r)
+			return
+		}
+
+		user, err := mw.UserService.ByRemember(cookie.Value)
+		if err != nil {
+			next(w, r)
+			return
+		}
+
+		r = r.WithContext(context.WithUser(r.Context(), user))
+		next(w, r)
+	})
+}
+
 type RequireUser struct {
 	model.UserService
 }
@@ -14,6 +44,12 @@ type RequireUser struct {
 // ApplyFn chains to the next call
 func (mw *RequireUser) ApplyFn(next http.HandlerFunc) http.HandlerFunc {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// already looked up by the User middleware
+		if context.User(r.Context()) != nil {
+			next(w, r)
+			return
+		}
+
 		cookie, err := r.Cookie("remember_token")
 		if err != nil {
 			http.Redirect(w, r, "/login", http.StatusFound) // 302 Found as in redirected to login page
@@ -0,0 +1,145 @@
+package model
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/jhampac/picha/imaging"
+	"github.com/jinzhu/gorm"
+)
+
+// JobSweepBlobs is the kind of the scheduled job that removes the files of
+// blobs no image refers to anymore
+const JobSweepBlobs = "blobs.sweep"
+
+// Blob is a stored original and its variants, shared by every image with the
+// same content so a photo added to several galleries is kept once. Refs
+// counts the images using it; unreferenced blobs are swept.
+type Blob struct {
+	Key   string `gorm:"primary_key"`
+	Hash  string `gorm:"index"`
+	Size  int64
+	Width int
+	Refs  int `gorm:"not_null;default:0"`
+
+	// Bytes is what the original and its variants take up in the store
+	Bytes int64 `gorm:"not_null;default:0"`
+
+	// Variants reports whether the resized copies are stored
+	Variants bool `gorm:"not_null;default:false"`
+
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+// blobKey is the storage key of a new blob. The random part keeps a blob
+// being swept and a new one with the same content apart.
+func blobKey(hash, token, format string) string {
+	return fmt.Sprintf("blobs/%s/%s-%s.%s", hash[:2], hash, token, format)
+}
+
+// files lists the storage keys of the blob's original and variants
+func (b *Blob) files() []string {
+	image := Image{Key: b.Key}
+	keys := []string{b.Key}
+	for _, w := range imaging.VariantWidths(b.Width) {
+		keys = append(keys, image.VariantKey(w))
+	}
+	return keys
+}
+
+// blobGorm keeps the blob rows; the image service owns their files
+type blobGorm struct {
+	db *gorm.DB
+}
+
+// acquire adds a reference to a blob with the content hash, or returns nil
+// when there is none. A blob swept meanwhile is not revived, since the
+// sweep only deletes rows that still have no references.
+func (bg *blobGorm) acquire(hash string) (*Blob, error) {
+	var blob Blob
+	err := first(bg.db.Where("hash = ?", hash).Order("created_at"), &blob)
+	if err == ErrNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	res := bg.db.Model(&Blob{}).Where("key = ?", blob.Key).UpdateColumn("refs", gorm.Expr("refs + 1"))
+	if res.Error != nil {
+		return nil, res.Error
+	}
+	if res.RowsAffected == 0 {
+		return nil, nil
+	}
+	blob.Refs++
+	return &blob, nil
+}
+
+func (bg *blobGorm) create(blob *Blob) error {
+	return bg.db.Create(blob).Error
+}
+
+func (bg *blobGorm) byKey(key string) (*Blob, error) {
+	var blob Blob
+	if err := first(bg.db.Where("key = ?", key), &blob); err != nil {
+		return nil, err
+	}
+	return &blob, nil
+}
+
+// variantsStored records that the blob's resized copies exist and charges
+// their bytes to the owners of the images using it, once
+func (bg *blobGorm) variantsStored(key string, bytes int64) error {
+	return bg.db.Transaction(func(tx *gorm.DB) error {
+		res := tx.Model(&Blob{}).Where("key = ? AND variants = ?", key, false).
+			UpdateColumns(map[string]interface{}{"variants": true, "bytes": gorm.Expr("bytes + ?", bytes)})
+		if res.Error != nil || res.RowsAffected == 0 {
+			return res.Error
+		}
+		return chargeVariants(tx, key, bytes)
+	})
+}
+
+// all lists every blob, for reconciling usage
+func (bg *blobGorm) all() ([]Blob, error) {
+	var blobs []Blob
+	if err := bg.db.Order("key").Find(&blobs).Error; err != nil {
+		return nil, err
+	}
+	return blobs, nil
+}
+
+// setBytes corrects the stored size of a blob
+func (bg *blobGorm) setBytes(key string, bytes int64) error {
+	return bg.db.Model(&Blob{}).Where("key = ?", key).UpdateColumn("bytes", bytes).Error
+}
+
+// unreferenced lists blobs whose files can be removed
+func (bg *blobGorm) unreferenced() ([]Blob, error) {
+	var blobs []Blob
+	if err := bg.db.Where("refs <= 0").Find(&blobs).Error; err != nil {
+		return nil, err
+	}
+	return blobs, nil
+}
+
+// remove deletes the blob's row unless it was referenced again, and reports
+// whether its files may be removed
+func (bg *blobGorm) remove(key string) (bool, error) {
+	res := bg.db.Where("key = ? AND refs <= 0", key).Delete(&Blob{})
+	return res.RowsAffected == 1, res.Error
+}
+
+// releaseBlob drops the reference of one image to its blob
+func releaseBlob(tx *gorm.DB, key string) error {
+	return tx.Model(&Blob{}).Where("key = ?", key).UpdateColumn("refs", gorm.Expr("refs - 1")).Error
+}
+
+// releaseGalleryBlobs drops the references of all the gallery's images, in
+// the trash or not, to their blobs; it must run before the images are purged
+func releaseGalleryBlobs(tx *gorm.DB, galleryID uint) error {
+	return tx.Exec(`UPDATE blobs SET refs = refs - (SELECT COUNT(*) FROM images
+		WHERE images.key = blobs.key AND images.gallery_id = ?)
+		WHERE key IN (SELECT key FROM images WHERE gallery_id = ?)`, galleryID, galleryID).Error
+}
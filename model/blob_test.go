@@ -0,0 +1,169 @@
+package model
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"testing"
+)
+
+func TestUploadSharesBlobsByContent(t *testing.T) {
+	env := newTestEnv(t)
+	alice := env.user(t, "alice@example.com")
+	beach := env.gallery(t, alice, "Beach")
+	city := env.gallery(t, alice, "City")
+	photo := jpegBytes(t, 400, 300, 1)
+
+	first := env.upload(t, beach, photo)
+	second := env.upload(t, city, photo)
+	other := env.upload(t, city, jpegBytes(t, 400, 300, 2))
+
+	if first.Key != second.Key {
+		t.Fatalf("same content stored as %s and %s, want one blob", first.Key, second.Key)
+	}
+	if other.Key == first.Key {
+		t.Fatal("different content shares a blob")
+	}
+	if got := env.blob(t, first.Key).Refs; got != 2 {
+		t.Fatalf("shared blob has %d refs, want 2", got)
+	}
+	if got := env.blobCount(t); got != 2 {
+		t.Fatalf("%d blobs, want 2", got)
+	}
+}
+
+func TestVariantsAreMadeOncePerBlob(t *testing.T) {
+	env := newTestEnv(t)
+	alice := env.user(t, "alice@example.com")
+	beach := env.gallery(t, alice, "Beach")
+	photo := jpegBytes(t, 400, 300, 1)
+
+	first := env.upload(t, beach, photo)
+	if !first.Pending {
+		t.Fatal("new image is not pending")
+	}
+	env.makeVariants(t)
+	blob := env.blob(t, first.Key)
+	if !blob.Variants || !env.stored(first.VariantKey(320)) {
+		t.Fatal("variants were not stored")
+	}
+
+	second := env.upload(t, env.gallery(t, alice, "City"), photo)
+	if second.Pending {
+		t.Fatal("image of a blob with variants is pending")
+	}
+	if job, _ := env.queue.Claim(context.Background()); job != nil {
+		t.Fatal("queued variants for a blob that has them")
+	}
+}
+
+func TestReleaseSweepsTheLastReference(t *testing.T) {
+	env := newTestEnv(t)
+	alice := env.user(t, "alice@example.com")
+	beach := env.gallery(t, alice, "Beach")
+	photo := jpegBytes(t, 400, 300, 1)
+	first := env.upload(t, beach, photo)
+	second := env.upload(t, beach, photo)
+	env.makeVariants(t)
+	is := env.images()
+
+	is.release(first)
+	if got := env.blob(t, first.Key).Refs; got != 1 {
+		t.Fatalf("%d refs after releasing one of two, want 1", got)
+	}
+	if !env.stored(first.Key) {
+		t.Fatal("removed a file that is still referenced")
+	}
+
+	is.release(second)
+	if got := env.blobCount(t); got != 0 {
+		t.Fatalf("%d blobs after releasing every reference, want 0", got)
+	}
+	if env.stored(first.Key) || env.stored(first.VariantKey(320)) {
+		t.Fatal("kept the files of an unreferenced blob")
+	}
+}
+
+func TestSweepKeepsBlobsReferencedAgain(t *testing.T) {
+	env := newTestEnv(t)
+	alice := env.user(t, "alice@example.com")
+	beach := env.gallery(t, alice, "Beach")
+	photo := jpegBytes(t, 400, 300, 1)
+	image := env.upload(t, beach, photo)
+	is := env.images()
+
+	if err := releaseBlob(env.db, image.Key); err != nil {
+		t.Fatal(err)
+	}
+	unreferenced, err := is.blobs.unreferenced()
+	if err != nil || len(unreferenced) != 1 {
+		t.Fatalf("unreferenced = %d blobs, %v, want 1", len(unreferenced), err)
+	}
+
+	// an upload of the same content takes the blob before the sweep gets to it
+	blob, err := is.blobs.acquire(HashBytes(photo))
+	if err != nil || blob == nil {
+		t.Fatalf("acquire = %v, %v, want the blob", blob, err)
+	}
+	if err := is.sweep(&unreferenced[0]); err != nil {
+		t.Fatal(err)
+	}
+	if got := env.blob(t, image.Key).Refs; got != 1 || !env.stored(image.Key) {
+		t.Fatalf("sweep removed a blob referenced again (refs %d)", got)
+	}
+}
+
+func TestAcquireDoesNotReviveSweptBlobs(t *testing.T) {
+	env := newTestEnv(t)
+	alice := env.user(t, "alice@example.com")
+	beach := env.gallery(t, alice, "Beach")
+	photo := jpegBytes(t, 400, 300, 1)
+	image := env.upload(t, beach, photo)
+	is := env.images()
+
+	if err := releaseBlob(env.db, image.Key); err != nil {
+		t.Fatal(err)
+	}
+	if err := is.Sweep(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if blob, err := is.blobs.acquire(HashBytes(photo)); err != nil || blob != nil {
+		t.Fatalf("acquire after the sweep = %v, %v, want no blob", blob, err)
+	}
+
+	again := env.upload(t, beach, photo)
+	if again.Key == image.Key || !env.stored(again.Key) {
+		t.Fatal("upload after the sweep did not store a new blob")
+	}
+}
+
+func TestUploadStreamsReadersThatCannotSeek(t *testing.T) {
+	env := newTestEnv(t)
+	beach := env.gallery(t, env.user(t, "alice@example.com"), "Beach")
+	photo := jpegBytes(t, 400, 300, 1)
+
+	// a reader that is not an io.ReadSeeker, like a request body
+	image, err := env.Image.Upload(beach.ID, "photo.jpg", io.MultiReader(bytes.NewReader(photo)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if image.Hash != HashBytes(photo) || image.Size != int64(len(photo)) || image.Width != 400 {
+		t.Fatalf("image %+v does not match the file", image)
+	}
+	if again := env.upload(t, beach, photo); again.Key != image.Key {
+		t.Fatal("the same file read with a seeker is stored apart")
+	}
+
+	file, err := spool(io.MultiReader(bytes.NewReader(photo)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	name := file.tmp.Name()
+	if err := file.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(name); !os.IsNotExist(err) {
+		t.Fatalf("temporary copy left behind: %v", err)
+	}
+}
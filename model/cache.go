@@ -0,0 +1,147 @@
+package model
+
+import (
+	"encoding/json"
+	"log/slog"
+	"strconv"
+	"sync/atomic"
+
+	"github.com/jhampac/picha/cache"
+)
+
+// userCache is a UserDB layer between the validator and gorm that keeps the
+// users found by remember token, which every signed in request looks up.
+// Users are kept by ID and found through an index entry per token, so
+// dropping the one entry by ID signs a user out whatever was evicted.
+// StorageUsed is changed by the image queries without going through UserDB,
+// so it may be stale in cached users.
+type userCache struct {
+	UserDB
+	cache cache.Cache
+
+	// forgets counts the users dropped, so a lookup that raced an update
+	// does not keep the row it read before it
+	forgets atomic.Uint64
+}
+
+// galleryCache is a GalleryDB layer between the validator and gorm that
+// keeps the galleries found by ID
+type galleryCache struct {
+	GalleryDB
+	cache cache.Cache
+}
+
+func userKey(id uint) string {
+	return "user:" + strconv.Itoa(int(id))
+}
+
+// rememberKey holds the ID of the user with the remember hash
+func rememberKey(rememberHash string) string {
+	return "user:remember:" + rememberHash
+}
+
+func galleryKey(id uint) string {
+	return "gallery:" + strconv.Itoa(int(id))
+}
+
+func (uc *userCache) ByRemember(rememberHash string) (*User, error) {
+	var id uint
+	var user User
+	// the index outlives a token rotated since, so the user must still have it
+	if getCached(uc.cache, rememberKey(rememberHash), &id) &&
+		getCached(uc.cache, userKey(id), &user) && user.RememberHash == rememberHash {
+		return &user, nil
+	}
+
+	forgets := uc.forgets.Load()
+	found, err := uc.UserDB.ByRemember(rememberHash)
+	if err != nil {
+		return nil, err
+	}
+	setCached(uc.cache, userKey(found.ID), found)
+	setCached(uc.cache, rememberKey(rememberHash), found.ID)
+	// a user dropped since the read may have been cached before the drop
+	if uc.forgets.Load() != forgets {
+		uc.cache.Delete(userKey(found.ID))
+	}
+	return found, nil
+}
+
+func (uc *userCache) Update(user *User) error {
+	err := uc.UserDB.Update(user)
+	uc.forget(user.ID)
+	return err
+}
+
+func (uc *userCache) UpdateLocale(user *User, locale string) error {
+	err := uc.UserDB.UpdateLocale(user, locale)
+	uc.forget(user.ID)
+	return err
+}
+
+func (uc *userCache) Delete(id uint) error {
+	err := uc.UserDB.Delete(id)
+	uc.forget(id)
+	return err
+}
+
+func (uc *userCache) forget(id uint) {
+	uc.forgets.Add(1)
+	uc.cache.Delete(userKey(id))
+}
+
+func (gc *galleryCache) ByID(id uint) (*Gallery, error) {
+	var gallery Gallery
+	if getCached(gc.cache, galleryKey(id), &gallery) {
+		return &gallery, nil
+	}
+	found, err := gc.GalleryDB.ByID(id)
+	if err != nil {
+		return nil, err
+	}
+	setCached(gc.cache, galleryKey(id), found)
+	return found, nil
+}
+
+func (gc *galleryCache) Update(gallery *Gallery) error {
+	err := gc.GalleryDB.Update(gallery)
+	gc.cache.Delete(galleryKey(gallery.ID))
+	return err
+}
+
+func (gc *galleryCache) Delete(id uint) error {
+	err := gc.GalleryDB.Delete(id)
+	gc.cache.Delete(galleryKey(id))
+	return err
+}
+
+// forgetGallery drops the gallery from c after it was changed outside GalleryDB
+func forgetGallery(c cache.Cache, id uint) {
+	if c != nil {
+		c.Delete(galleryKey(id))
+	}
+}
+
+// getCached decodes the value under key into dst. Every hit decodes a fresh
+// copy, so callers may change what they get.
+func getCached(c cache.Cache, key string, dst interface{}) bool {
+	b, ok := c.Get(key)
+	if !ok {
+		return false
+	}
+	if err := json.Unmarshal(b, dst); err != nil {
+		slog.Warn("decoding cached value", "key", key, "error", err)
+		c.Delete(key)
+		return false
+	}
+	return true
+}
+
+func setCached(c cache.Cache, key string, value interface{}) {
+	b, err := json.Marshal(value)
+	if err != nil {
+		slog.Warn("encoding cached value", "key", key, "error", err)
+		return
+	}
+	c.Set(key, b)
+}
@@ -0,0 +1,217 @@
+package model
+
+import (
+	"testing"
+	"time"
+
+	"github.com/jhampac/picha/cache"
+	"github.com/jhampac/picha/rand"
+)
+
+func TestUserCacheForgetsUpdatedAndDeletedUsers(t *testing.T) {
+	env := newTestEnv(t)
+	alice := env.user(t, "alice@example.com")
+	token := alice.Remember
+
+	if _, err := env.User.ByRemember(token); err != nil {
+		t.Fatal(err)
+	}
+	if err := env.db.Exec("UPDATE users SET name = 'Changed elsewhere'").Error; err != nil {
+		t.Fatal(err)
+	}
+	cached, err := env.User.ByRemember(token)
+	if err != nil || cached.Name != "Test" {
+		t.Fatalf("ByRemember = %v, %v, want the cached user", cached, err)
+	}
+
+	// signing in again rotates the token; the old one must stop working at once
+	cached.Remember, err = rand.RememberToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cached.Name = "Alice"
+	if err := env.User.Update(cached); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := env.User.ByRemember(token); err != ErrNotFound {
+		t.Fatalf("ByRemember with the old token = %v, want ErrNotFound", err)
+	}
+	fresh, err := env.User.ByRemember(cached.Remember)
+	if err != nil || fresh.Name != "Alice" {
+		t.Fatalf("ByRemember with the new token = %v, %v, want the updated user", fresh, err)
+	}
+
+	if err := env.User.Delete(alice.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := env.User.ByRemember(cached.Remember); err != ErrNotFound {
+		t.Fatalf("ByRemember of a deleted user = %v, want ErrNotFound", err)
+	}
+}
+
+// signOut rotates the user's remember token, as logging out or changing the password does
+func signOut(t *testing.T, us UserService, user *User) {
+	t.Helper()
+	token, err := rand.RememberToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+	user.Remember = token
+	if err := us.Update(user); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestUserCacheForgetsSignedOutUsersUnderPressure(t *testing.T) {
+	env := newTestEnv(t)
+	alice := env.user(t, "alice@example.com")
+	bob := env.user(t, "bob@example.com")
+	token := alice.Remember
+
+	// small enough that bob's sign in evicts some of what alice left
+	us := NewUserService(env.db, cache.NewLRU(3, time.Minute))
+	for _, tok := range []string{token, token, bob.Remember} {
+		if _, err := us.ByRemember(tok); err != nil {
+			t.Fatal(err)
+		}
+	}
+	cached, err := us.ByRemember(token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signOut(t, us, cached)
+	if _, err := us.ByRemember(token); err != ErrNotFound {
+		t.Fatalf("ByRemember after signing out = %v, want ErrNotFound", err)
+	}
+}
+
+// updateDuringRead is a UserDB that lets another request update the user
+// between reading it and returning it
+type updateDuringRead struct {
+	UserDB
+	update func(user User)
+}
+
+func (u *updateDuringRead) ByRemember(rememberHash string) (*User, error) {
+	user, err := u.UserDB.ByRemember(rememberHash)
+	if err == nil && u.update != nil {
+		update := u.update
+		u.update = nil
+		update(*user)
+	}
+	return user, err
+}
+
+func TestUserCacheKeepsNoRowReadBeforeAnUpdate(t *testing.T) {
+	env := newTestEnv(t)
+	alice := env.user(t, "alice@example.com")
+
+	db := &updateDuringRead{UserDB: &userGorm{env.db}}
+	uc := &userCache{UserDB: db, cache: cache.NewLRU(100, time.Minute)}
+	db.update = func(user User) {
+		user.Name = "Alice"
+		if err := uc.Update(&user); err != nil {
+			t.Error(err)
+		}
+	}
+	if _, err := uc.ByRemember(alice.RememberHash); err != nil {
+		t.Fatal(err)
+	}
+	fresh, err := uc.ByRemember(alice.RememberHash)
+	if err != nil || fresh.Name != "Alice" {
+		t.Fatalf("ByRemember after a racing update = %v, %v, want the updated user", fresh, err)
+	}
+}
+
+func TestUserCacheReturnsCopies(t *testing.T) {
+	env := newTestEnv(t)
+	alice := env.user(t, "alice@example.com")
+	first, err := env.User.ByRemember(alice.Remember)
+	if err != nil {
+		t.Fatal(err)
+	}
+	first.Name = "Changed without saving"
+	second, err := env.User.ByRemember(alice.Remember)
+	if err != nil || second.Name != "Test" {
+		t.Fatalf("ByRemember = %v, %v, want the stored user", second, err)
+	}
+}
+
+func TestUpdateLocaleOnlyWritesTheLocale(t *testing.T) {
+	env := newTestEnv(t)
+	alice := env.user(t, "alice@example.com")
+	cached, err := env.User.ByRemember(alice.Remember)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a stale copy, such as the one a request loaded before the plan changed
+	if err := env.db.Exec("UPDATE users SET plan = ?", PlanPro).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := env.User.UpdateLocale(cached, "fr"); err != nil {
+		t.Fatal(err)
+	}
+	fresh, err := env.User.ByRemember(alice.Remember)
+	if err != nil || fresh.Locale != "fr" || fresh.Plan != PlanPro {
+		t.Fatalf("ByRemember after UpdateLocale = %+v, %v, want locale fr on the pro plan", fresh, err)
+	}
+}
+
+func TestGalleryCacheForgetsChangedGalleries(t *testing.T) {
+	env := newTestEnv(t)
+	alice := env.user(t, "alice@example.com")
+	beach := env.gallery(t, alice, "Beach")
+
+	if _, err := env.Gallery.ByID(beach.ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := env.db.Exec("UPDATE galleries SET title = 'Changed elsewhere'").Error; err != nil {
+		t.Fatal(err)
+	}
+	cached, err := env.Gallery.ByID(beach.ID)
+	if err != nil || cached.Title != "Beach" {
+		t.Fatalf("ByID = %v, %v, want the cached gallery", cached, err)
+	}
+
+	cached.Title = "Seaside"
+	if err := env.Gallery.Update(cached); err != nil {
+		t.Fatal(err)
+	}
+	if fresh, err := env.Gallery.ByID(beach.ID); err != nil || fresh.Title != "Seaside" {
+		t.Fatalf("ByID after Update = %v, %v, want the new title", fresh, err)
+	}
+
+	if err := env.Gallery.Delete(beach.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := env.Gallery.ByID(beach.ID); err != ErrNotFound {
+		t.Fatalf("ByID after Delete = %v, want ErrNotFound", err)
+	}
+	if err := env.Trash.RestoreGallery(beach.ID); err != nil {
+		t.Fatal(err)
+	}
+	if restored, err := env.Gallery.ByID(beach.ID); err != nil || restored.Title != "Seaside" {
+		t.Fatalf("ByID after restoring = %v, %v, want the gallery back", restored, err)
+	}
+}
+
+func TestPurgingForgetsCachedGalleries(t *testing.T) {
+	env := newTestEnv(t)
+	alice := env.user(t, "alice@example.com")
+	beach := env.gallery(t, alice, "Beach")
+	if _, err := env.Gallery.ByID(beach.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	// deleted by another process, whose cache this one does not share
+	if err := env.db.Delete(&Gallery{}, beach.ID).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := env.Trash.PurgeGallery(beach.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := env.Gallery.ByID(beach.ID); err != ErrNotFound {
+		t.Fatalf("ByID of a purged gallery = %v, want ErrNotFound", err)
+	}
+}
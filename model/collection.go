@@ -0,0 +1,278 @@
+package model
+
+import (
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+const (
+	// ErrCollectionOrderInvalid is returned when a new order does not list every image of the collection exactly once
+	ErrCollectionOrderInvalid modelError = "model: the new order must list every image of the collection exactly once"
+)
+
+// Collection is a curated, ordered set of images picked from any of the owner's galleries
+type Collection struct {
+	gorm.Model
+	UserID uint   `gorm:"not_null;index"`
+	Title  string `gorm:"not_null"`
+
+	// Description is Markdown; it is sanitized when rendered
+	Description string `gorm:"type:text"`
+
+	// Visibility is VisibilityPublic or VisibilityPrivate
+	Visibility string `gorm:"not_null;default:'public'"`
+}
+
+// VisibleTo reports whether user, which may be nil, can view the collection
+func (c *Collection) VisibleTo(user *User) bool {
+	if c.Visibility != VisibilityPrivate {
+		return true
+	}
+	return user != nil && user.ID == c.UserID
+}
+
+// CollectionImage links an image into a collection at a position; the image
+// itself, and its files, stay in its gallery
+type CollectionImage struct {
+	CollectionID uint `gorm:"primary_key;auto_increment:false"`
+	ImageID      uint `gorm:"primary_key;auto_increment:false;index"`
+	Position     int  `gorm:"not_null;default:0"`
+	CreatedAt    time.Time
+}
+
+// CollectionService provides an interface to the Collection model
+type CollectionService interface {
+	CollectionDB
+}
+
+// CollectionDB is the DB connection for collections
+type CollectionDB interface {
+	ByID(id uint) (*Collection, error)
+	ByUserID(userID uint) ([]Collection, error)
+	Create(collection *Collection) error
+	Update(collection *Collection) error
+	Delete(id uint) error
+
+	// Images lists the collection's images in order, leaving out images in
+	// galleries viewerID may not see; 0 is a visitor
+	Images(collectionID, viewerID uint) ([]Image, error)
+
+	// AddImage appends the image to the collection; adding it twice is a no-op
+	AddImage(collectionID, imageID uint) error
+	RemoveImage(collectionID, imageID uint) error
+
+	// Reorder sets the positions of the collection's images to the order of ids
+	Reorder(collectionID uint, ids []uint) error
+}
+
+type collectionService struct {
+	CollectionDB
+}
+
+type collectionValidator struct {
+	CollectionDB
+}
+
+type collectionGorm struct {
+	db *gorm.DB
+}
+
+// NewCollectionService instantiates a new CollectionService
+func NewCollectionService(db *gorm.DB) CollectionService {
+	return &collectionService{
+		CollectionDB: &collectionValidator{
+			CollectionDB: &collectionGorm{
+				db: db,
+			},
+		},
+	}
+}
+
+func (cv *collectionValidator) Create(collection *Collection) error {
+	err := runCollectionValFns(collection,
+		cv.userIDRequired,
+		cv.titleRequired,
+		cv.visibilityValid)
+	if err != nil {
+		return err
+	}
+	return cv.CollectionDB.Create(collection)
+}
+
+func (cg *collectionGorm) Create(collection *Collection) error {
+	return cg.db.Create(collection).Error
+}
+
+func (cg *collectionGorm) ByID(id uint) (*Collection, error) {
+	var collection Collection
+	err := first(cg.db.Where("id = ?", id), &collection)
+	if err != nil {
+		return nil, err
+	}
+	return &collection, nil
+}
+
+func (cg *collectionGorm) ByUserID(userID uint) ([]Collection, error) {
+	var collections []Collection
+	err := cg.db.Where("user_id = ?", userID).Order("created_at desc").Find(&collections).Error
+	if err != nil {
+		return nil, err
+	}
+	return collections, nil
+}
+
+func (cv *collectionValidator) Update(collection *Collection) error {
+	err := runCollectionValFns(collection,
+		cv.userIDRequired,
+		cv.titleRequired,
+		cv.visibilityValid,
+	)
+	if err != nil {
+		return err
+	}
+	return cv.CollectionDB.Update(collection)
+}
+
+func (cg *collectionGorm) Update(collection *Collection) error {
+	return cg.db.Save(collection).Error
+}
+
+func (cv *collectionValidator) Delete(id uint) error {
+	var collection Collection
+	collection.ID = id
+	if err := runCollectionValFns(&collection, cv.nonZeroID); err != nil {
+		return err
+	}
+	return cv.CollectionDB.Delete(id)
+}
+
+func (cg *collectionGorm) Delete(id uint) error {
+	collection := Collection{Model: gorm.Model{ID: id}}
+	return cg.db.Delete(&collection).Error
+}
+
+func (cg *collectionGorm) Images(collectionID, viewerID uint) ([]Image, error) {
+	var images []Image
+	err := visibleImages(cg.db, viewerID).
+		Joins("JOIN collection_images ON collection_images.image_id = images.id").
+		Where("collection_images.collection_id = ?", collectionID).
+		Order("collection_images.position, collection_images.created_at").
+		Select("images.*").
+		Find(&images).Error
+	if err != nil {
+		return nil, err
+	}
+	return images, nil
+}
+
+func (cg *collectionGorm) AddImage(collectionID, imageID uint) error {
+	return cg.db.Transaction(func(tx *gorm.DB) error {
+		var count int
+		err := tx.Model(&CollectionImage{}).Where("collection_id = ? AND image_id = ?", collectionID, imageID).Count(&count).Error
+		if err != nil || count > 0 {
+			return err
+		}
+		link := CollectionImage{CollectionID: collectionID, ImageID: imageID}
+		row := tx.Model(&CollectionImage{}).Where("collection_id = ?", collectionID).Select("COALESCE(MAX(position), -1) + 1").Row()
+		if err := row.Scan(&link.Position); err != nil {
+			return err
+		}
+		return tx.Create(&link).Error
+	})
+}
+
+func (cg *collectionGorm) RemoveImage(collectionID, imageID uint) error {
+	return cg.db.Where("collection_id = ? AND image_id = ?", collectionID, imageID).Delete(&CollectionImage{}).Error
+}
+
+func (cv *collectionValidator) Reorder(collectionID uint, ids []uint) error {
+	seen := make(map[uint]bool, len(ids))
+	for _, id := range ids {
+		if id == 0 || seen[id] {
+			return ErrCollectionOrderInvalid
+		}
+		seen[id] = true
+	}
+	return cv.CollectionDB.Reorder(collectionID, ids)
+}
+
+// Reorder runs in a transaction like the image reorder of a gallery
+func (cg *collectionGorm) Reorder(collectionID uint, ids []uint) error {
+	return cg.db.Transaction(func(tx *gorm.DB) error {
+		// links to images of deleted galleries are not shown, so they are not expected either
+		var existing []uint
+		err := tx.Table("collection_images").
+			Joins("JOIN images ON images.id = collection_images.image_id AND images.deleted_at IS NULL").
+			Joins("JOIN galleries ON galleries.id = images.gallery_id AND galleries.deleted_at IS NULL").
+			Where("collection_images.collection_id = ?", collectionID).
+			Pluck("collection_images.image_id", &existing).Error
+		if err != nil {
+			return err
+		}
+		if len(existing) != len(ids) {
+			return ErrCollectionOrderInvalid
+		}
+		want := make(map[uint]bool, len(ids))
+		for _, id := range ids {
+			want[id] = true
+		}
+		for _, id := range existing {
+			if !want[id] {
+				return ErrCollectionOrderInvalid
+			}
+		}
+
+		for pos, id := range ids {
+			err := tx.Model(&CollectionImage{}).Where("collection_id = ? AND image_id = ?", collectionID, id).Update("position", pos).Error
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+type collectionValFn func(*Collection) error
+
+func runCollectionValFns(collection *Collection, fns ...collectionValFn) error {
+	var verr ValidationError
+	for _, fn := range fns {
+		if err := fn(collection); err != nil && !verr.add(err) {
+			return err
+		}
+	}
+	return verr.err()
+}
+
+func (cv *collectionValidator) userIDRequired(c *Collection) error {
+	if c.UserID <= 0 {
+		return ErrUserIDRequired
+	}
+	return nil
+}
+
+func (cv *collectionValidator) titleRequired(c *Collection) error {
+	if c.Title == "" {
+		return ErrTitleRequired
+	}
+	return nil
+}
+
+func (cv *collectionValidator) visibilityValid(c *Collection) error {
+	switch c.Visibility {
+	case "":
+		c.Visibility = VisibilityPublic
+	case VisibilityPublic, VisibilityPrivate:
+	default:
+		return ErrVisibilityInvalid
+	}
+	return nil
+}
+
+func (cv *collectionValidator) nonZeroID(collection *Collection) error {
+	if collection.ID <= 0 {
+		return ErrIDInvalid
+	}
+	return nil
+}
@@ -1,10 +1,25 @@
 package model
 
-import "github.com/jinzhu/gorm"
+import (
+	"time"
+
+	"github.com/jhampac/picha/cache"
+	"github.com/jinzhu/gorm"
+)
 
 const (
-	ErrUserIDRequired modelError = "model: user ID is required"
-	ErrTitleRequired  modelError = "model: title is required"
+	ErrUserIDRequired    modelError = "model: user ID is required"
+	ErrTitleRequired     modelError = "model: title is required"
+	ErrVisibilityInvalid modelError = "model: visibility must be public or private"
+)
+
+// Gallery visibilities
+const (
+	// VisibilityPublic galleries can be viewed and found by anyone
+	VisibilityPublic = "public"
+
+	// VisibilityPrivate galleries are only shown to their owner and members
+	VisibilityPrivate = "private"
 )
 
 // Gallery contains images to view
@@ -12,6 +27,17 @@ type Gallery struct {
 	gorm.Model
 	UserID uint   `gorm:"not_null;index"`
 	Title  string `gorm:"not_null"`
+
+	// Description is Markdown; it is sanitized when rendered
+	Description string `gorm:"type:text"`
+
+	// CoverImageID is the image shown on listings; 0 uses the first image
+	CoverImageID uint
+
+	// Visibility is VisibilityPublic or VisibilityPrivate
+	Visibility string `gorm:"not_null;default:'public'"`
+
+	Tags []Tag `gorm:"many2many:gallery_tags"`
 }
 
 // GalleryService provides an interface to the Gallery model
@@ -22,6 +48,7 @@ type GalleryService interface {
 // GalleryDB is the DB connection for galleries
 type GalleryDB interface {
 	ByID(id uint) (*Gallery, error)
+	ByUserID(userID uint) ([]Gallery, error)
 	Create(gallery *Gallery) error
 	Update(gallery *Gallery) error
 	Delete(id uint) error
@@ -39,13 +66,18 @@ type galleryGorm struct {
 	db *gorm.DB
 }
 
-// NewGalleryService instantiates a new GalleryService
-func NewGalleryService(db *gorm.DB) GalleryService {
+// NewGalleryService instantiates a new GalleryService; galleries found by ID
+// are kept in c unless it is nil
+func NewGalleryService(db *gorm.DB, c cache.Cache) GalleryService {
+	var gg GalleryDB = &galleryGorm{
+		db: db,
+	}
+	if c != nil {
+		gg = &galleryCache{GalleryDB: gg, cache: c}
+	}
 	return &galleryService{
 		GalleryDB: &galleryValidator{
-			GalleryDB: &galleryGorm{
-				db: db,
-			},
+			GalleryDB: gg,
 		},
 	}
 }
@@ -53,7 +85,8 @@ func NewGalleryService(db *gorm.DB) GalleryService {
 func (gv *galleryValidator) Create(gallery *Gallery) error {
 	err := runGalleryValFns(gallery,
 		gv.userIDRequired,
-		gv.titleRequired)
+		gv.titleRequired,
+		gv.visibilityValid)
 	if err != nil {
 		return err
 	}
@@ -74,10 +107,20 @@ func (gg *galleryGorm) ByID(id uint) (*Gallery, error) {
 	return &gallery, nil
 }
 
+func (gg *galleryGorm) ByUserID(userID uint) ([]Gallery, error) {
+	var galleries []Gallery
+	err := gg.db.Where("user_id = ?", userID).Order("created_at desc").Find(&galleries).Error
+	if err != nil {
+		return nil, err
+	}
+	return galleries, nil
+}
+
 func (gv *galleryValidator) Update(gallery *Gallery) error {
 	err := runGalleryValFns(gallery,
 		gv.userIDRequired,
 		gv.titleRequired,
+		gv.visibilityValid,
 	)
 	if err != nil {
 		return err
@@ -98,20 +141,30 @@ func (gv *galleryValidator) Delete(id uint) error {
 	return gv.GalleryDB.Delete(gallery.ID)
 }
 
+// Delete moves the gallery to the trash along with its images, marked so
+// restoring the gallery brings back only them
 func (gg *galleryGorm) Delete(id uint) error {
-	gallery := Gallery{Model: gorm.Model{ID: id}}
-	return gg.db.Delete(&gallery).Error
+	return gg.db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Model(&Image{}).Where("gallery_id = ?", id).
+			UpdateColumns(map[string]interface{}{"deleted_at": time.Now(), "deleted_with_gallery": true}).Error
+		if err != nil {
+			return err
+		}
+		gallery := Gallery{Model: gorm.Model{ID: id}}
+		return tx.Delete(&gallery).Error
+	})
 }
 
 type galleryValFn func(*Gallery) error
 
 func runGalleryValFns(gallery *Gallery, fns ...galleryValFn) error {
+	var verr ValidationError
 	for _, fn := range fns {
-		if err := fn(gallery); err != nil {
+		if err := fn(gallery); err != nil && !verr.add(err) {
 			return err
 		}
 	}
-	return nil
+	return verr.err()
 }
 
 func (gv *galleryValidator) userIDRequired(g *Gallery) error {
@@ -128,6 +181,18 @@ func (gv *galleryValidator) titleRequired(g *Gallery) error {
 	return nil
 }
 
+// visibilityValid defaults to public so existing callers keep their behaviour
+func (gv *galleryValidator) visibilityValid(g *Gallery) error {
+	switch g.Visibility {
+	case "":
+		g.Visibility = VisibilityPublic
+	case VisibilityPublic, VisibilityPrivate:
+	default:
+		return ErrVisibilityInvalid
+	}
+	return nil
+}
+
 func (gv *galleryValidator) nonZeroID(gallery *Gallery) error {
 	if gallery.ID <= 0 {
 		return ErrIDInvalid
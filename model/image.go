@@ -0,0 +1,819 @@
+package model
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/jhampac/picha/imaging"
+	"github.com/jhampac/picha/jobs"
+	"github.com/jhampac/picha/rand"
+	"github.com/jhampac/picha/storage"
+	"github.com/jinzhu/gorm"
+)
+
+const (
+	// ErrGalleryIDRequired is returned when an image is created without a gallery
+	ErrGalleryIDRequired modelError = "model: gallery ID is required"
+
+	// ErrImageUnsupported is returned when an upload is not a JPEG, PNG or GIF image
+	ErrImageUnsupported modelError = "model: only JPEG, PNG and GIF images can be uploaded"
+
+	// ErrImageOrderInvalid is returned when a new order does not list every image of the gallery exactly once
+	ErrImageOrderInvalid modelError = "model: the new order must list every image of the gallery exactly once"
+
+	// ErrImageTitleTooLong is returned when an image title is longer than maxImageTitle
+	ErrImageTitleTooLong modelError = "model: image titles can be at most 200 characters long"
+
+	// ErrAltTooLong is returned when alt text is longer than maxAlt
+	ErrAltTooLong modelError = "model: alt text can be at most 250 characters long"
+
+	// ErrCaptionTooLong is returned when a caption is longer than maxCaption
+	ErrCaptionTooLong modelError = "model: captions can be at most 2000 characters long"
+
+	// ErrImageTooLarge is returned for uploads with more than imaging.MaxPixels pixels
+	ErrImageTooLarge modelError = "model: images can be at most 100 megapixels"
+)
+
+// JobImageVariants is the kind of job that stores the resized copies of a new image
+const JobImageVariants = "image.variants"
+
+// text limits, in characters
+const (
+	maxImageTitle = 200
+	maxAlt        = 250
+	maxCaption    = 2000
+)
+
+// Image is a photo in a gallery; its bytes live in the storage backend under Key
+type Image struct {
+	gorm.Model
+	GalleryID uint   `gorm:"not_null;index"`
+	Filename  string `gorm:"not_null"`
+	Key       string `gorm:"not_null"`
+	Size      int64
+	Width     int
+	Height    int
+
+	// Hash is the hex SHA-256 of the original file, used to find duplicates
+	Hash string `gorm:"index"`
+
+	// Title, Caption and Alt are written by the gallery owner; Alt describes
+	// the image for screen readers and when it fails to load
+	Title   string
+	Caption string `gorm:"type:text"`
+	Alt     string
+
+	// Position orders the images of a gallery, lowest first
+	Position int `gorm:"not_null;default:0"`
+
+	// Pending images have no resized variants yet and are served from the original
+	Pending bool `gorm:"not_null;default:false"`
+
+	// DeletedWithGallery marks images moved to the trash along with their
+	// gallery, and so restored with it
+	DeletedWithGallery bool `gorm:"not_null;default:false"`
+
+	Tags []Tag `gorm:"many2many:image_tags"`
+}
+
+// VariantKey is the storage key of the resized copy of the image at width
+func (i *Image) VariantKey(width int) string {
+	return fmt.Sprintf("%s.w%d.jpg", i.Key, width)
+}
+
+// AltText is the text alternative for the image: its alt text, else its
+// title, else the uploaded file name so links to it are never unlabelled
+func (i *Image) AltText() string {
+	switch {
+	case i.Alt != "":
+		return i.Alt
+	case i.Title != "":
+		return i.Title
+	}
+	return i.Filename
+}
+
+// SrcsetWidths lists the widths the image can be served at, including the original
+func (i *Image) SrcsetWidths() []int {
+	if i.Pending {
+		return []int{i.Width}
+	}
+	return append(imaging.VariantWidths(i.Width), i.Width)
+}
+
+// VariantWidth is the width of the variant served when asked for width, or 0
+// when the original is served instead
+func (i *Image) VariantWidth(width int) int {
+	if i.Pending {
+		return 0
+	}
+	return imaging.BestWidth(i.Width, width)
+}
+
+// ImageService provides an interface to the Image model and the stored files
+type ImageService interface {
+	ImageDB
+
+	// Upload stores the file and creates the image at the end of the gallery;
+	// its resized variants are made by a JobImageVariants job
+	Upload(galleryID uint, filename string, r io.Reader) (*Image, error)
+
+	// MakeVariants is the handler for JobImageVariants jobs
+	MakeVariants(ctx context.Context, job *jobs.Job) error
+
+	// QueueVariants queues the JobImageVariants job of a pending image
+	QueueVariants(image *Image) error
+
+	// Open reads the image at the smallest variant at least width pixels wide; 0 opens the original
+	Open(image *Image, width int) (io.ReadCloser, error)
+
+	// Remove moves the image to the trash
+	Remove(image *Image) error
+
+	// Sweep removes the files of blobs no image refers to anymore
+	Sweep(ctx context.Context) error
+
+	// SweepBlobs is the handler for JobSweepBlobs jobs
+	SweepBlobs(ctx context.Context, job *jobs.Job) error
+
+	// ReconcileUsage measures every blob's files in the store and recomputes
+	// each user's storage usage from them, returning the users it corrected
+	ReconcileUsage(ctx context.Context) ([]UsageChange, error)
+}
+
+// ImageDB is the DB connection for images
+type ImageDB interface {
+	ByID(id uint) (*Image, error)
+	ByGalleryID(galleryID uint) ([]Image, error)
+
+	// ByHash finds an image of the gallery with the same file content
+	ByHash(galleryID uint, hash string) (*Image, error)
+
+	// Duplicates lists the galleries userID owns or is a member of that have
+	// another image with the same content as image
+	Duplicates(image *Image, userID uint) ([]Gallery, error)
+
+	// Covers returns the cover image of each gallery that has images, keyed by gallery ID
+	Covers(galleries []Gallery) (map[uint]*Image, error)
+
+	Create(image *Image) error
+	Update(image *Image) error
+	Delete(id uint) error
+
+	// Processed clears Pending once the image's variants are stored
+	Processed(id uint) error
+
+	// Reorder sets the positions of the gallery's images to the order of ids
+	Reorder(galleryID uint, ids []uint) error
+}
+
+type imageService struct {
+	ImageDB
+	store storage.Store
+	queue jobs.Queue
+	blobs *blobGorm
+	usage *usageGorm
+}
+
+type imageValidator struct {
+	ImageDB
+}
+
+type imageGorm struct {
+	db *gorm.DB
+}
+
+// imageVariants is the payload of JobImageVariants jobs
+type imageVariants struct {
+	ImageID uint `json:"image_id"`
+}
+
+// NewImageService instantiates a new ImageService keeping files in store and
+// resizing new images through queue
+func NewImageService(db *gorm.DB, store storage.Store, queue jobs.Queue) ImageService {
+	return &imageService{
+		ImageDB: &imageValidator{
+			ImageDB: &imageGorm{
+				db: db,
+			},
+		},
+		store: store,
+		queue: queue,
+		blobs: &blobGorm{db: db},
+		usage: &usageGorm{db: db},
+	}
+}
+
+// HashBytes is the content hash stored in Image.Hash
+func HashBytes(b []byte) string {
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
+
+// Upload keeps one copy of every distinct file: when a blob with the same
+// content exists, the image only adds a reference to it. The file is
+// streamed, never held in memory as a whole.
+func (is *imageService) Upload(galleryID uint, filename string, r io.Reader) (*Image, error) {
+	file, err := spool(r)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	cfg, format, err := imaging.DecodeConfig(file)
+	if err == imaging.ErrUnsupported {
+		return nil, ErrImageUnsupported
+	}
+	if err != nil {
+		return nil, err
+	}
+	if cfg.Width*cfg.Height > imaging.MaxPixels {
+		return nil, ErrImageTooLarge
+	}
+
+	// refuse early what cannot fit rather than store it first; Create checks
+	// again as it charges, in case other uploads took the space meanwhile
+	owner, err := is.usage.galleryOwner(galleryID)
+	if err != nil {
+		return nil, err
+	}
+	if file.size+variantsEstimate(file.size, cfg.Width) > owner.StorageLeft() {
+		return nil, ErrQuotaExceeded
+	}
+
+	blob, err := is.blobs.acquire(file.hash)
+	if err != nil {
+		return nil, err
+	}
+	if blob == nil {
+		if err := file.rewind(); err != nil {
+			return nil, err
+		}
+		if blob, err = is.storeBlob(file.hash, format, cfg.Width, file.size, file); err != nil {
+			return nil, err
+		}
+	}
+	image := Image{
+		GalleryID: galleryID,
+		Filename:  path.Base(filename),
+		Key:       blob.Key,
+		Size:      file.size,
+		Width:     cfg.Width,
+		Height:    cfg.Height,
+		Hash:      file.hash,
+		Pending:   !blob.Variants && len(imaging.VariantWidths(cfg.Width)) > 0,
+	}
+	if err := is.Create(&image); err != nil {
+		is.release(&image)
+		return nil, err
+	}
+	if !image.Pending {
+		return &image, nil
+	}
+
+	// resizing is slow, so it happens in the background; should the job not
+	// be queued, the variants are made right away
+	if err := is.QueueVariants(&image); err != nil {
+		slog.Error("queueing image variants", "image_id", image.ID, "error", err)
+		if err := is.makeVariants(&image); err != nil {
+			return nil, err
+		}
+		image.Pending = false
+	}
+	return &image, nil
+}
+
+// spooledFile is an upload read through once to hash and measure it, then
+// rewound. Uploads that cannot seek are copied to a temporary file.
+type spooledFile struct {
+	io.ReadSeeker
+	size int64
+	hash string
+	tmp  *os.File
+}
+
+func spool(r io.Reader) (*spooledFile, error) {
+	file := &spooledFile{}
+	h := sha256.New()
+	var err error
+	if rs, ok := r.(io.ReadSeeker); ok {
+		file.ReadSeeker = rs
+		if _, err = rs.Seek(0, io.SeekStart); err == nil {
+			file.size, err = io.Copy(h, rs)
+		}
+	} else {
+		if file.tmp, err = os.CreateTemp("", "picha-upload-*"); err != nil {
+			return nil, err
+		}
+		file.ReadSeeker = file.tmp
+		file.size, err = io.Copy(io.MultiWriter(file.tmp, h), r)
+	}
+	if err == nil {
+		err = file.rewind()
+	}
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	file.hash = hex.EncodeToString(h.Sum(nil))
+	return file, nil
+}
+
+func (f *spooledFile) rewind() error {
+	_, err := f.Seek(0, io.SeekStart)
+	return err
+}
+
+// Close removes the temporary copy, if any
+func (f *spooledFile) Close() error {
+	if f.tmp == nil {
+		return nil
+	}
+	f.tmp.Close()
+	return os.Remove(f.tmp.Name())
+}
+
+// storeBlob writes a new original of size bytes from r with one reference
+func (is *imageService) storeBlob(hash, format string, width int, size int64, r io.Reader) (*Blob, error) {
+	token, err := rand.String(9)
+	if err != nil {
+		return nil, err
+	}
+	blob := Blob{
+		Key:   blobKey(hash, token, format),
+		Hash:  hash,
+		Size:  size,
+		Width: width,
+		Refs:  1,
+	}
+	if blob.Bytes, err = is.store.Put(blob.Key, r); err != nil {
+		is.removeFiles(&blob)
+		return nil, err
+	}
+	if err := is.blobs.create(&blob); err != nil {
+		is.removeFiles(&blob)
+		return nil, err
+	}
+	return &blob, nil
+}
+
+func (is *imageService) QueueVariants(image *Image) error {
+	_, err := is.queue.Enqueue(context.Background(), JobImageVariants, imageVariants{ImageID: image.ID})
+	return err
+}
+
+func (is *imageService) MakeVariants(ctx context.Context, job *jobs.Job) error {
+	var payload imageVariants
+	if err := job.Decode(&payload); err != nil {
+		return err
+	}
+	image, err := is.ByID(payload.ImageID)
+	if err == ErrNotFound {
+		// deleted before it was resized; restoring it queues the job again
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if !image.Pending {
+		return nil
+	}
+
+	// another image with the same content may have been resized meanwhile
+	blob, err := is.blobs.byKey(image.Key)
+	if err != nil {
+		return err
+	}
+	if blob.Variants {
+		return is.Processed(image.ID)
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return is.makeVariants(image)
+}
+
+// makeVariants stores the resized copies of the image's blob and marks both done
+func (is *imageService) makeVariants(image *Image) error {
+	rc, err := is.store.Open(image.Key)
+	if err != nil {
+		return err
+	}
+	n, err := is.storeVariants(image, rc)
+	rc.Close()
+	if err != nil {
+		return err
+	}
+	if err := is.blobs.variantsStored(image.Key, n); err != nil {
+		return err
+	}
+	return is.Processed(image.ID)
+}
+
+// storeVariants writes one resized copy of the original per applicable
+// width and returns how many bytes they take up
+func (is *imageService) storeVariants(image *Image, original io.Reader) (int64, error) {
+	widths := imaging.VariantWidths(image.Width)
+	if len(widths) == 0 {
+		return 0, nil
+	}
+
+	src, err := imaging.Decode(original)
+	if err != nil {
+		return 0, err
+	}
+	var total int64
+	for _, w := range widths {
+		var buf bytes.Buffer
+		if err := imaging.EncodeJPEG(&buf, imaging.Resize(src, w)); err != nil {
+			return 0, err
+		}
+		n, err := is.store.Put(image.VariantKey(w), &buf)
+		if err != nil {
+			return 0, err
+		}
+		total += n
+	}
+	return total, nil
+}
+
+// removeFiles deletes the original and the variants of a blob
+func (is *imageService) removeFiles(blob *Blob) {
+	for _, key := range blob.files() {
+		if err := is.store.Delete(key); err != nil {
+			slog.Error("removing image file", "key", key, "error", err)
+		}
+	}
+}
+
+// release drops the image's reference to its blob and sweeps the blob when it was the last one
+func (is *imageService) release(image *Image) {
+	if err := releaseBlob(is.blobs.db, image.Key); err != nil {
+		slog.Error("releasing blob", "key", image.Key, "error", err)
+		return
+	}
+	if blob, err := is.blobs.byKey(image.Key); err == nil && blob.Refs <= 0 {
+		is.sweep(blob)
+	}
+}
+
+// sweep removes an unreferenced blob; its files go only once its row is gone,
+// so an upload referencing it again in the meantime keeps it
+func (is *imageService) sweep(blob *Blob) error {
+	removed, err := is.blobs.remove(blob.Key)
+	if err != nil || !removed {
+		return err
+	}
+	is.removeFiles(blob)
+	return nil
+}
+
+func (is *imageService) SweepBlobs(ctx context.Context, job *jobs.Job) error {
+	return is.Sweep(ctx)
+}
+
+func (is *imageService) Sweep(ctx context.Context) error {
+	blobs, err := is.blobs.unreferenced()
+	if err != nil {
+		return err
+	}
+	for i := range blobs {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := is.sweep(&blobs[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (is *imageService) ReconcileUsage(ctx context.Context) ([]UsageChange, error) {
+	blobs, err := is.blobs.all()
+	if err != nil {
+		return nil, err
+	}
+	for i := range blobs {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		blob := &blobs[i]
+		keys := blob.files()
+		if !blob.Variants {
+			keys = keys[:1]
+		}
+		var bytes int64
+		for _, key := range keys {
+			n, err := is.store.Size(key)
+			if errors.Is(err, fs.ErrNotExist) {
+				slog.WarnContext(ctx, "image file missing from storage", "key", key)
+				continue
+			}
+			if err != nil {
+				return nil, err
+			}
+			bytes += n
+		}
+		if bytes != blob.Bytes {
+			if err := is.blobs.setBytes(blob.Key, bytes); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return is.usage.recompute()
+}
+
+func (is *imageService) Open(image *Image, width int) (io.ReadCloser, error) {
+	if w := image.VariantWidth(width); w > 0 {
+		return is.store.Open(image.VariantKey(w))
+	}
+	return is.store.Open(image.Key)
+}
+
+func (is *imageService) Remove(image *Image) error {
+	return is.Delete(image.ID)
+}
+
+func (iv *imageValidator) Create(image *Image) error {
+	if err := runImageValFns(image, iv.galleryIDRequired, iv.filenameSafe); err != nil {
+		return err
+	}
+	return iv.ImageDB.Create(image)
+}
+
+// Create also charges the image's blob to the gallery owner's storage usage,
+// failing with ErrQuotaExceeded when it does not fit with room left for the
+// variants of a pending image
+func (ig *imageGorm) Create(image *Image) error {
+	return ig.db.Transaction(func(tx *gorm.DB) error {
+		// append to the end of the gallery
+		row := tx.Model(&Image{}).Where("gallery_id = ?", image.GalleryID).Select("COALESCE(MAX(position), -1) + 1").Row()
+		if err := row.Scan(&image.Position); err != nil {
+			return err
+		}
+		if err := tx.Create(image).Error; err != nil {
+			return err
+		}
+		var headroom int64
+		if image.Pending {
+			headroom = variantsEstimate(image.Size, image.Width)
+		}
+		return chargeUpload(tx, image, headroom)
+	})
+}
+
+func (ig *imageGorm) ByID(id uint) (*Image, error) {
+	var image Image
+	err := first(ig.db.Where("id = ?", id), &image)
+	if err != nil {
+		return nil, err
+	}
+	return &image, nil
+}
+
+func (ig *imageGorm) ByHash(galleryID uint, hash string) (*Image, error) {
+	var image Image
+	err := first(ig.db.Where("gallery_id = ? AND hash = ?", galleryID, hash), &image)
+	if err != nil {
+		return nil, err
+	}
+	return &image, nil
+}
+
+func (ig *imageGorm) Duplicates(image *Image, userID uint) ([]Gallery, error) {
+	var galleries []Gallery
+	if image.Hash == "" {
+		return galleries, nil
+	}
+	err := ig.db.Where(`galleries.id IN (SELECT gallery_id FROM images WHERE hash = ? AND id <> ? AND deleted_at IS NULL)
+		AND (galleries.user_id = ? OR EXISTS (SELECT 1 FROM gallery_members WHERE gallery_members.gallery_id = galleries.id AND gallery_members.user_id = ?))`,
+		image.Hash, image.ID, userID, userID).
+		Order("galleries.id").Find(&galleries).Error
+	if err != nil {
+		return nil, err
+	}
+	return galleries, nil
+}
+
+func (ig *imageGorm) ByGalleryID(galleryID uint) ([]Image, error) {
+	var images []Image
+	err := ig.db.Where("gallery_id = ?", galleryID).Order("position, id").Find(&images).Error
+	if err != nil {
+		return nil, err
+	}
+	return images, nil
+}
+
+func (ig *imageGorm) Covers(galleries []Gallery) (map[uint]*Image, error) {
+	covers := make(map[uint]*Image, len(galleries))
+	if len(galleries) == 0 {
+		return covers, nil
+	}
+
+	// the chosen covers first
+	chosen := make(map[uint]uint)
+	var coverIDs []uint
+	for _, g := range galleries {
+		if g.CoverImageID != 0 {
+			chosen[g.ID] = g.CoverImageID
+			coverIDs = append(coverIDs, g.CoverImageID)
+		}
+	}
+	if len(coverIDs) > 0 {
+		var images []Image
+		if err := ig.db.Where("id IN (?)", coverIDs).Find(&images).Error; err != nil {
+			return nil, err
+		}
+		for i := range images {
+			if chosen[images[i].GalleryID] == images[i].ID {
+				covers[images[i].GalleryID] = &images[i]
+			}
+		}
+	}
+
+	// then the first image of galleries without a (still existing) cover
+	var rest []uint
+	for _, g := range galleries {
+		if covers[g.ID] == nil {
+			rest = append(rest, g.ID)
+		}
+	}
+	if len(rest) == 0 {
+		return covers, nil
+	}
+	var firsts []Image
+	err := ig.db.
+		Where("gallery_id IN (?)", rest).
+		Where("position = (SELECT MIN(i2.position) FROM images i2 WHERE i2.gallery_id = images.gallery_id AND i2.deleted_at IS NULL)").
+		Order("id").
+		Find(&firsts).Error
+	if err != nil {
+		return nil, err
+	}
+	for i := range firsts {
+		if covers[firsts[i].GalleryID] == nil {
+			covers[firsts[i].GalleryID] = &firsts[i]
+		}
+	}
+	return covers, nil
+}
+
+func (iv *imageValidator) Update(image *Image) error {
+	err := runImageValFns(image,
+		iv.nonZeroID,
+		iv.galleryIDRequired,
+		iv.trimText,
+		iv.titleLength,
+		iv.captionLength,
+		iv.altLength,
+	)
+	if err != nil {
+		return err
+	}
+	return iv.ImageDB.Update(image)
+}
+
+// Update only writes the details editors change, so saving a copy loaded
+// earlier cannot undo a Processed or Reorder made since
+func (ig *imageGorm) Update(image *Image) error {
+	return ig.db.Model(image).Updates(map[string]interface{}{
+		"title":   image.Title,
+		"caption": image.Caption,
+		"alt":     image.Alt,
+	}).Error
+}
+
+func (ig *imageGorm) Processed(id uint) error {
+	return ig.db.Model(&Image{}).Where("id = ?", id).UpdateColumn("pending", false).Error
+}
+
+func (iv *imageValidator) Delete(id uint) error {
+	var image Image
+	image.ID = id
+	if err := runImageValFns(&image, iv.nonZeroID); err != nil {
+		return err
+	}
+	return iv.ImageDB.Delete(id)
+}
+
+// Delete moves the image to the trash. It keeps its blob, its storage usage
+// and its place in collections, which hide it, until it is purged.
+func (ig *imageGorm) Delete(id uint) error {
+	image := Image{Model: gorm.Model{ID: id}}
+	return ig.db.Delete(&image).Error
+}
+
+func (iv *imageValidator) Reorder(galleryID uint, ids []uint) error {
+	seen := make(map[uint]bool, len(ids))
+	for _, id := range ids {
+		if id == 0 || seen[id] {
+			return ErrImageOrderInvalid
+		}
+		seen[id] = true
+	}
+	return iv.ImageDB.Reorder(galleryID, ids)
+}
+
+// Reorder runs in a transaction so a concurrent upload or a failure half way
+// never leaves the gallery partially reordered
+func (ig *imageGorm) Reorder(galleryID uint, ids []uint) error {
+	return ig.db.Transaction(func(tx *gorm.DB) error {
+		var existing []uint
+		if err := tx.Model(&Image{}).Where("gallery_id = ?", galleryID).Pluck("id", &existing).Error; err != nil {
+			return err
+		}
+		if len(existing) != len(ids) {
+			return ErrImageOrderInvalid
+		}
+		want := make(map[uint]bool, len(ids))
+		for _, id := range ids {
+			want[id] = true
+		}
+		for _, id := range existing {
+			if !want[id] {
+				return ErrImageOrderInvalid
+			}
+		}
+
+		for pos, id := range ids {
+			err := tx.Model(&Image{}).Where("id = ? AND gallery_id = ?", id, galleryID).Update("position", pos).Error
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+type imageValFn func(*Image) error
+
+func runImageValFns(image *Image, fns ...imageValFn) error {
+	var verr ValidationError
+	for _, fn := range fns {
+		if err := fn(image); err != nil && !verr.add(err) {
+			return err
+		}
+	}
+	return verr.err()
+}
+
+func (iv *imageValidator) galleryIDRequired(image *Image) error {
+	if image.GalleryID <= 0 {
+		return ErrGalleryIDRequired
+	}
+	return nil
+}
+
+// filenameSafe strips any directories a browser sent along with the file name
+func (iv *imageValidator) filenameSafe(image *Image) error {
+	image.Filename = path.Base(strings.ReplaceAll(image.Filename, "\\", "/"))
+	if image.Filename == "." || image.Filename == "/" {
+		image.Filename = "image"
+	}
+	return nil
+}
+
+func (iv *imageValidator) trimText(image *Image) error {
+	image.Title = strings.TrimSpace(image.Title)
+	image.Caption = strings.TrimSpace(image.Caption)
+	image.Alt = strings.TrimSpace(image.Alt)
+	return nil
+}
+
+func (iv *imageValidator) titleLength(image *Image) error {
+	if utf8.RuneCountInString(image.Title) > maxImageTitle {
+		return ErrImageTitleTooLong
+	}
+	return nil
+}
+
+func (iv *imageValidator) captionLength(image *Image) error {
+	if utf8.RuneCountInString(image.Caption) > maxCaption {
+		return ErrCaptionTooLong
+	}
+	return nil
+}
+
+func (iv *imageValidator) altLength(image *Image) error {
+	if utf8.RuneCountInString(image.Alt) > maxAlt {
+		return ErrAltTooLong
+	}
+	return nil
+}
+
+func (iv *imageValidator) nonZeroID(image *Image) error {
+	if image.ID <= 0 {
+		return ErrIDInvalid
+	}
+	return nil
+}
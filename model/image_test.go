@@ -0,0 +1,36 @@
+package model
+
+import "testing"
+
+func TestUpdateKeepsChangesMadeSinceTheImageWasLoaded(t *testing.T) {
+	env := newTestEnv(t)
+	beach := env.gallery(t, env.user(t, "alice@example.com"), "Beach")
+	first := env.upload(t, beach, jpegBytes(t, 400, 300, 1))
+	second := env.upload(t, beach, jpegBytes(t, 400, 300, 2))
+
+	// an editor opened the first image before it was resized and moved
+	stale, err := env.Image.ByID(first.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	env.makeVariants(t)
+	if err := env.Image.Reorder(beach.ID, []uint{second.ID, first.ID}); err != nil {
+		t.Fatal(err)
+	}
+
+	stale.Title = "Sunset"
+	stale.Caption = "  Over the bay  "
+	if err := env.Image.Update(stale); err != nil {
+		t.Fatal(err)
+	}
+	found, err := env.Image.ByID(first.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if found.Title != "Sunset" || found.Caption != "Over the bay" {
+		t.Fatalf("details are %q, %q, want the edit", found.Title, found.Caption)
+	}
+	if found.Pending || found.Position != 1 {
+		t.Fatalf("pending %v at position %d, want the variants and the new order kept", found.Pending, found.Position)
+	}
+}
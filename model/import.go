@@ -0,0 +1,154 @@
+package model
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+// Import job statuses
+const (
+	ImportQueued  = "queued"
+	ImportRunning = "running"
+	ImportDone    = "done"
+	ImportFailed  = "failed"
+)
+
+// Import item results
+const (
+	ItemImported  = "imported"
+	ItemDuplicate = "duplicate"
+	ItemSkipped   = "skipped"
+	ItemFailed    = "failed"
+)
+
+// ImportJob expands an archive or a server directory into a gallery. The
+// counters are kept up to date as items are added, so the job can be shown
+// while it runs.
+type ImportJob struct {
+	gorm.Model
+	UserID    uint `gorm:"not_null;index"`
+	GalleryID uint `gorm:"not_null"`
+
+	// Source is the archive or directory name, for display
+	Source string
+	Status string `gorm:"not_null"`
+
+	// Error is why a failed job stopped; problems with single files are items
+	Error string
+
+	Total      int
+	Imported   int
+	Duplicates int
+	Skipped    int
+	Failed     int
+}
+
+// Finished reports whether the job will not change anymore
+func (j *ImportJob) Finished() bool {
+	return j.Status == ImportDone || j.Status == ImportFailed
+}
+
+// ImportItem is the result for one file of an import
+type ImportItem struct {
+	ID          uint   `gorm:"primary_key"`
+	ImportJobID uint   `gorm:"not_null;index"`
+	Name        string `gorm:"not_null"`
+	Result      string `gorm:"not_null"`
+	Message     string
+
+	// ImageID is the created image, or the existing one for duplicates
+	ImageID   uint
+	CreatedAt time.Time
+}
+
+// ImportService provides the import jobs and their results
+type ImportService interface {
+	ImportDB
+}
+
+// ImportDB is the DB connection for import jobs
+type ImportDB interface {
+	ByID(id uint) (*ImportJob, error)
+	Create(job *ImportJob) error
+
+	// Update saves the status and error of the job
+	Update(job *ImportJob) error
+
+	// AddItem records a file's result and counts it on the job
+	AddItem(item *ImportItem) error
+	Items(jobID uint) ([]ImportItem, error)
+}
+
+type importService struct {
+	ImportDB
+}
+
+type importGorm struct {
+	db *gorm.DB
+}
+
+// NewImportService instantiates a new ImportService
+func NewImportService(db *gorm.DB) ImportService {
+	return &importService{
+		ImportDB: &importGorm{
+			db: db,
+		},
+	}
+}
+
+func (ig *importGorm) ByID(id uint) (*ImportJob, error) {
+	var job ImportJob
+	if err := first(ig.db.Where("id = ?", id), &job); err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
+
+func (ig *importGorm) Create(job *ImportJob) error {
+	if job.Status == "" {
+		job.Status = ImportQueued
+	}
+	return ig.db.Create(job).Error
+}
+
+// Update only writes the status and error; the counters belong to AddItem
+func (ig *importGorm) Update(job *ImportJob) error {
+	return ig.db.Model(job).Updates(map[string]interface{}{
+		"status": job.Status,
+		"error":  job.Error,
+	}).Error
+}
+
+var itemCounters = map[string]string{
+	ItemImported:  "imported",
+	ItemDuplicate: "duplicates",
+	ItemSkipped:   "skipped",
+	ItemFailed:    "failed",
+}
+
+func (ig *importGorm) AddItem(item *ImportItem) error {
+	column, ok := itemCounters[item.Result]
+	if !ok {
+		return fmt.Errorf("model: unknown import result %q", item.Result)
+	}
+	return ig.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(item).Error; err != nil {
+			return err
+		}
+		return tx.Model(&ImportJob{}).Where("id = ?", item.ImportJobID).UpdateColumns(map[string]interface{}{
+			"total": gorm.Expr("total + 1"),
+			column:  gorm.Expr(column + " + 1"),
+		}).Error
+	})
+}
+
+func (ig *importGorm) Items(jobID uint) ([]ImportItem, error) {
+	var items []ImportItem
+	err := ig.db.Where("import_job_id = ?", jobID).Order("id").Find(&items).Error
+	if err != nil {
+		return nil, err
+	}
+	return items, nil
+}
@@ -0,0 +1,52 @@
+package model
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+// gormLogger implements gorm's logger interface on top of slog
+type gormLogger struct {
+	log   *slog.Logger
+	level slog.Level
+}
+
+// Print receives gorm's positional log values. SQL entries arrive as
+// ("sql", source, duration, query, vars, rowsAffected); anything else is
+// (kind, source, values...).
+func (gl *gormLogger) Print(v ...interface{}) {
+	if len(v) < 2 {
+		return
+	}
+
+	switch v[0] {
+	case "sql":
+		if len(v) < 6 {
+			return
+		}
+		vars, _ := v[4].([]interface{})
+		duration, _ := v[2].(time.Duration)
+		gl.log.Log(context.Background(), gl.level, "sql",
+			"source", v[1],
+			"query", v[3],
+			"params", redact(vars),
+			"rows", v[5],
+			"duration_ms", float64(duration.Microseconds())/1000,
+		)
+	case "error":
+		gl.log.Error("gorm", "source", v[1], "error", fmt.Sprint(v[2:]...))
+	default:
+		gl.log.Log(context.Background(), gl.level, "gorm", "source", v[1], "msg", fmt.Sprint(v[2:]...))
+	}
+}
+
+// redact replaces bind parameters so emails, hashes and titles never reach the logs
+func redact(vars []interface{}) []string {
+	out := make([]string, len(vars))
+	for i := range vars {
+		out[i] = "?"
+	}
+	return out
+}
@@ -0,0 +1,402 @@
+package model
+
+import (
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/jhampac/picha/hash"
+	"github.com/jhampac/picha/rand"
+	"github.com/jinzhu/gorm"
+)
+
+const (
+	// ErrForbidden is returned by Authorize when the user may see the gallery but not do the action
+	ErrForbidden modelError = "model: you do not have permission to do that"
+
+	// ErrRoleInvalid is returned when a member is given anything but a member role
+	ErrRoleInvalid modelError = "model: role must be viewer, contributor or editor"
+
+	// ErrInvitationInvalid is returned for unknown, used or expired invitation tokens
+	ErrInvitationInvalid modelError = "model: this invitation is invalid or has expired"
+
+	// ErrInvitationEmail is returned when an invitation is accepted by an account with another email address
+	ErrInvitationEmail modelError = "model: this invitation was sent to a different email address"
+
+	// ErrInviteSelf is returned when the owner invites themselves
+	ErrInviteSelf modelError = "model: you already own this gallery"
+)
+
+// invitationTTL is how long an invitation link can be used
+const invitationTTL = 7 * 24 * time.Hour
+
+// Role is what a user may do in a gallery. The owner is Gallery.UserID;
+// everyone else gets a role through membership.
+type Role string
+
+// Roles from least to most access
+const (
+	RoleNone        Role = ""
+	RoleViewer      Role = "viewer"
+	RoleContributor Role = "contributor"
+	RoleEditor      Role = "editor"
+	RoleOwner       Role = "owner"
+)
+
+// MemberRoles are the roles that can be given to members, least access first
+var MemberRoles = []Role{RoleViewer, RoleContributor, RoleEditor}
+
+func (r Role) rank() int {
+	switch r {
+	case RoleViewer:
+		return 1
+	case RoleContributor:
+		return 2
+	case RoleEditor:
+		return 3
+	case RoleOwner:
+		return 4
+	}
+	return 0
+}
+
+// Can reports whether the role allows action
+func (r Role) Can(action Action) bool {
+	return r.rank() >= actionRoles[action].rank()
+}
+
+// Action is something done to a gallery that needs a minimum role
+type Action int
+
+const (
+	// ActionView is seeing the gallery and its images
+	ActionView Action = iota
+
+	// ActionUpload is adding images
+	ActionUpload
+
+	// ActionEdit is changing the gallery's details and its images
+	ActionEdit
+
+	// ActionManage is sharing and deleting the gallery
+	ActionManage
+)
+
+var actionRoles = map[Action]Role{
+	ActionView:   RoleViewer,
+	ActionUpload: RoleContributor,
+	ActionEdit:   RoleEditor,
+	ActionManage: RoleOwner,
+}
+
+// GalleryMember gives a user a role in someone else's gallery
+type GalleryMember struct {
+	GalleryID uint `gorm:"primary_key;auto_increment:false"`
+	UserID    uint `gorm:"primary_key;auto_increment:false;index"`
+	Role      Role `gorm:"not_null"`
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+// Member is a GalleryMember with the user's details, for listing
+type Member struct {
+	UserID uint
+	Name   string
+	Email  string
+	Role   Role
+}
+
+// Invitation offers a role in a gallery to an email address until it is accepted or expires
+type Invitation struct {
+	gorm.Model
+	GalleryID   uint   `gorm:"not_null;index"`
+	Email       string `gorm:"not_null"`
+	Role        Role   `gorm:"not_null"`
+	InvitedByID uint   `gorm:"not_null"`
+	ExpiresAt   time.Time
+
+	// Token is only set right after Invite, to be sent; the database keeps its hash
+	Token     string `gorm:"-"`
+	TokenHash string `gorm:"not_null;unique_index"`
+}
+
+// MemberService provides gallery membership, invitations and the permission checks built on them
+type MemberService interface {
+	MemberDB
+
+	// Authorize is the one place deciding whether user, which may be nil, may
+	// do action on gallery. It returns nil, ErrForbidden, or ErrNotFound when
+	// the user should not learn that the gallery exists.
+	Authorize(user *User, gallery *Gallery, action Action) error
+
+	// RoleOf is the role user has in gallery, counting public galleries as viewable by everyone
+	RoleOf(user *User, gallery *Gallery) (Role, error)
+
+	// Invite creates an invitation with a fresh Token for email to join gallery with role
+	Invite(gallery *Gallery, email string, role Role, by *User) (*Invitation, error)
+
+	// Accept turns the invitation with token into a membership for user
+	Accept(token string, user *User) (*Invitation, error)
+}
+
+// MemberDB is the DB connection for members and invitations
+type MemberDB interface {
+	// Role is the member role of the user in the gallery, RoleNone for non-members
+	Role(galleryID, userID uint) (Role, error)
+	Members(galleryID uint) ([]Member, error)
+
+	// SetRole adds the user to the gallery or changes their role
+	SetRole(galleryID, userID uint, role Role) error
+	RemoveMember(galleryID, userID uint) error
+
+	// SharedWith lists the galleries the user is a member of, newest first
+	SharedWith(userID uint) ([]Gallery, error)
+
+	Invitations(galleryID uint) ([]Invitation, error)
+	InvitationByID(id uint) (*Invitation, error)
+	InvitationByToken(token string) (*Invitation, error)
+	CreateInvitation(invitation *Invitation) error
+	DeleteInvitation(id uint) error
+}
+
+type memberService struct {
+	MemberDB
+}
+
+type memberValidator struct {
+	MemberDB
+	hmac       hash.HMAC
+	emailRegex *regexp.Regexp
+}
+
+type memberGorm struct {
+	db *gorm.DB
+}
+
+// NewMemberService instantiates a new MemberService
+func NewMemberService(db *gorm.DB) MemberService {
+	return &memberService{
+		MemberDB: &memberValidator{
+			MemberDB: &memberGorm{
+				db: db,
+			},
+			hmac:       hash.NewHMAC(hmacSecretKey),
+			emailRegex: regexp.MustCompile(`^[a-z0-9._%+\-]+@[a-z0-9.\-]+\.[a-z]{2,16}$`),
+		},
+	}
+}
+
+func (ms *memberService) RoleOf(user *User, gallery *Gallery) (Role, error) {
+	role := RoleNone
+	if user != nil {
+		if user.ID == gallery.UserID {
+			return RoleOwner, nil
+		}
+		var err error
+		role, err = ms.Role(gallery.ID, user.ID)
+		if err != nil {
+			return RoleNone, err
+		}
+	}
+	if role == RoleNone && gallery.Visibility != VisibilityPrivate {
+		role = RoleViewer
+	}
+	return role, nil
+}
+
+func (ms *memberService) Authorize(user *User, gallery *Gallery, action Action) error {
+	role, err := ms.RoleOf(user, gallery)
+	if err != nil {
+		return err
+	}
+	switch {
+	case role.Can(action):
+		return nil
+	case role == RoleNone:
+		return ErrNotFound
+	default:
+		return ErrForbidden
+	}
+}
+
+func (ms *memberService) Invite(gallery *Gallery, email string, role Role, by *User) (*Invitation, error) {
+	if strings.EqualFold(strings.TrimSpace(email), by.Email) {
+		return nil, NewValidationError("email", ErrInviteSelf)
+	}
+	token, err := rand.RememberToken()
+	if err != nil {
+		return nil, err
+	}
+	invitation := Invitation{
+		GalleryID:   gallery.ID,
+		Email:       email,
+		Role:        role,
+		InvitedByID: by.ID,
+		ExpiresAt:   time.Now().Add(invitationTTL),
+		Token:       token,
+	}
+	if err := ms.CreateInvitation(&invitation); err != nil {
+		return nil, err
+	}
+	return &invitation, nil
+}
+
+func (ms *memberService) Accept(token string, user *User) (*Invitation, error) {
+	invitation, err := ms.InvitationByToken(token)
+	if err == ErrNotFound {
+		return nil, ErrInvitationInvalid
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !strings.EqualFold(invitation.Email, user.Email) {
+		return nil, ErrInvitationEmail
+	}
+
+	// never downgrade someone who already has more access
+	current, err := ms.Role(invitation.GalleryID, user.ID)
+	if err != nil {
+		return nil, err
+	}
+	if invitation.Role.rank() > current.rank() {
+		if err := ms.SetRole(invitation.GalleryID, user.ID, invitation.Role); err != nil {
+			return nil, err
+		}
+	}
+	if err := ms.DeleteInvitation(invitation.ID); err != nil {
+		return nil, err
+	}
+	return invitation, nil
+}
+
+func (mv *memberValidator) SetRole(galleryID, userID uint, role Role) error {
+	if err := validMemberRole(role); err != nil {
+		return err
+	}
+	return mv.MemberDB.SetRole(galleryID, userID, role)
+}
+
+func (mv *memberValidator) CreateInvitation(invitation *Invitation) error {
+	var verr ValidationError
+	invitation.Email = strings.ToLower(strings.TrimSpace(invitation.Email))
+	switch {
+	case invitation.Email == "":
+		verr.add(ErrEmailRequired)
+	case !mv.emailRegex.MatchString(invitation.Email):
+		verr.add(ErrEmailInvalid)
+	}
+	if err := validMemberRole(invitation.Role); err != nil {
+		verr.add(err)
+	}
+	if err := verr.err(); err != nil {
+		return err
+	}
+	invitation.TokenHash = mv.hmac.Hash(invitation.Token)
+	return mv.MemberDB.CreateInvitation(invitation)
+}
+
+// InvitationByToken hashes the token and leaves out expired invitations
+func (mv *memberValidator) InvitationByToken(token string) (*Invitation, error) {
+	if token == "" {
+		return nil, ErrNotFound
+	}
+	invitation, err := mv.MemberDB.InvitationByToken(mv.hmac.Hash(token))
+	if err != nil {
+		return nil, err
+	}
+	if time.Now().After(invitation.ExpiresAt) {
+		return nil, ErrNotFound
+	}
+	return invitation, nil
+}
+
+func validMemberRole(role Role) error {
+	for _, r := range MemberRoles {
+		if role == r {
+			return nil
+		}
+	}
+	return ErrRoleInvalid
+}
+
+func (mg *memberGorm) Role(galleryID, userID uint) (Role, error) {
+	var member GalleryMember
+	err := first(mg.db.Where("gallery_id = ? AND user_id = ?", galleryID, userID), &member)
+	if err == ErrNotFound {
+		return RoleNone, nil
+	}
+	if err != nil {
+		return RoleNone, err
+	}
+	return member.Role, nil
+}
+
+func (mg *memberGorm) Members(galleryID uint) ([]Member, error) {
+	var members []Member
+	err := mg.db.Table("gallery_members").
+		Select("gallery_members.user_id, users.name, users.email, gallery_members.role").
+		Joins("JOIN users ON users.id = gallery_members.user_id AND users.deleted_at IS NULL").
+		Where("gallery_members.gallery_id = ?", galleryID).
+		Order("users.email").
+		Scan(&members).Error
+	if err != nil {
+		return nil, err
+	}
+	return members, nil
+}
+
+func (mg *memberGorm) SetRole(galleryID, userID uint, role Role) error {
+	member := GalleryMember{GalleryID: galleryID, UserID: userID}
+	return mg.db.Where(member).Assign(GalleryMember{Role: role}).FirstOrCreate(&member).Error
+}
+
+func (mg *memberGorm) RemoveMember(galleryID, userID uint) error {
+	return mg.db.Where("gallery_id = ? AND user_id = ?", galleryID, userID).Delete(&GalleryMember{}).Error
+}
+
+func (mg *memberGorm) SharedWith(userID uint) ([]Gallery, error) {
+	var galleries []Gallery
+	err := mg.db.
+		Joins("JOIN gallery_members ON gallery_members.gallery_id = galleries.id").
+		Where("gallery_members.user_id = ?", userID).
+		Order("galleries.created_at desc").
+		Find(&galleries).Error
+	if err != nil {
+		return nil, err
+	}
+	return galleries, nil
+}
+
+func (mg *memberGorm) Invitations(galleryID uint) ([]Invitation, error) {
+	var invitations []Invitation
+	err := mg.db.Where("gallery_id = ? AND expires_at > ?", galleryID, time.Now()).Order("created_at").Find(&invitations).Error
+	if err != nil {
+		return nil, err
+	}
+	return invitations, nil
+}
+
+func (mg *memberGorm) InvitationByID(id uint) (*Invitation, error) {
+	var invitation Invitation
+	if err := first(mg.db.Where("id = ?", id), &invitation); err != nil {
+		return nil, err
+	}
+	return &invitation, nil
+}
+
+func (mg *memberGorm) InvitationByToken(tokenHash string) (*Invitation, error) {
+	var invitation Invitation
+	if err := first(mg.db.Where("token_hash = ?", tokenHash), &invitation); err != nil {
+		return nil, err
+	}
+	return &invitation, nil
+}
+
+func (mg *memberGorm) CreateInvitation(invitation *Invitation) error {
+	return mg.db.Create(invitation).Error
+}
+
+func (mg *memberGorm) DeleteInvitation(id uint) error {
+	invitation := Invitation{Model: gorm.Model{ID: id}}
+	return mg.db.Delete(&invitation).Error
+}
@@ -0,0 +1,214 @@
+package model
+
+import (
+	"strings"
+
+	"github.com/jinzhu/gorm"
+)
+
+// searchLimit caps the galleries and the images returned by one search
+const searchLimit = 50
+
+// SearchResults are the matches of one query, best first
+type SearchResults struct {
+	Query     string
+	Galleries []Gallery
+	Images    []Image
+}
+
+// SearchService finds galleries and images by text and tags
+type SearchService interface {
+	// Search matches query against gallery titles and descriptions, image
+	// titles, captions and alt text, and tags. Private galleries and their
+	// images only match for their owner and members; viewerID 0 is a visitor.
+	Search(query string, viewerID uint) (*SearchResults, error)
+}
+
+// NewSearchService picks full-text search on Postgres and a LIKE based
+// fallback on other databases such as SQLite in development
+func NewSearchService(db *gorm.DB) SearchService {
+	if db.Dialect().GetName() == "postgres" {
+		return &searchPostgres{db: db}
+	}
+	return &searchLike{db: db}
+}
+
+// visibleGalleries limits a query on galleries to the ones viewerID may see;
+// it is the SQL form of MemberService.Authorize with ActionView
+func visibleGalleries(db *gorm.DB, viewerID uint) *gorm.DB {
+	return db.Where(`galleries.visibility = ? OR galleries.user_id = ?
+		OR EXISTS (SELECT 1 FROM gallery_members WHERE gallery_members.gallery_id = galleries.id AND gallery_members.user_id = ?)`,
+		VisibilityPublic, viewerID, viewerID)
+}
+
+// visibleImages limits a query on images to the ones in galleries viewerID may see
+func visibleImages(db *gorm.DB, viewerID uint) *gorm.DB {
+	db = db.Joins("JOIN galleries ON galleries.id = images.gallery_id AND galleries.deleted_at IS NULL")
+	return visibleGalleries(db, viewerID)
+}
+
+type searchPostgres struct {
+	db *gorm.DB
+}
+
+// searchSchema keeps a search_vector column on galleries and images, with a
+// GIN index, so searches do not build every document on the fly. Triggers
+// refresh it when the text or the tags of a row change; tags are folded in
+// from the join tables, which rules out a generated column.
+var searchSchema = []string{
+	`CREATE OR REPLACE FUNCTION gallery_document(integer, text, text) RETURNS tsvector AS $$
+		SELECT to_tsvector('simple', coalesce($2, '') || ' ' || coalesce($3, '') || ' ' ||
+			coalesce((SELECT string_agg(tags.name, ' ') FROM tags JOIN gallery_tags ON gallery_tags.tag_id = tags.id WHERE gallery_tags.gallery_id = $1), ''))
+	$$ LANGUAGE sql STABLE`,
+	`CREATE OR REPLACE FUNCTION image_document(integer, text, text, text) RETURNS tsvector AS $$
+		SELECT to_tsvector('simple', coalesce($2, '') || ' ' || coalesce($3, '') || ' ' || coalesce($4, '') || ' ' ||
+			coalesce((SELECT string_agg(tags.name, ' ') FROM tags JOIN image_tags ON image_tags.tag_id = tags.id WHERE image_tags.image_id = $1), ''))
+	$$ LANGUAGE sql STABLE`,
+
+	`ALTER TABLE galleries ADD COLUMN IF NOT EXISTS search_vector tsvector`,
+	`ALTER TABLE images ADD COLUMN IF NOT EXISTS search_vector tsvector`,
+	`CREATE INDEX IF NOT EXISTS idx_galleries_search_vector ON galleries USING GIN (search_vector)`,
+	`CREATE INDEX IF NOT EXISTS idx_images_search_vector ON images USING GIN (search_vector)`,
+
+	`CREATE OR REPLACE FUNCTION galleries_search_vector() RETURNS trigger AS $$
+	BEGIN
+		NEW.search_vector := gallery_document(NEW.id, NEW.title, NEW.description);
+		RETURN NEW;
+	END
+	$$ LANGUAGE plpgsql`,
+	`DROP TRIGGER IF EXISTS galleries_search_vector ON galleries`,
+	`CREATE TRIGGER galleries_search_vector BEFORE INSERT OR UPDATE OF title, description ON galleries
+		FOR EACH ROW EXECUTE FUNCTION galleries_search_vector()`,
+	`CREATE OR REPLACE FUNCTION images_search_vector() RETURNS trigger AS $$
+	BEGIN
+		NEW.search_vector := image_document(NEW.id, NEW.title, NEW.caption, NEW.alt);
+		RETURN NEW;
+	END
+	$$ LANGUAGE plpgsql`,
+	`DROP TRIGGER IF EXISTS images_search_vector ON images`,
+	`CREATE TRIGGER images_search_vector BEFORE INSERT OR UPDATE OF title, caption, alt ON images
+		FOR EACH ROW EXECUTE FUNCTION images_search_vector()`,
+
+	`CREATE OR REPLACE FUNCTION gallery_tags_search_vector() RETURNS trigger AS $$
+	DECLARE
+		changed integer;
+	BEGIN
+		IF TG_OP = 'DELETE' THEN
+			changed := OLD.gallery_id;
+		ELSE
+			changed := NEW.gallery_id;
+		END IF;
+		UPDATE galleries SET search_vector = gallery_document(id, title, description) WHERE id = changed;
+		RETURN NULL;
+	END
+	$$ LANGUAGE plpgsql`,
+	`DROP TRIGGER IF EXISTS gallery_tags_search_vector ON gallery_tags`,
+	`CREATE TRIGGER gallery_tags_search_vector AFTER INSERT OR DELETE ON gallery_tags
+		FOR EACH ROW EXECUTE FUNCTION gallery_tags_search_vector()`,
+	`CREATE OR REPLACE FUNCTION image_tags_search_vector() RETURNS trigger AS $$
+	DECLARE
+		changed integer;
+	BEGIN
+		IF TG_OP = 'DELETE' THEN
+			changed := OLD.image_id;
+		ELSE
+			changed := NEW.image_id;
+		END IF;
+		UPDATE images SET search_vector = image_document(id, title, caption, alt) WHERE id = changed;
+		RETURN NULL;
+	END
+	$$ LANGUAGE plpgsql`,
+	`DROP TRIGGER IF EXISTS image_tags_search_vector ON image_tags`,
+	`CREATE TRIGGER image_tags_search_vector AFTER INSERT OR DELETE ON image_tags
+		FOR EACH ROW EXECUTE FUNCTION image_tags_search_vector()`,
+}
+
+// migrateSearch sets up searchSchema on Postgres, filling the columns of the
+// rows there were before it
+func migrateSearch(db *gorm.DB) error {
+	if db.Dialect().GetName() != "postgres" {
+		return nil
+	}
+	fill := !db.Dialect().HasColumn("galleries", "search_vector")
+	return db.Transaction(func(tx *gorm.DB) error {
+		for _, stmt := range searchSchema {
+			if err := tx.Exec(stmt).Error; err != nil {
+				return err
+			}
+		}
+		if !fill {
+			return nil
+		}
+		if err := tx.Exec("UPDATE galleries SET search_vector = gallery_document(id, title, description)").Error; err != nil {
+			return err
+		}
+		return tx.Exec("UPDATE images SET search_vector = image_document(id, title, caption, alt)").Error
+	})
+}
+
+func (sp *searchPostgres) Search(query string, viewerID uint) (*SearchResults, error) {
+	results := SearchResults{Query: strings.TrimSpace(query)}
+	if results.Query == "" {
+		return &results, nil
+	}
+
+	// websearch_to_tsquery accepts anything a user types, including quotes and -exclusions
+	tsquery := "websearch_to_tsquery('simple', ?)"
+	err := visibleGalleries(sp.db, viewerID).
+		Where("galleries.search_vector @@ "+tsquery, results.Query).
+		Order(gorm.Expr("ts_rank(galleries.search_vector, "+tsquery+") DESC, galleries.id DESC", results.Query)).
+		Limit(searchLimit).
+		Find(&results.Galleries).Error
+	if err != nil {
+		return nil, err
+	}
+
+	err = visibleImages(sp.db, viewerID).
+		Where("images.search_vector @@ "+tsquery, results.Query).
+		Order(gorm.Expr("ts_rank(images.search_vector, "+tsquery+") DESC, images.id DESC", results.Query)).
+		Limit(searchLimit).
+		Select("images.*").
+		Find(&results.Images).Error
+	if err != nil {
+		return nil, err
+	}
+	return &results, nil
+}
+
+type searchLike struct {
+	db *gorm.DB
+}
+
+// likeEscaper makes user input literal inside a LIKE pattern
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+// Search requires every word of the query to appear in the text or match a tag
+func (sl *searchLike) Search(query string, viewerID uint) (*SearchResults, error) {
+	results := SearchResults{Query: strings.TrimSpace(query)}
+	terms := strings.Fields(strings.ToLower(results.Query))
+	if len(terms) == 0 {
+		return &results, nil
+	}
+
+	galleries := visibleGalleries(sl.db, viewerID)
+	images := visibleImages(sl.db, viewerID)
+	for _, term := range terms {
+		pattern := "%" + likeEscaper.Replace(term) + "%"
+		galleries = galleries.Where(`lower(galleries.title) LIKE ? ESCAPE '\' OR lower(galleries.description) LIKE ? ESCAPE '\'
+			OR EXISTS (SELECT 1 FROM tags JOIN gallery_tags ON gallery_tags.tag_id = tags.id WHERE gallery_tags.gallery_id = galleries.id AND tags.name = ?)`,
+			pattern, pattern, term)
+		images = images.Where(`lower(images.title) LIKE ? ESCAPE '\' OR lower(images.caption) LIKE ? ESCAPE '\' OR lower(images.alt) LIKE ? ESCAPE '\'
+			OR EXISTS (SELECT 1 FROM tags JOIN image_tags ON image_tags.tag_id = tags.id WHERE image_tags.image_id = images.id AND tags.name = ?)`,
+			pattern, pattern, pattern, term)
+	}
+
+	err := galleries.Order("galleries.updated_at DESC").Limit(searchLimit).Find(&results.Galleries).Error
+	if err != nil {
+		return nil, err
+	}
+	err = images.Order("images.updated_at DESC").Limit(searchLimit).Select("images.*").Find(&results.Images).Error
+	if err != nil {
+		return nil, err
+	}
+	return &results, nil
+}
@@ -1,27 +1,179 @@
 package model
 
-import "github.com/jinzhu/gorm"
+import (
+	"context"
+	"database/sql"
+	"log/slog"
+
+	"github.com/jhampac/picha/cache"
+	"github.com/jhampac/picha/jobs"
+	"github.com/jhampac/picha/storage"
+	"github.com/jinzhu/gorm"
+)
 
 // Services to DB wrappers
 type Services struct {
-	Gallery GalleryService
-	User    UserService
-	db      *gorm.DB
+	Collection CollectionService
+	Gallery    GalleryService
+	Image      ImageService
+	Import     ImportService
+	Jobs       jobs.Queue
+	Member     MemberService
+	Search     SearchService
+	Share      ShareLinkService
+	Tag        TagService
+	Trash      TrashService
+	Upload     UploadService
+	User       UserService
+	db         *gorm.DB
+	cache      cache.Cache
 }
 
-// NewServices instatiates all the available services with one DB connection
-func NewServices(connectionInfo string) (*Services, error) {
-	db, err := gorm.Open("postgres", connectionInfo)
-	if err != nil {
-		return nil, err
+// ServicesConfig is a functional option applied by NewServices, in order
+type ServicesConfig func(*Services) error
+
+// WithGorm opens the DB connection; it must be the first config passed to NewServices
+func WithGorm(dialect, connectionInfo string) ServicesConfig {
+	return func(s *Services) error {
+		db, err := gorm.Open(dialect, connectionInfo)
+		if err != nil {
+			return err
+		}
+		s.db = db
+		return nil
+	}
+}
+
+// WithLogger routes gorm output through the structured logger. Statements are
+// logged at sqlLevel with their bind parameters redacted; pass nil to only log errors.
+func WithLogger(logger *slog.Logger, sqlLevel *slog.Level) ServicesConfig {
+	return func(s *Services) error {
+		gl := &gormLogger{log: logger, level: slog.LevelDebug}
+		if sqlLevel != nil {
+			gl.level = *sqlLevel
+		}
+		s.db.SetLogger(gl)
+		s.db.LogMode(sqlLevel != nil)
+		return nil
+	}
+}
+
+// WithJobs attaches the background job queue; it must come before the
+// services that enqueue jobs, such as WithImage
+func WithJobs() ServicesConfig {
+	return func(s *Services) error {
+		s.Jobs = jobs.NewDB(s.db)
+		return nil
+	}
+}
+
+// WithCache keeps hot reads, the signed in user and galleries by ID, in c;
+// it must come before WithUser, WithGallery and WithTrash
+func WithCache(c cache.Cache) ServicesConfig {
+	return func(s *Services) error {
+		s.cache = c
+		return nil
+	}
+}
+
+// WithUser attaches the user service
+func WithUser() ServicesConfig {
+	return func(s *Services) error {
+		s.User = NewUserService(s.db, s.cache)
+		return nil
+	}
+}
+
+// WithGallery attaches the gallery service
+func WithGallery() ServicesConfig {
+	return func(s *Services) error {
+		s.Gallery = NewGalleryService(s.db, s.cache)
+		return nil
+	}
+}
+
+// WithImage attaches the image service, keeping files in store and resizing
+// images through the job queue
+func WithImage(store storage.Store) ServicesConfig {
+	return func(s *Services) error {
+		s.Image = NewImageService(s.db, store, s.Jobs)
+		return nil
+	}
+}
+
+// WithUpload attaches the resumable upload service, keeping partial files in chunks
+func WithUpload(chunks *storage.Chunks) ServicesConfig {
+	return func(s *Services) error {
+		s.Upload = NewUploadService(s.db, chunks)
+		return nil
+	}
+}
+
+// WithCollection attaches the collection service
+func WithCollection() ServicesConfig {
+	return func(s *Services) error {
+		s.Collection = NewCollectionService(s.db)
+		return nil
 	}
-	db.LogMode(true)
+}
+
+// WithImport attaches the import job service
+func WithImport() ServicesConfig {
+	return func(s *Services) error {
+		s.Import = NewImportService(s.db)
+		return nil
+	}
+}
+
+// WithMember attaches the membership and authorization service
+func WithMember() ServicesConfig {
+	return func(s *Services) error {
+		s.Member = NewMemberService(s.db)
+		return nil
+	}
+}
+
+// WithShareLink attaches the password protected share link service
+func WithShareLink() ServicesConfig {
+	return func(s *Services) error {
+		s.Share = NewShareLinkService(s.db)
+		return nil
+	}
+}
+
+// WithTag attaches the tag service
+func WithTag() ServicesConfig {
+	return func(s *Services) error {
+		s.Tag = NewTagService(s.db)
+		return nil
+	}
+}
+
+// WithTrash attaches the trash service; it must come after WithImage
+func WithTrash() ServicesConfig {
+	return func(s *Services) error {
+		s.Trash = NewTrashService(s.db, s.Image, s.cache)
+		return nil
+	}
+}
 
-	return &Services{
-		User:    NewUserService(db),
-		Gallery: NewGalleryService(db),
-		db:      db,
-	}, nil
+// WithSearch attaches the search service for the connected database
+func WithSearch() ServicesConfig {
+	return func(s *Services) error {
+		s.Search = NewSearchService(s.db)
+		return nil
+	}
+}
+
+// NewServices instatiates all the available services with one DB connection
+func NewServices(cfgs ...ServicesConfig) (*Services, error) {
+	var s Services
+	for _, cfg := range cfgs {
+		if err := cfg(&s); err != nil {
+			return nil, err
+		}
+	}
+	return &s, nil
 }
 
 // Close the DB connection
@@ -29,14 +181,28 @@ func (s *Services) Close() error {
 	return s.db.Close()
 }
 
+// SQL exposes the underlying connection pool, e.g. for pool stats
+func (s *Services) SQL() *sql.DB {
+	return s.db.DB()
+}
+
+// Ping verifies the DB connection is still alive
+func (s *Services) Ping(ctx context.Context) error {
+	return s.db.DB().PingContext(ctx)
+}
+
 // AutoMigrate will attempt to automatically migrate all the tables
 func (s *Services) AutoMigrate() error {
-	return s.db.AutoMigrate(&User{}, &Gallery{}).Error
+	err := s.db.AutoMigrate(&User{}, &Gallery{}, &Image{}, &Blob{}, &Tag{}, &Collection{}, &CollectionImage{}, &GalleryMember{}, &Invitation{}, &ShareLink{}, &ImportJob{}, &ImportItem{}, &Upload{}, &jobs.Job{}).Error
+	if err != nil {
+		return err
+	}
+	return migrateSearch(s.db)
 }
 
 // DestructiveReset drops all tables and rebuilds them
 func (s *Services) DestructiveReset() error {
-	err := s.db.DropTableIfExists(&User{}, &Gallery{}).Error
+	err := s.db.DropTableIfExists(&User{}, &Gallery{}, &Image{}, &Blob{}, &Tag{}, &Collection{}, &CollectionImage{}, &GalleryMember{}, &Invitation{}, &ShareLink{}, &ImportJob{}, &ImportItem{}, &Upload{}, &jobs.Job{}, "gallery_tags", "image_tags").Error
 	if err != nil {
 		return err
 	}
@@ -0,0 +1,175 @@
+package model
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"io"
+	"log/slog"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/jhampac/picha/cache"
+	"github.com/jhampac/picha/jobs"
+	"github.com/jhampac/picha/storage"
+)
+
+// testEnv is a fresh SQLite database with the services, keeping files in a
+// temporary directory and jobs in memory
+type testEnv struct {
+	*Services
+	store  *storage.Disk
+	chunks *storage.Chunks
+	queue  *jobs.Memory
+	cache  *cache.LRU
+}
+
+func newTestEnv(t *testing.T) *testEnv {
+	t.Helper()
+	dir := t.TempDir()
+	store, err := storage.NewDisk(filepath.Join(dir, "images"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	chunks, err := storage.NewChunks(filepath.Join(dir, "uploads"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	env := &testEnv{
+		store:  store,
+		chunks: chunks,
+		queue:  jobs.NewMemory(),
+		cache:  cache.NewLRU(100, time.Minute),
+	}
+	env.Services, err = NewServices(
+		WithGorm("sqlite3", filepath.Join(dir, "test.db")),
+		WithLogger(slog.New(slog.NewTextHandler(io.Discard, nil)), nil),
+		func(s *Services) error {
+			s.Jobs = env.queue
+			return nil
+		},
+		WithCache(env.cache),
+		WithUser(),
+		WithGallery(),
+		WithImage(store),
+		WithTrash(),
+		WithUpload(chunks),
+		WithShareLink(),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { env.Close() })
+	if err := env.AutoMigrate(); err != nil {
+		t.Fatal(err)
+	}
+	return env
+}
+
+func (env *testEnv) images() *imageService {
+	return env.Image.(*imageService)
+}
+
+func (env *testEnv) user(t *testing.T, email string) *User {
+	t.Helper()
+	user := User{Name: "Test", Email: email, Password: "password123"}
+	if err := env.User.Create(&user); err != nil {
+		t.Fatal(err)
+	}
+	return &user
+}
+
+func (env *testEnv) gallery(t *testing.T, user *User, title string) *Gallery {
+	t.Helper()
+	gallery := Gallery{UserID: user.ID, Title: title, Visibility: VisibilityPublic}
+	if err := env.Gallery.Create(&gallery); err != nil {
+		t.Fatal(err)
+	}
+	return &gallery
+}
+
+func (env *testEnv) upload(t *testing.T, gallery *Gallery, b []byte) *Image {
+	t.Helper()
+	image, err := env.Image.Upload(gallery.ID, "photo.jpg", bytes.NewReader(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return image
+}
+
+// makeVariants runs the queued variant jobs
+func (env *testEnv) makeVariants(t *testing.T) {
+	t.Helper()
+	ctx := context.Background()
+	for {
+		job, err := env.queue.Claim(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if job == nil {
+			return
+		}
+		if job.Kind != JobImageVariants {
+			t.Fatalf("unexpected job %q", job.Kind)
+		}
+		if err := env.Image.MakeVariants(ctx, job); err != nil {
+			t.Fatal(err)
+		}
+		if err := env.queue.Complete(ctx, job); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func (env *testEnv) blob(t *testing.T, key string) *Blob {
+	t.Helper()
+	blob, err := env.images().blobs.byKey(key)
+	if err != nil {
+		t.Fatalf("blob %s: %v", key, err)
+	}
+	return blob
+}
+
+func (env *testEnv) blobCount(t *testing.T) int {
+	t.Helper()
+	var n int
+	if err := env.db.Model(&Blob{}).Count(&n).Error; err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+// storageUsed reads the counter from the database, past the user cache
+func (env *testEnv) storageUsed(t *testing.T, user *User) int64 {
+	t.Helper()
+	found, err := env.User.ByID(user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return found.StorageUsed
+}
+
+func (env *testEnv) stored(key string) bool {
+	_, err := env.store.Size(key)
+	return err == nil
+}
+
+// jpegBytes encodes a width x height JPEG; images with different shades
+// have different content
+func jpegBytes(t *testing.T, width, height int, shade uint8) []byte {
+	t.Helper()
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for x := 0; x < width; x++ {
+		for y := 0; y < height; y++ {
+			img.Set(x, y, color.RGBA{R: shade, G: uint8(x), B: uint8(y), A: 255})
+		}
+	}
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, nil); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
@@ -0,0 +1,340 @@
+package model
+
+import (
+	"crypto/hmac"
+	"fmt"
+	"time"
+
+	"github.com/jhampac/picha/hash"
+	"github.com/jhampac/picha/rand"
+	"github.com/jinzhu/gorm"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	// ErrShareExpiryRequired is returned when a share link is created without an expiry date
+	ErrShareExpiryRequired modelError = "model: an expiry date is required"
+
+	// ErrShareExpiryPast is returned when a share link would already be expired
+	ErrShareExpiryPast modelError = "model: the expiry date must be in the future"
+
+	// ErrMaxViewsInvalid is returned for a negative view cap
+	ErrMaxViewsInvalid modelError = "model: the view limit cannot be negative"
+
+	// ErrShareLinkUnavailable is returned for share links that expired or used up their views
+	ErrShareLinkUnavailable modelError = "model: this link has expired"
+
+	// ErrShareLinkLocked is returned while a share link is locked after maxUnlockAttempts wrong passwords
+	ErrShareLinkLocked modelError = "model: too many wrong passwords, try again in 15 minutes"
+)
+
+const (
+	// maxUnlockAttempts wrong passwords in a row lock a share link for unlockLockout
+	maxUnlockAttempts = 5
+	unlockLockout     = 15 * time.Minute
+)
+
+// sharePwPepper is added to share link passwords before hashing, like userPwPepper
+const sharePwPepper = "secret-dev-share-pepper"
+
+// shareTokenBytes is the size of the random token in share link URLs
+const shareTokenBytes = 18
+
+// ShareLink gives anyone with its URL and password read access to a gallery
+// until it expires, is revoked or runs out of views
+type ShareLink struct {
+	gorm.Model
+	GalleryID   uint   `gorm:"not_null;index"`
+	CreatedByID uint   `gorm:"not_null"`
+	Token       string `gorm:"not_null;unique_index"`
+
+	Password     string `gorm:"-"`
+	PasswordHash string `gorm:"not_null"`
+
+	ExpiresAt time.Time
+
+	// MaxViews caps how often the gallery can be opened through the link; 0 is unlimited
+	MaxViews int
+	Views    int
+
+	// FailedUnlocks counts the passwords tried since the last right one or
+	// lockout; the link refuses passwords until LockedUntil
+	FailedUnlocks int `gorm:"not_null;default:0"`
+	LockedUntil   *time.Time
+}
+
+// Expired reports whether the link's date has passed
+func (l *ShareLink) Expired() bool {
+	return !time.Now().Before(l.ExpiresAt)
+}
+
+// UsedUp reports whether the gallery was opened MaxViews times; the images of
+// the last view keep loading until the link expires
+func (l *ShareLink) UsedUp() bool {
+	return l.MaxViews > 0 && l.Views >= l.MaxViews
+}
+
+// ShareLinkService provides password protected share links to galleries
+type ShareLinkService interface {
+	ShareLinkDB
+
+	// Unlock checks password against the link with token and returns it with
+	// the value of the cookie that keeps it unlocked. The link refuses every
+	// password for a while after too many wrong ones, returning
+	// ErrShareLinkLocked; visitors who unlocked it already are not affected.
+	Unlock(token, password string) (*ShareLink, string, error)
+
+	// Unlocked reports whether cookie was issued by Unlock for link
+	Unlocked(link *ShareLink, cookie string) bool
+}
+
+// ShareLinkDB is the DB connection for share links
+type ShareLinkDB interface {
+	ByID(id uint) (*ShareLink, error)
+
+	// ByToken returns ErrShareLinkUnavailable once the link expired
+	ByToken(token string) (*ShareLink, error)
+	ByGalleryID(galleryID uint) ([]ShareLink, error)
+	Create(link *ShareLink) error
+	Delete(id uint) error
+
+	// AddView counts a visit, failing with ErrShareLinkUnavailable once the views are used up
+	AddView(link *ShareLink) error
+
+	// AttemptUnlock counts a password attempt before it is checked, so
+	// concurrent guesses count too, failing with ErrShareLinkLocked while the
+	// link is locked. The attempt that reaches maxUnlockAttempts locks it.
+	AttemptUnlock(link *ShareLink) error
+
+	// ResetUnlocks forgets the attempts once the right password was entered
+	ResetUnlocks(link *ShareLink) error
+}
+
+type shareLinkService struct {
+	ShareLinkDB
+	hmac hash.HMAC
+}
+
+type shareLinkValidator struct {
+	ShareLinkDB
+}
+
+type shareLinkGorm struct {
+	db *gorm.DB
+}
+
+// NewShareLinkService instantiates a new ShareLinkService
+func NewShareLinkService(db *gorm.DB) ShareLinkService {
+	return &shareLinkService{
+		ShareLinkDB: &shareLinkValidator{
+			ShareLinkDB: &shareLinkGorm{
+				db: db,
+			},
+		},
+		hmac: hash.NewHMAC(hmacSecretKey),
+	}
+}
+
+func (ss *shareLinkService) Unlock(token, password string) (*ShareLink, string, error) {
+	link, err := ss.ByToken(token)
+	if err != nil {
+		return nil, "", err
+	}
+	if link.UsedUp() {
+		return nil, "", ErrShareLinkUnavailable
+	}
+	if err := ss.AttemptUnlock(link); err != nil {
+		return nil, "", err
+	}
+	err = bcrypt.CompareHashAndPassword([]byte(link.PasswordHash), []byte(password+sharePwPepper))
+	switch err {
+	case nil:
+		if err := ss.ResetUnlocks(link); err != nil {
+			return nil, "", err
+		}
+		return link, ss.cookie(link), nil
+	case bcrypt.ErrMismatchedHashAndPassword:
+		return nil, "", NewValidationError("password", ErrPasswordIncorrect)
+	default:
+		return nil, "", err
+	}
+}
+
+func (ss *shareLinkService) Unlocked(link *ShareLink, cookie string) bool {
+	return cookie != "" && hmac.Equal([]byte(cookie), []byte(ss.cookie(link)))
+}
+
+// cookie signs the link with its password hash, so changing either locks visitors out again
+func (ss *shareLinkService) cookie(link *ShareLink) string {
+	return ss.hmac.Hash(fmt.Sprintf("share:%d:%s", link.ID, link.PasswordHash))
+}
+
+func (sv *shareLinkValidator) ByToken(token string) (*ShareLink, error) {
+	if token == "" {
+		return nil, ErrNotFound
+	}
+	link, err := sv.ShareLinkDB.ByToken(token)
+	if err != nil {
+		return nil, err
+	}
+	if link.Expired() {
+		return nil, ErrShareLinkUnavailable
+	}
+	return link, nil
+}
+
+func (sv *shareLinkValidator) Create(link *ShareLink) error {
+	err := runShareLinkValFns(link,
+		sv.passwordRequired,
+		sv.passwordMinLength,
+		sv.expiryInFuture,
+		sv.maxViewsValid)
+	if err != nil {
+		return err
+	}
+	// hashing is slow, so only valid links get that far
+	err = runShareLinkValFns(link,
+		sv.bcryptPassword,
+		sv.setToken)
+	if err != nil {
+		return err
+	}
+	return sv.ShareLinkDB.Create(link)
+}
+
+func (sg *shareLinkGorm) ByID(id uint) (*ShareLink, error) {
+	var link ShareLink
+	if err := first(sg.db.Where("id = ?", id), &link); err != nil {
+		return nil, err
+	}
+	return &link, nil
+}
+
+func (sg *shareLinkGorm) ByToken(token string) (*ShareLink, error) {
+	var link ShareLink
+	if err := first(sg.db.Where("token = ?", token), &link); err != nil {
+		return nil, err
+	}
+	return &link, nil
+}
+
+func (sg *shareLinkGorm) ByGalleryID(galleryID uint) ([]ShareLink, error) {
+	var links []ShareLink
+	err := sg.db.Where("gallery_id = ?", galleryID).Order("created_at desc").Find(&links).Error
+	if err != nil {
+		return nil, err
+	}
+	return links, nil
+}
+
+func (sg *shareLinkGorm) Create(link *ShareLink) error {
+	return sg.db.Create(link).Error
+}
+
+func (sg *shareLinkGorm) Delete(id uint) error {
+	link := ShareLink{Model: gorm.Model{ID: id}}
+	return sg.db.Delete(&link).Error
+}
+
+// AddView increments in one statement so concurrent visits cannot go over the cap
+func (sg *shareLinkGorm) AddView(link *ShareLink) error {
+	db := sg.db.Model(&ShareLink{}).
+		Where("id = ? AND (max_views = 0 OR views < max_views)", link.ID).
+		UpdateColumn("views", gorm.Expr("views + 1"))
+	if db.Error != nil {
+		return db.Error
+	}
+	if db.RowsAffected == 0 {
+		return ErrShareLinkUnavailable
+	}
+	link.Views++
+	return nil
+}
+
+func (sg *shareLinkGorm) AttemptUnlock(link *ShareLink) error {
+	now := time.Now()
+	lock := "failed_unlocks + 1 >= ?"
+	db := sg.db.Model(&ShareLink{}).
+		Where("id = ? AND (locked_until IS NULL OR locked_until <= ?)", link.ID, now).
+		UpdateColumns(map[string]interface{}{
+			"failed_unlocks": gorm.Expr("CASE WHEN "+lock+" THEN 0 ELSE failed_unlocks + 1 END", maxUnlockAttempts),
+			"locked_until":   gorm.Expr("CASE WHEN "+lock+" THEN ? ELSE locked_until END", maxUnlockAttempts, now.Add(unlockLockout)),
+		})
+	if db.Error != nil {
+		return db.Error
+	}
+	if db.RowsAffected == 0 {
+		return ErrShareLinkLocked
+	}
+	return nil
+}
+
+func (sg *shareLinkGorm) ResetUnlocks(link *ShareLink) error {
+	return sg.db.Model(&ShareLink{}).Where("id = ?", link.ID).
+		UpdateColumns(map[string]interface{}{"failed_unlocks": 0, "locked_until": nil}).Error
+}
+
+type shareLinkValFn func(*ShareLink) error
+
+func runShareLinkValFns(link *ShareLink, fns ...shareLinkValFn) error {
+	var verr ValidationError
+	for _, fn := range fns {
+		if err := fn(link); err != nil && !verr.add(err) {
+			return err
+		}
+	}
+	return verr.err()
+}
+
+func (sv *shareLinkValidator) passwordRequired(link *ShareLink) error {
+	if link.Password == "" {
+		return ErrPasswordRequired
+	}
+	return nil
+}
+
+func (sv *shareLinkValidator) passwordMinLength(link *ShareLink) error {
+	if link.Password != "" && len(link.Password) < 8 {
+		return ErrPasswordTooShort
+	}
+	return nil
+}
+
+func (sv *shareLinkValidator) bcryptPassword(link *ShareLink) error {
+	if len(link.Password) < 8 {
+		return nil
+	}
+	hashedBytes, err := bcrypt.GenerateFromPassword([]byte(link.Password+sharePwPepper), bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+	link.PasswordHash = string(hashedBytes)
+	link.Password = ""
+	return nil
+}
+
+func (sv *shareLinkValidator) expiryInFuture(link *ShareLink) error {
+	if link.ExpiresAt.IsZero() {
+		return ErrShareExpiryRequired
+	}
+	if !link.ExpiresAt.After(time.Now()) {
+		return ErrShareExpiryPast
+	}
+	return nil
+}
+
+func (sv *shareLinkValidator) maxViewsValid(link *ShareLink) error {
+	if link.MaxViews < 0 {
+		return ErrMaxViewsInvalid
+	}
+	return nil
+}
+
+func (sv *shareLinkValidator) setToken(link *ShareLink) error {
+	token, err := rand.String(shareTokenBytes)
+	if err != nil {
+		return err
+	}
+	link.Token = token
+	return nil
+}
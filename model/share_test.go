@@ -0,0 +1,141 @@
+package model
+
+import (
+	"testing"
+	"time"
+)
+
+func (env *testEnv) shareLink(t *testing.T, gallery *Gallery, maxViews int) *ShareLink {
+	t.Helper()
+	link := ShareLink{
+		GalleryID:   gallery.ID,
+		CreatedByID: gallery.UserID,
+		Password:    "open sesame",
+		ExpiresAt:   time.Now().Add(24 * time.Hour),
+		MaxViews:    maxViews,
+	}
+	if err := env.Share.Create(&link); err != nil {
+		t.Fatal(err)
+	}
+	return &link
+}
+
+func TestShareLinkValidation(t *testing.T) {
+	env := newTestEnv(t)
+	beach := env.gallery(t, env.user(t, "alice@example.com"), "Beach")
+	tomorrow := time.Now().Add(24 * time.Hour)
+
+	tests := []struct {
+		name string
+		link ShareLink
+		want modelError
+	}{
+		{"no password", ShareLink{ExpiresAt: tomorrow}, ErrPasswordRequired},
+		{"short password", ShareLink{Password: "short", ExpiresAt: tomorrow}, ErrPasswordTooShort},
+		{"no expiry", ShareLink{Password: "open sesame"}, ErrShareExpiryRequired},
+		{"expiry past", ShareLink{Password: "open sesame", ExpiresAt: time.Now().Add(-time.Hour)}, ErrShareExpiryPast},
+		{"negative views", ShareLink{Password: "open sesame", ExpiresAt: tomorrow, MaxViews: -1}, ErrMaxViewsInvalid},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.link.GalleryID = beach.ID
+			err := env.Share.Create(&tt.link)
+			verr, ok := err.(ValidationError)
+			if !ok || len(verr.Errors) != 1 || verr.Errors[0].Code != tt.want {
+				t.Fatalf("Create = %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestShareLinkUnlock(t *testing.T) {
+	env := newTestEnv(t)
+	link := env.shareLink(t, env.gallery(t, env.user(t, "alice@example.com"), "Beach"), 0)
+	if link.Password != "" || link.PasswordHash == "" || link.Token == "" {
+		t.Fatalf("created link %+v keeps its password or has no token", link)
+	}
+
+	if _, _, err := env.Share.Unlock(link.Token, "wrong password"); err == nil {
+		t.Fatal("unlocked with the wrong password")
+	}
+	unlocked, cookie, err := env.Share.Unlock(link.Token, "open sesame")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !env.Share.Unlocked(unlocked, cookie) {
+		t.Fatal("the cookie from Unlock does not unlock the link")
+	}
+	for _, forged := range []string{"", cookie[1:], "x" + cookie[1:]} {
+		if env.Share.Unlocked(unlocked, forged) {
+			t.Errorf("cookie %q unlocks the link", forged)
+		}
+	}
+
+	// another link needs its own password
+	other := env.shareLink(t, env.gallery(t, env.user(t, "bob@example.com"), "Holiday"), 0)
+	if env.Share.Unlocked(other, cookie) {
+		t.Fatal("the cookie of one link unlocks another")
+	}
+	if _, _, err := env.Share.Unlock("unknown", "open sesame"); err != ErrNotFound {
+		t.Fatalf("Unlock of an unknown token = %v, want ErrNotFound", err)
+	}
+}
+
+func TestShareLinkLocksAfterWrongPasswords(t *testing.T) {
+	env := newTestEnv(t)
+	link := env.shareLink(t, env.gallery(t, env.user(t, "alice@example.com"), "Beach"), 0)
+
+	for i := 0; i < maxUnlockAttempts; i++ {
+		if _, _, err := env.Share.Unlock(link.Token, "wrong password"); err == ErrShareLinkLocked {
+			t.Fatalf("locked after %d wrong passwords, want %d", i, maxUnlockAttempts)
+		}
+	}
+	if _, _, err := env.Share.Unlock(link.Token, "open sesame"); err != ErrShareLinkLocked {
+		t.Fatalf("Unlock of a locked link = %v, want ErrShareLinkLocked", err)
+	}
+
+	err := env.db.Model(&ShareLink{}).Where("id = ?", link.ID).UpdateColumn("locked_until", time.Now().Add(-time.Second)).Error
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := env.Share.Unlock(link.Token, "open sesame"); err != nil {
+		t.Fatalf("Unlock after the lockout = %v", err)
+	}
+	// the right password starts the count over
+	for i := 0; i < maxUnlockAttempts-1; i++ {
+		env.Share.Unlock(link.Token, "wrong password")
+	}
+	if _, _, err := env.Share.Unlock(link.Token, "open sesame"); err != nil {
+		t.Fatalf("Unlock after %d wrong passwords = %v", maxUnlockAttempts-1, err)
+	}
+}
+
+func TestShareLinkExpiryAndViews(t *testing.T) {
+	env := newTestEnv(t)
+	beach := env.gallery(t, env.user(t, "alice@example.com"), "Beach")
+	limited := env.shareLink(t, beach, 2)
+
+	for i := 0; i < 2; i++ {
+		if err := env.Share.AddView(limited); err != nil {
+			t.Fatalf("view %d: %v", i+1, err)
+		}
+	}
+	if err := env.Share.AddView(limited); err != ErrShareLinkUnavailable {
+		t.Fatalf("view past the limit = %v, want ErrShareLinkUnavailable", err)
+	}
+	if _, _, err := env.Share.Unlock(limited.Token, "open sesame"); err != ErrShareLinkUnavailable {
+		t.Fatalf("Unlock of a used up link = %v, want ErrShareLinkUnavailable", err)
+	}
+
+	expired := env.shareLink(t, beach, 0)
+	err := env.db.Model(&ShareLink{}).Where("id = ?", expired.ID).UpdateColumn("expires_at", time.Now().Add(-time.Second)).Error
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := env.Share.ByToken(expired.Token); err != ErrShareLinkUnavailable {
+		t.Fatalf("ByToken of an expired link = %v, want ErrShareLinkUnavailable", err)
+	}
+	if _, _, err := env.Share.Unlock(expired.Token, "open sesame"); err != ErrShareLinkUnavailable {
+		t.Fatalf("Unlock of an expired link = %v, want ErrShareLinkUnavailable", err)
+	}
+}
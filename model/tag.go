@@ -0,0 +1,201 @@
+package model
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/jinzhu/gorm"
+)
+
+const (
+	// ErrTagInvalid is returned for tags that are too long or contain anything but letters, digits, - and _
+	ErrTagInvalid modelError = "model: tags can only contain letters, digits, - and _ and be at most 32 characters long"
+
+	// ErrTooManyTags is returned when more than maxTags tags are set at once
+	ErrTooManyTags modelError = "model: at most 20 tags can be added"
+)
+
+const (
+	maxTagLength = 32
+	maxTags      = 20
+)
+
+// Tag labels galleries and images so they can be found by search
+type Tag struct {
+	ID   uint   `gorm:"primary_key"`
+	Name string `gorm:"not_null;unique_index"`
+}
+
+// ParseTags splits user input such as "cats, #Dogs beach" into tag names
+func ParseTags(s string) []string {
+	return strings.FieldsFunc(s, func(r rune) bool {
+		return r == ',' || unicode.IsSpace(r)
+	})
+}
+
+// JoinTags is the inverse of ParseTags, used to fill in forms
+func JoinTags(tags []Tag) string {
+	names := make([]string, len(tags))
+	for i, t := range tags {
+		names[i] = t.Name
+	}
+	return strings.Join(names, ", ")
+}
+
+// TagService provides an interface to the Tag model
+type TagService interface {
+	TagDB
+}
+
+// TagDB is the DB connection for tags
+type TagDB interface {
+	ByGalleryID(galleryID uint) ([]Tag, error)
+	ByImageID(imageID uint) ([]Tag, error)
+
+	// ImageTagsByGalleryID loads the tags of all the gallery's images in one
+	// query, keyed by image ID
+	ImageTagsByGalleryID(galleryID uint) (map[uint][]Tag, error)
+
+	// SetGalleryTags replaces the tags of a gallery, creating tags as needed
+	SetGalleryTags(galleryID uint, names []string) ([]Tag, error)
+
+	// SetImageTags replaces the tags of an image, creating tags as needed
+	SetImageTags(imageID uint, names []string) ([]Tag, error)
+}
+
+type tagService struct {
+	TagDB
+}
+
+type tagValidator struct {
+	TagDB
+}
+
+type tagGorm struct {
+	db *gorm.DB
+}
+
+// NewTagService instantiates a new TagService
+func NewTagService(db *gorm.DB) TagService {
+	return &tagService{
+		TagDB: &tagValidator{
+			TagDB: &tagGorm{
+				db: db,
+			},
+		},
+	}
+}
+
+func (tv *tagValidator) SetGalleryTags(galleryID uint, names []string) ([]Tag, error) {
+	names, err := tv.normalize(names)
+	if err != nil {
+		return nil, err
+	}
+	return tv.TagDB.SetGalleryTags(galleryID, names)
+}
+
+func (tv *tagValidator) SetImageTags(imageID uint, names []string) ([]Tag, error) {
+	names, err := tv.normalize(names)
+	if err != nil {
+		return nil, err
+	}
+	return tv.TagDB.SetImageTags(imageID, names)
+}
+
+// normalize lowercases names, drops a leading # and duplicates, and checks the limits
+func (tv *tagValidator) normalize(names []string) ([]string, error) {
+	seen := make(map[string]bool, len(names))
+	var out []string
+	for _, name := range names {
+		name = strings.ToLower(strings.TrimPrefix(strings.TrimSpace(name), "#"))
+		if name == "" || seen[name] {
+			continue
+		}
+		if utf8.RuneCountInString(name) > maxTagLength || strings.IndexFunc(name, invalidTagRune) >= 0 {
+			return nil, NewValidationError("tags", ErrTagInvalid)
+		}
+		seen[name] = true
+		out = append(out, name)
+	}
+	if len(out) > maxTags {
+		return nil, NewValidationError("tags", ErrTooManyTags)
+	}
+	return out, nil
+}
+
+func invalidTagRune(r rune) bool {
+	return !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '-' && r != '_'
+}
+
+func (tg *tagGorm) ByGalleryID(galleryID uint) ([]Tag, error) {
+	var tags []Tag
+	gallery := Gallery{Model: gorm.Model{ID: galleryID}}
+	err := tg.db.Model(&gallery).Order("name").Related(&tags, "Tags").Error
+	if err != nil {
+		return nil, err
+	}
+	return tags, nil
+}
+
+func (tg *tagGorm) ByImageID(imageID uint) ([]Tag, error) {
+	var tags []Tag
+	image := Image{Model: gorm.Model{ID: imageID}}
+	err := tg.db.Model(&image).Order("name").Related(&tags, "Tags").Error
+	if err != nil {
+		return nil, err
+	}
+	return tags, nil
+}
+
+func (tg *tagGorm) ImageTagsByGalleryID(galleryID uint) (map[uint][]Tag, error) {
+	var rows []struct {
+		ImageID uint
+		ID      uint
+		Name    string
+	}
+	err := tg.db.Table("tags").Select("image_tags.image_id, tags.id, tags.name").
+		Joins("JOIN image_tags ON image_tags.tag_id = tags.id").
+		Joins("JOIN images ON images.id = image_tags.image_id").
+		Where("images.gallery_id = ? AND images.deleted_at IS NULL", galleryID).
+		Order("tags.name").Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+	tags := make(map[uint][]Tag)
+	for _, row := range rows {
+		tags[row.ImageID] = append(tags[row.ImageID], Tag{ID: row.ID, Name: row.Name})
+	}
+	return tags, nil
+}
+
+func (tg *tagGorm) SetGalleryTags(galleryID uint, names []string) ([]Tag, error) {
+	gallery := Gallery{Model: gorm.Model{ID: galleryID}}
+	return tg.replace(&gallery, names)
+}
+
+func (tg *tagGorm) SetImageTags(imageID uint, names []string) ([]Tag, error) {
+	image := Image{Model: gorm.Model{ID: imageID}}
+	return tg.replace(&image, names)
+}
+
+// replace sets the Tags association of owner to names in one transaction
+func (tg *tagGorm) replace(owner interface{}, names []string) ([]Tag, error) {
+	tags := make([]Tag, len(names))
+	err := tg.db.Transaction(func(tx *gorm.DB) error {
+		for i, name := range names {
+			if err := tx.Where(Tag{Name: name}).FirstOrCreate(&tags[i]).Error; err != nil {
+				return err
+			}
+		}
+		assoc := tx.Model(owner).Association("Tags")
+		if len(tags) == 0 {
+			return assoc.Clear().Error
+		}
+		return assoc.Replace(tags).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return tags, nil
+}
@@ -0,0 +1,308 @@
+package model
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/jhampac/picha/cache"
+	"github.com/jhampac/picha/jobs"
+	"github.com/jinzhu/gorm"
+)
+
+const (
+	// TrashRetention is how long deleted galleries and images can be restored
+	TrashRetention = 30 * 24 * time.Hour
+
+	// UndoWindow is how long the deletion of a gallery can be undone from the
+	// alert shown after it
+	UndoWindow = 10 * time.Minute
+
+	// JobPurgeTrash is the kind of the scheduled job that deletes expired trash for good
+	JobPurgeTrash = "trash.purge"
+)
+
+// TrashedImage is an image deleted on its own, with the title of its gallery
+type TrashedImage struct {
+	Image
+	GalleryTitle string
+}
+
+// PurgeAt is when a deleted gallery or image is deleted for good
+func PurgeAt(deletedAt *time.Time) time.Time {
+	if deletedAt == nil {
+		return time.Time{}
+	}
+	return deletedAt.Add(TrashRetention)
+}
+
+// TrashService lists what gallery owners deleted and restores it, or
+// deletes it for good together with the files nothing else uses
+type TrashService interface {
+	TrashDB
+
+	// PurgeTrash is the handler for JobPurgeTrash jobs
+	PurgeTrash(ctx context.Context, job *jobs.Job) error
+}
+
+// TrashDB is the DB connection for deleted galleries and images. Deleted
+// rows keep their blob references and count against their owner's storage
+// until they are purged.
+type TrashDB interface {
+	// Galleries lists the user's deleted galleries, most recently deleted first
+	Galleries(userID uint) ([]Gallery, error)
+
+	// Images lists the images deleted on their own from the user's galleries
+	Images(userID uint) ([]TrashedImage, error)
+
+	// Gallery finds a deleted gallery of the user
+	Gallery(id, userID uint) (*Gallery, error)
+
+	// Image finds an image deleted on its own from a gallery of the user
+	Image(id, userID uint) (*Image, error)
+
+	// RestoreGallery brings back the gallery with the images deleted along with it
+	RestoreGallery(id uint) error
+	RestoreImage(id uint) error
+
+	// PurgeGallery deletes the gallery and all of its images for good
+	PurgeGallery(id uint) error
+	PurgeImage(id uint) error
+
+	// Expired lists the galleries and the images deleted on their own before t
+	Expired(before time.Time) (galleryIDs, imageIDs []uint, err error)
+}
+
+type trashService struct {
+	TrashDB
+	images ImageService
+
+	// galleries is the cache of GalleryDB, told about restored and purged galleries
+	galleries cache.Cache
+}
+
+type trashGorm struct {
+	db *gorm.DB
+}
+
+// NewTrashService instantiates a new TrashService; images sweeps the files
+// of purged images and galleries is the gallery cache, which may be nil
+func NewTrashService(db *gorm.DB, images ImageService, galleries cache.Cache) TrashService {
+	return &trashService{
+		TrashDB: &trashGorm{
+			db: db,
+		},
+		images:    images,
+		galleries: galleries,
+	}
+}
+
+func (ts *trashService) RestoreGallery(id uint) error {
+	err := ts.TrashDB.RestoreGallery(id)
+	forgetGallery(ts.galleries, id)
+	if err != nil {
+		return err
+	}
+	images, err := ts.images.ByGalleryID(id)
+	if err != nil {
+		return err
+	}
+	for i := range images {
+		if err := ts.queuePending(&images[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (ts *trashService) RestoreImage(id uint) error {
+	if err := ts.TrashDB.RestoreImage(id); err != nil {
+		return err
+	}
+	image, err := ts.images.ByID(id)
+	if err != nil {
+		return err
+	}
+	return ts.queuePending(image)
+}
+
+// queuePending queues the variants of a restored image that is still
+// pending, since its job may have run, and found nothing to do, while the
+// image was in the trash. A job queued twice costs nothing: it skips images
+// that are no longer pending.
+func (ts *trashService) queuePending(image *Image) error {
+	if !image.Pending {
+		return nil
+	}
+	return ts.images.QueueVariants(image)
+}
+
+func (ts *trashService) PurgeGallery(id uint) error {
+	err := ts.TrashDB.PurgeGallery(id)
+	forgetGallery(ts.galleries, id)
+	if err != nil {
+		return err
+	}
+	return ts.images.Sweep(context.Background())
+}
+
+func (ts *trashService) PurgeImage(id uint) error {
+	if err := ts.TrashDB.PurgeImage(id); err != nil {
+		return err
+	}
+	return ts.images.Sweep(context.Background())
+}
+
+func (ts *trashService) PurgeTrash(ctx context.Context, job *jobs.Job) error {
+	galleryIDs, imageIDs, err := ts.Expired(time.Now().Add(-TrashRetention))
+	if err != nil {
+		return err
+	}
+	for _, id := range galleryIDs {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := ts.TrashDB.PurgeGallery(id); err != nil {
+			slog.ErrorContext(ctx, "purging gallery", "gallery_id", id, "error", err)
+		}
+		forgetGallery(ts.galleries, id)
+	}
+	for _, id := range imageIDs {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := ts.TrashDB.PurgeImage(id); err != nil {
+			slog.ErrorContext(ctx, "purging image", "image_id", id, "error", err)
+		}
+	}
+	return ts.images.Sweep(ctx)
+}
+
+func (tg *trashGorm) Galleries(userID uint) ([]Gallery, error) {
+	var galleries []Gallery
+	err := tg.db.Unscoped().Where("user_id = ? AND deleted_at IS NOT NULL", userID).
+		Order("deleted_at DESC").Find(&galleries).Error
+	if err != nil {
+		return nil, err
+	}
+	return galleries, nil
+}
+
+// trashedImages limits a query on images to the ones deleted on their own
+// from live galleries of userID
+func trashedImages(db *gorm.DB, userID uint) *gorm.DB {
+	return db.Unscoped().Table("images").
+		Joins("JOIN galleries ON galleries.id = images.gallery_id AND galleries.deleted_at IS NULL").
+		Where("galleries.user_id = ? AND images.deleted_at IS NOT NULL AND NOT images.deleted_with_gallery", userID)
+}
+
+func (tg *trashGorm) Images(userID uint) ([]TrashedImage, error) {
+	var images []TrashedImage
+	err := trashedImages(tg.db, userID).
+		Select("images.*, galleries.title AS gallery_title").
+		Order("images.deleted_at DESC").Scan(&images).Error
+	if err != nil {
+		return nil, err
+	}
+	return images, nil
+}
+
+func (tg *trashGorm) Gallery(id, userID uint) (*Gallery, error) {
+	var gallery Gallery
+	err := first(tg.db.Unscoped().Where("id = ? AND user_id = ? AND deleted_at IS NOT NULL", id, userID), &gallery)
+	if err != nil {
+		return nil, err
+	}
+	return &gallery, nil
+}
+
+func (tg *trashGorm) Image(id, userID uint) (*Image, error) {
+	var image Image
+	err := first(trashedImages(tg.db, userID).Where("images.id = ?", id).Select("images.*"), &image)
+	if err != nil {
+		return nil, err
+	}
+	return &image, nil
+}
+
+func (tg *trashGorm) RestoreGallery(id uint) error {
+	return tg.db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Unscoped().Model(&Image{}).Where("gallery_id = ? AND deleted_with_gallery", id).
+			UpdateColumns(map[string]interface{}{"deleted_at": nil, "deleted_with_gallery": false}).Error
+		if err != nil {
+			return err
+		}
+		return tx.Unscoped().Model(&Gallery{}).Where("id = ?", id).UpdateColumn("deleted_at", nil).Error
+	})
+}
+
+func (tg *trashGorm) RestoreImage(id uint) error {
+	return tg.db.Unscoped().Model(&Image{}).Where("id = ?", id).UpdateColumn("deleted_at", nil).Error
+}
+
+// PurgeGallery also removes everything else pointing at the gallery and its images
+func (tg *trashGorm) PurgeGallery(id uint) error {
+	return tg.db.Transaction(func(tx *gorm.DB) error {
+		if err := releaseGalleryUsage(tx, id); err != nil {
+			return err
+		}
+		if err := releaseGalleryBlobs(tx, id); err != nil {
+			return err
+		}
+		images := "image_id IN (SELECT id FROM images WHERE gallery_id = ?)"
+		if err := tx.Where(images, id).Delete(&CollectionImage{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Exec("DELETE FROM image_tags WHERE "+images, id).Error; err != nil {
+			return err
+		}
+		if err := tx.Unscoped().Where("gallery_id = ?", id).Delete(&Image{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Exec("DELETE FROM gallery_tags WHERE gallery_id = ?", id).Error; err != nil {
+			return err
+		}
+		for _, related := range []interface{}{&GalleryMember{}, &Invitation{}, &ShareLink{}} {
+			if err := tx.Unscoped().Where("gallery_id = ?", id).Delete(related).Error; err != nil {
+				return err
+			}
+		}
+		gallery := Gallery{Model: gorm.Model{ID: id}}
+		return tx.Unscoped().Delete(&gallery).Error
+	})
+}
+
+func (tg *trashGorm) PurgeImage(id uint) error {
+	return tg.db.Transaction(func(tx *gorm.DB) error {
+		var image Image
+		if err := first(tx.Unscoped().Where("id = ?", id), &image); err != nil {
+			return err
+		}
+		if err := chargeImage(tx, &image, -1); err != nil {
+			return err
+		}
+		if err := tx.Where("image_id = ?", id).Delete(&CollectionImage{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Exec("DELETE FROM image_tags WHERE image_id = ?", id).Error; err != nil {
+			return err
+		}
+		if err := releaseBlob(tx, image.Key); err != nil {
+			return err
+		}
+		return tx.Unscoped().Delete(&image).Error
+	})
+}
+
+func (tg *trashGorm) Expired(before time.Time) ([]uint, []uint, error) {
+	var galleryIDs, imageIDs []uint
+	err := tg.db.Unscoped().Model(&Gallery{}).Where("deleted_at < ?", before).Pluck("id", &galleryIDs).Error
+	if err != nil {
+		return nil, nil, err
+	}
+	err = tg.db.Unscoped().Model(&Image{}).Where("deleted_at < ? AND NOT deleted_with_gallery", before).Pluck("id", &imageIDs).Error
+	if err != nil {
+		return nil, nil, err
+	}
+	return galleryIDs, imageIDs, nil
+}
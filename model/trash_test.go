@@ -0,0 +1,238 @@
+package model
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/jhampac/picha/jobs"
+)
+
+// assertUsageExact fails when the storage counters drifted from what the
+// images refer to
+func assertUsageExact(t *testing.T, env *testEnv) {
+	t.Helper()
+	changes, err := env.Image.ReconcileUsage(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changes) != 0 {
+		t.Fatalf("storage counters drifted: %+v", changes)
+	}
+}
+
+func (env *testEnv) imageRows(t *testing.T, galleryID uint) int {
+	t.Helper()
+	var n int
+	if err := env.db.Unscoped().Model(&Image{}).Where("gallery_id = ?", galleryID).Count(&n).Error; err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+func TestTrashedImagesCanBeRestoredOrPurged(t *testing.T) {
+	env := newTestEnv(t)
+	alice := env.user(t, "alice@example.com")
+	beach := env.gallery(t, alice, "Beach")
+	photo := jpegBytes(t, 400, 300, 1)
+	first := env.upload(t, beach, photo)
+	second := env.upload(t, beach, photo)
+	env.makeVariants(t)
+	used := env.storageUsed(t, alice)
+	shared := env.blob(t, first.Key).Bytes
+
+	if err := env.Image.Remove(first); err != nil {
+		t.Fatal(err)
+	}
+	if got := env.storageUsed(t, alice); got != used {
+		t.Fatalf("usage went from %d to %d when trashing, want it unchanged until purged", used, got)
+	}
+	trashed, err := env.Trash.Images(alice.ID)
+	if err != nil || len(trashed) != 1 || trashed[0].ID != first.ID || trashed[0].GalleryTitle != "Beach" {
+		t.Fatalf("trash lists %+v, %v, want the removed image", trashed, err)
+	}
+	if err := env.Trash.RestoreImage(first.ID); err != nil {
+		t.Fatal(err)
+	}
+	if images, _ := env.Image.ByGalleryID(beach.ID); len(images) != 2 {
+		t.Fatalf("gallery has %d images after restoring, want 2", len(images))
+	}
+
+	if err := env.Image.Remove(first); err != nil {
+		t.Fatal(err)
+	}
+	if err := env.Trash.PurgeImage(first.ID); err != nil {
+		t.Fatal(err)
+	}
+	if got := env.storageUsed(t, alice); got != used-shared {
+		t.Fatalf("usage is %d after purging, want %d", got, used-shared)
+	}
+	if got := env.blob(t, first.Key).Refs; got != 1 || !env.stored(first.Key) {
+		t.Fatalf("purging one of two references left %d refs", got)
+	}
+	assertUsageExact(t, env)
+
+	if err := env.Image.Remove(second); err != nil {
+		t.Fatal(err)
+	}
+	if err := env.Trash.PurgeImage(second.ID); err != nil {
+		t.Fatal(err)
+	}
+	if got := env.storageUsed(t, alice); got != 0 {
+		t.Fatalf("usage is %d after purging everything, want 0", got)
+	}
+	if env.blobCount(t) != 0 || env.stored(first.Key) || env.stored(first.VariantKey(320)) {
+		t.Fatal("kept the blob after purging its last image")
+	}
+	if got := env.imageRows(t, beach.ID); got != 0 {
+		t.Fatalf("%d image rows left after purging, want 0", got)
+	}
+}
+
+func TestRestoringAGalleryBringsBackOnlyItsOwnImages(t *testing.T) {
+	env := newTestEnv(t)
+	alice := env.user(t, "alice@example.com")
+	beach := env.gallery(t, alice, "Beach")
+	kept := env.upload(t, beach, jpegBytes(t, 400, 300, 1))
+	removed := env.upload(t, beach, jpegBytes(t, 400, 300, 2))
+	used := env.storageUsed(t, alice)
+
+	if err := env.Image.Remove(removed); err != nil {
+		t.Fatal(err)
+	}
+	if err := env.Gallery.Delete(beach.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := env.Gallery.ByID(beach.ID); err != ErrNotFound {
+		t.Fatalf("ByID of a deleted gallery = %v, want ErrNotFound", err)
+	}
+	if images, _ := env.Trash.Images(alice.ID); len(images) != 0 {
+		t.Fatalf("trash lists %d images of a deleted gallery on their own, want 0", len(images))
+	}
+	galleries, err := env.Trash.Galleries(alice.ID)
+	if err != nil || len(galleries) != 1 {
+		t.Fatalf("trash lists %d galleries, %v, want 1", len(galleries), err)
+	}
+
+	if err := env.Trash.RestoreGallery(beach.ID); err != nil {
+		t.Fatal(err)
+	}
+	images, err := env.Image.ByGalleryID(beach.ID)
+	if err != nil || len(images) != 1 || images[0].ID != kept.ID {
+		t.Fatalf("restored gallery has %+v, %v, want only the image deleted with it", images, err)
+	}
+	trashed, err := env.Trash.Images(alice.ID)
+	if err != nil || len(trashed) != 1 || trashed[0].ID != removed.ID {
+		t.Fatalf("trash lists %+v, %v, want the image removed before the gallery", trashed, err)
+	}
+	if got := env.storageUsed(t, alice); got != used {
+		t.Fatalf("usage went from %d to %d, want it unchanged", used, got)
+	}
+	assertUsageExact(t, env)
+}
+
+func TestPurgingAGalleryReleasesItsImages(t *testing.T) {
+	env := newTestEnv(t)
+	alice := env.user(t, "alice@example.com")
+	beach := env.gallery(t, alice, "Beach")
+	city := env.gallery(t, alice, "City")
+	shared := jpegBytes(t, 400, 300, 1)
+	sharedImage := env.upload(t, beach, shared)
+	env.upload(t, city, shared)
+	own := env.upload(t, beach, jpegBytes(t, 400, 300, 2))
+	removed := env.upload(t, beach, jpegBytes(t, 400, 300, 3))
+	env.makeVariants(t)
+	cityBytes := env.blob(t, sharedImage.Key).Bytes
+
+	if err := env.Image.Remove(removed); err != nil {
+		t.Fatal(err)
+	}
+	if err := env.Gallery.Delete(beach.ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := env.Trash.PurgeGallery(beach.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := env.storageUsed(t, alice); got != cityBytes {
+		t.Fatalf("usage is %d after purging, want the other gallery's %d", got, cityBytes)
+	}
+	if got := env.blob(t, sharedImage.Key).Refs; got != 1 || !env.stored(sharedImage.Key) {
+		t.Fatalf("shared blob has %d refs after purging, want 1 and its file", got)
+	}
+	if env.blobCount(t) != 1 || env.stored(own.Key) || env.stored(removed.Key) {
+		t.Fatal("kept blobs only the purged gallery used")
+	}
+	if got := env.imageRows(t, beach.ID); got != 0 {
+		t.Fatalf("%d image rows left, want 0", got)
+	}
+	if _, err := env.Trash.Gallery(beach.ID, alice.ID); err != ErrNotFound {
+		t.Fatalf("purged gallery is still in the trash: %v", err)
+	}
+	assertUsageExact(t, env)
+}
+
+func TestPurgeTrashDeletesExpiredItems(t *testing.T) {
+	env := newTestEnv(t)
+	alice := env.user(t, "alice@example.com")
+	old := env.gallery(t, alice, "Old")
+	recent := env.gallery(t, alice, "Recent")
+	oldImage := env.upload(t, old, jpegBytes(t, 400, 300, 1))
+	env.upload(t, recent, jpegBytes(t, 400, 300, 2))
+	for _, id := range []uint{old.ID, recent.ID} {
+		if err := env.Gallery.Delete(id); err != nil {
+			t.Fatal(err)
+		}
+	}
+	expired := time.Now().Add(-TrashRetention - time.Hour)
+	if err := env.db.Unscoped().Model(&Gallery{}).Where("id = ?", old.ID).UpdateColumn("deleted_at", expired).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	if err := env.Trash.PurgeTrash(context.Background(), &jobs.Job{Kind: JobPurgeTrash}); err != nil {
+		t.Fatal(err)
+	}
+	galleries, err := env.Trash.Galleries(alice.ID)
+	if err != nil || len(galleries) != 1 || galleries[0].ID != recent.ID {
+		t.Fatalf("trash holds %+v, %v, want only the recent gallery", galleries, err)
+	}
+	if env.stored(oldImage.Key) {
+		t.Fatal("kept the file of an expired gallery")
+	}
+	assertUsageExact(t, env)
+}
+
+func TestRestoringQueuesVariantsSkippedInTheTrash(t *testing.T) {
+	env := newTestEnv(t)
+	alice := env.user(t, "alice@example.com")
+	beach := env.gallery(t, alice, "Beach")
+	city := env.gallery(t, alice, "City")
+	alone := env.upload(t, beach, jpegBytes(t, 400, 300, 1))
+	withGallery := env.upload(t, city, jpegBytes(t, 400, 300, 2))
+
+	if err := env.Image.Remove(alone); err != nil {
+		t.Fatal(err)
+	}
+	if err := env.Gallery.Delete(city.ID); err != nil {
+		t.Fatal(err)
+	}
+	// the jobs run while both images are in the trash and find nothing to do
+	env.makeVariants(t)
+
+	if err := env.Trash.RestoreImage(alone.ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := env.Trash.RestoreGallery(city.ID); err != nil {
+		t.Fatal(err)
+	}
+	env.makeVariants(t)
+	for _, image := range []*Image{alone, withGallery} {
+		found, err := env.Image.ByID(image.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if found.Pending || !env.stored(found.VariantKey(320)) {
+			t.Errorf("image %d is still pending after it was restored", image.ID)
+		}
+	}
+}
@@ -0,0 +1,357 @@
+package model
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"time"
+
+	"github.com/jhampac/picha/jobs"
+	"github.com/jhampac/picha/rand"
+	"github.com/jhampac/picha/storage"
+	"github.com/jinzhu/gorm"
+)
+
+const (
+	// ErrUploadLengthInvalid is returned when a resumable upload is created without a positive size
+	ErrUploadLengthInvalid modelError = "model: the upload size must be given"
+
+	// ErrUploadTooLarge is returned for resumable uploads over MaxUploadBytes
+	ErrUploadTooLarge modelError = "model: uploads can be at most 512 MB"
+
+	// ErrUploadOffset is returned when a chunk does not continue where the upload stopped
+	ErrUploadOffset modelError = "model: the chunk does not start at the upload's offset"
+
+	// ErrUploadLocked is returned while another request appends to the upload or creates its image
+	ErrUploadLocked modelError = "model: the upload is busy with another request, try again shortly"
+)
+
+const (
+	// MaxUploadBytes is the largest file a resumable upload accepts
+	MaxUploadBytes = 512 << 20
+
+	// UploadExpiry is how long an unfinished upload is kept after its last chunk
+	UploadExpiry = 24 * time.Hour
+
+	// JobExpireUploads is the kind of the scheduled job that removes expired uploads
+	JobExpireUploads = "uploads.expire"
+
+	// uploadLockTime is how long a request may hold an upload; the lock of a
+	// request that died lapses after it
+	uploadLockTime = 10 * time.Minute
+)
+
+// uploadTokenBytes is the size of the random token in upload URLs
+const uploadTokenBytes = 18
+
+// Upload is a file sent to a gallery in chunks, so a broken connection only
+// loses the chunk in flight. Its bytes are kept apart from the stored images
+// until Offset reaches Length.
+type Upload struct {
+	gorm.Model
+	Token     string `gorm:"not_null;unique_index"`
+	UserID    uint   `gorm:"not_null"`
+	GalleryID uint   `gorm:"not_null"`
+	Filename  string
+
+	// OwnerID is the gallery's owner, whose storage quota the upload counts against
+	OwnerID uint `gorm:"not_null;index"`
+
+	Length int64 `gorm:"not_null"`
+	Offset int64 `gorm:"column:upload_offset;not_null;default:0"`
+
+	// ImageID is the image created once every byte arrived
+	ImageID uint
+
+	// LockedUntil is when the request appending to the upload or creating
+	// its image gives it up at the latest
+	LockedUntil *time.Time
+}
+
+// Complete reports whether every byte of the upload was received
+func (u *Upload) Complete() bool {
+	return u.Offset == u.Length
+}
+
+// ExpiresAt is when an unfinished upload is removed
+func (u *Upload) ExpiresAt() time.Time {
+	return u.UpdatedAt.Add(UploadExpiry)
+}
+
+// UploadService provides resumable uploads and their partial files
+type UploadService interface {
+	UploadDB
+
+	// Append writes the chunk r, which must start at offset, and advances the
+	// upload. It holds the upload's lock meanwhile and stops reading shortly
+	// before the lock lapses; the client resumes after what was received.
+	Append(upload *Upload, offset int64, r io.Reader) error
+
+	// Open reads the bytes received so far
+	Open(upload *Upload) (io.ReadCloser, error)
+
+	// Finish records the image created from the upload and drops the file,
+	// keeping the upload itself so clients can still ask for its offset
+	Finish(upload *Upload, imageID uint) error
+
+	// Remove deletes the upload and its partial file
+	Remove(upload *Upload) error
+
+	// ExpireUploads is the handler for JobExpireUploads jobs
+	ExpireUploads(ctx context.Context, job *jobs.Job) error
+}
+
+// UploadDB is the DB connection for resumable uploads
+type UploadDB interface {
+	// ByToken only finds unexpired uploads
+	ByToken(token string) (*Upload, error)
+
+	// Create validates the size against MaxUploadBytes and the owner's storage quota
+	Create(upload *Upload) error
+
+	// Lock claims the upload for one request at a time until uploadLockTime
+	// from now, failing with ErrUploadLocked while another request holds it.
+	// It reloads the upload, so the caller sees what the last holder left.
+	Lock(upload *Upload) error
+	Unlock(upload *Upload) error
+
+	// Advance moves the offset forward unless another request moved it first
+	Advance(upload *Upload, offset int64) error
+
+	// Finish records the image created from the upload
+	Finish(upload *Upload, imageID uint) error
+	Delete(id uint) error
+
+	// Expired lists the uploads untouched since before t
+	Expired(before time.Time) ([]Upload, error)
+}
+
+type uploadService struct {
+	UploadDB
+	chunks *storage.Chunks
+}
+
+type uploadValidator struct {
+	UploadDB
+	db    *gorm.DB
+	usage *usageGorm
+}
+
+type uploadGorm struct {
+	db *gorm.DB
+}
+
+// NewUploadService instantiates a new UploadService keeping partial files in chunks
+func NewUploadService(db *gorm.DB, chunks *storage.Chunks) UploadService {
+	return &uploadService{
+		UploadDB: &uploadValidator{
+			UploadDB: &uploadGorm{
+				db: db,
+			},
+			db:    db,
+			usage: &usageGorm{db: db},
+		},
+		chunks: chunks,
+	}
+}
+
+func (us *uploadService) Append(upload *Upload, offset int64, r io.Reader) error {
+	if offset != upload.Offset {
+		return ErrUploadOffset
+	}
+	// the file is only written under the lock, so two requests at the same
+	// offset cannot both write to it
+	if err := us.Lock(upload); err != nil {
+		return err
+	}
+	defer us.unlock(upload)
+	if offset != upload.Offset {
+		return ErrUploadOffset
+	}
+
+	// never take more than announced, nor read past the lock
+	r = &deadlineReader{
+		r:        io.LimitReader(r, upload.Length-offset),
+		deadline: upload.LockedUntil.Add(-time.Minute),
+	}
+	n, err := us.chunks.Append(upload.Token, offset, r)
+	if err == storage.ErrOffsetMismatch {
+		return ErrUploadOffset
+	}
+	if n > 0 {
+		if aerr := us.Advance(upload, offset+n); aerr != nil {
+			// clients resume from the stored offset, so the file must not
+			// stay longer than it; ErrUploadOffset means another request
+			// moved the offset and the bytes are its own
+			if aerr != ErrUploadOffset {
+				if terr := us.chunks.Truncate(upload.Token, offset); terr != nil {
+					slog.Error("truncating unrecorded upload chunk", "upload_id", upload.ID, "error", terr)
+				}
+			}
+			return aerr
+		}
+	}
+	return err
+}
+
+func (us *uploadService) unlock(upload *Upload) {
+	if err := us.Unlock(upload); err != nil {
+		slog.Error("unlocking upload", "upload_id", upload.ID, "error", err)
+	}
+}
+
+// deadlineReader ends r once the deadline passed; bytes read after it are
+// dropped rather than written
+type deadlineReader struct {
+	r        io.Reader
+	deadline time.Time
+}
+
+func (dr *deadlineReader) Read(p []byte) (int, error) {
+	if !time.Now().Before(dr.deadline) {
+		return 0, io.EOF
+	}
+	n, err := dr.r.Read(p)
+	if !time.Now().Before(dr.deadline) {
+		return 0, io.EOF
+	}
+	return n, err
+}
+
+func (us *uploadService) Open(upload *Upload) (io.ReadCloser, error) {
+	return us.chunks.Open(upload.Token)
+}
+
+func (us *uploadService) Finish(upload *Upload, imageID uint) error {
+	if err := us.UploadDB.Finish(upload, imageID); err != nil {
+		return err
+	}
+	return us.chunks.Delete(upload.Token)
+}
+
+func (us *uploadService) Remove(upload *Upload) error {
+	if err := us.Delete(upload.ID); err != nil {
+		return err
+	}
+	return us.chunks.Delete(upload.Token)
+}
+
+func (us *uploadService) ExpireUploads(ctx context.Context, job *jobs.Job) error {
+	uploads, err := us.Expired(time.Now().Add(-UploadExpiry))
+	if err != nil {
+		return err
+	}
+	for i := range uploads {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := us.Remove(&uploads[i]); err != nil {
+			slog.ErrorContext(ctx, "removing expired upload", "upload_id", uploads[i].ID, "error", err)
+		}
+	}
+	return nil
+}
+
+func (uv *uploadValidator) Create(upload *Upload) error {
+	switch {
+	case upload.Length <= 0:
+		return ErrUploadLengthInvalid
+	case upload.Length > MaxUploadBytes:
+		return ErrUploadTooLarge
+	}
+	owner, err := uv.usage.galleryOwner(upload.GalleryID)
+	if err != nil {
+		return err
+	}
+	upload.OwnerID = owner.ID
+	reserved, err := uv.reserved(owner.ID)
+	if err != nil {
+		return err
+	}
+	if reserved+upload.Length > owner.StorageLeft() {
+		return ErrQuotaExceeded
+	}
+
+	token, err := rand.String(uploadTokenBytes)
+	if err != nil {
+		return err
+	}
+	upload.Token = token
+	upload.Offset = 0
+	return uv.UploadDB.Create(upload)
+}
+
+// reserved is the space unfinished uploads to the user's galleries have claimed
+func (uv *uploadValidator) reserved(userID uint) (int64, error) {
+	var uploads struct{ Total int64 }
+	err := uv.db.Table("uploads").Select("COALESCE(SUM(length), 0) AS total").
+		Where("owner_id = ? AND image_id = 0 AND deleted_at IS NULL", userID).Scan(&uploads).Error
+	if err != nil {
+		return 0, err
+	}
+	return uploads.Total, nil
+}
+
+func (ug *uploadGorm) ByToken(token string) (*Upload, error) {
+	var upload Upload
+	err := first(ug.db.Where("token = ? AND updated_at > ?", token, time.Now().Add(-UploadExpiry)), &upload)
+	if err != nil {
+		return nil, err
+	}
+	return &upload, nil
+}
+
+func (ug *uploadGorm) Create(upload *Upload) error {
+	return ug.db.Create(upload).Error
+}
+
+func (ug *uploadGorm) Lock(upload *Upload) error {
+	now := time.Now()
+	res := ug.db.Model(&Upload{}).Where("id = ? AND (locked_until IS NULL OR locked_until <= ?)", upload.ID, now).
+		UpdateColumn("locked_until", now.Add(uploadLockTime))
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return ErrUploadLocked
+	}
+	return first(ug.db.Where("id = ?", upload.ID), upload)
+}
+
+func (ug *uploadGorm) Unlock(upload *Upload) error {
+	upload.LockedUntil = nil
+	return ug.db.Model(&Upload{}).Where("id = ?", upload.ID).UpdateColumn("locked_until", nil).Error
+}
+
+func (ug *uploadGorm) Advance(upload *Upload, offset int64) error {
+	now := time.Now()
+	res := ug.db.Model(&Upload{}).Where("id = ? AND upload_offset = ?", upload.ID, upload.Offset).
+		UpdateColumns(map[string]interface{}{"upload_offset": offset, "updated_at": now})
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return ErrUploadOffset
+	}
+	upload.Offset = offset
+	upload.UpdatedAt = now
+	return nil
+}
+
+func (ug *uploadGorm) Finish(upload *Upload, imageID uint) error {
+	upload.ImageID = imageID
+	return ug.db.Model(upload).UpdateColumn("image_id", imageID).Error
+}
+
+func (ug *uploadGorm) Delete(id uint) error {
+	upload := Upload{Model: gorm.Model{ID: id}}
+	return ug.db.Unscoped().Delete(&upload).Error
+}
+
+func (ug *uploadGorm) Expired(before time.Time) ([]Upload, error) {
+	var uploads []Upload
+	if err := ug.db.Where("updated_at < ?", before).Find(&uploads).Error; err != nil {
+		return nil, err
+	}
+	return uploads, nil
+}
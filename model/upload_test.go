@@ -0,0 +1,123 @@
+package model
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+// advanceFails is an UploadDB whose Advance fails like a lost connection
+type advanceFails struct {
+	UploadDB
+}
+
+func (advanceFails) Advance(upload *Upload, offset int64) error {
+	return errors.New("connection reset")
+}
+
+func TestAppendDropsChunksWhoseOffsetWasNotSaved(t *testing.T) {
+	env := newTestEnv(t)
+	alice := env.user(t, "alice@example.com")
+	beach := env.gallery(t, alice, "Beach")
+	upload := Upload{UserID: alice.ID, GalleryID: beach.ID, Filename: "notes.txt", Length: 10}
+	if err := env.Upload.Create(&upload); err != nil {
+		t.Fatal(err)
+	}
+	us := env.Upload.(*uploadService)
+	db := us.UploadDB
+
+	us.UploadDB = advanceFails{db}
+	if err := us.Append(&upload, 0, strings.NewReader("hello")); err == nil {
+		t.Fatal("Append succeeded without saving the offset")
+	}
+	us.UploadDB = db
+	if upload.Offset != 0 {
+		t.Fatalf("offset moved to %d without being saved", upload.Offset)
+	}
+
+	// the client resumes from the saved offset
+	if err := us.Append(&upload, 0, strings.NewReader("hello")); err != nil {
+		t.Fatalf("resuming at the saved offset: %v", err)
+	}
+	if err := us.Append(&upload, 5, strings.NewReader("world")); err != nil {
+		t.Fatal(err)
+	}
+	rc, err := us.Open(&upload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rc.Close()
+	b, err := io.ReadAll(rc)
+	if err != nil || string(b) != "helloworld" {
+		t.Fatalf("upload holds %q, %v, want helloworld", b, err)
+	}
+}
+
+func TestAppendRejectsChunksAtTheWrongOffset(t *testing.T) {
+	env := newTestEnv(t)
+	alice := env.user(t, "alice@example.com")
+	beach := env.gallery(t, alice, "Beach")
+	upload := Upload{UserID: alice.ID, GalleryID: beach.ID, Filename: "notes.txt", Length: 10}
+	if err := env.Upload.Create(&upload); err != nil {
+		t.Fatal(err)
+	}
+	if err := env.Upload.Append(&upload, 3, strings.NewReader("lo")); err != ErrUploadOffset {
+		t.Fatalf("Append at a gap = %v, want ErrUploadOffset", err)
+	}
+}
+
+func TestLockedUploadsTakeOneRequestAtATime(t *testing.T) {
+	env := newTestEnv(t)
+	alice := env.user(t, "alice@example.com")
+	beach := env.gallery(t, alice, "Beach")
+	upload := Upload{UserID: alice.ID, GalleryID: beach.ID, Filename: "notes.txt", Length: 10}
+	if err := env.Upload.Create(&upload); err != nil {
+		t.Fatal(err)
+	}
+
+	// another request at the same offset holds the upload
+	other := upload
+	if err := env.Upload.Lock(&other); err != nil {
+		t.Fatal(err)
+	}
+	if err := env.Upload.Append(&upload, 0, strings.NewReader("hello")); err != ErrUploadLocked {
+		t.Fatalf("Append to a locked upload = %v, want ErrUploadLocked", err)
+	}
+	if err := env.Upload.Append(&other, 0, strings.NewReader("hello")); err != ErrUploadLocked {
+		t.Fatalf("second lock of the same upload = %v, want ErrUploadLocked", err)
+	}
+	if err := env.Upload.Unlock(&other); err != nil {
+		t.Fatal(err)
+	}
+	if err := env.Upload.Append(&upload, 0, strings.NewReader("hello")); err != nil {
+		t.Fatalf("Append after the unlock = %v", err)
+	}
+
+	// the lock of a request that died lapses
+	if err := env.Upload.Lock(&other); err != nil {
+		t.Fatal(err)
+	}
+	if other.Offset != 5 {
+		t.Fatalf("Lock loaded offset %d, want 5", other.Offset)
+	}
+	err := env.db.Model(&Upload{}).Where("id = ?", upload.ID).UpdateColumn("locked_until", time.Now().Add(-time.Second)).Error
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := env.Upload.Append(&upload, 5, strings.NewReader("world")); err != nil {
+		t.Fatalf("Append after the lock lapsed = %v", err)
+	}
+}
+
+func TestDeadlineReaderStopsAtTheDeadline(t *testing.T) {
+	r := &deadlineReader{r: strings.NewReader("hello"), deadline: time.Now().Add(-time.Second)}
+	if b, err := io.ReadAll(r); err != nil || len(b) != 0 {
+		t.Fatalf("read %q, %v past the deadline, want nothing", b, err)
+	}
+	r = &deadlineReader{r: strings.NewReader("hello"), deadline: time.Now().Add(time.Minute)}
+	if b, err := io.ReadAll(r); err != nil || string(b) != "hello" {
+		t.Fatalf("read %q, %v before the deadline, want hello", b, err)
+	}
+}
@@ -0,0 +1,194 @@
+package model
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/jhampac/picha/imaging"
+	"github.com/jinzhu/gorm"
+)
+
+const (
+	// ErrQuotaExceeded is returned when a file would take its gallery's owner
+	// over their plan's storage quota. The variants made after the upload are
+	// only estimated by then, so they can still take a user slightly over it.
+	ErrQuotaExceeded modelError = "model: there is not enough storage space left for this upload"
+
+	// ErrPlanInvalid is returned when a user is given a plan that does not exist
+	ErrPlanInvalid modelError = "model: plan is not valid"
+)
+
+// Plan is the storage tier of a user
+type Plan string
+
+const (
+	PlanFree Plan = "free"
+	PlanPro  Plan = "pro"
+)
+
+// planQuotas are the bytes of originals and variants each plan may store
+var planQuotas = map[Plan]int64{
+	PlanFree: 10 << 30,
+	PlanPro:  250 << 30,
+}
+
+// Valid reports whether p is a known plan
+func (p Plan) Valid() bool {
+	_, ok := planQuotas[p]
+	return ok
+}
+
+// Quota is how many bytes users on the plan may store
+func (p Plan) Quota() int64 {
+	return planQuotas[p]
+}
+
+// StorageLeft is how many more bytes the user may store before reaching their quota
+func (u *User) StorageLeft() int64 {
+	if left := u.Plan.Quota() - u.StorageUsed; left > 0 {
+		return left
+	}
+	return 0
+}
+
+// StoragePercent is the share of the quota in use, from 0 to 100
+func (u *User) StoragePercent() int {
+	quota := u.Plan.Quota()
+	if quota <= 0 || u.StorageUsed >= quota {
+		return 100
+	}
+	return int(u.StorageUsed * 100 / quota)
+}
+
+// variantsEstimate guesses what the variants of an original of size bytes
+// and width pixels will take up, assuming bytes grow with the area
+func variantsEstimate(size int64, width int) int64 {
+	var total float64
+	for _, w := range imaging.VariantWidths(width) {
+		scale := float64(w) / float64(width)
+		total += float64(size) * scale * scale
+	}
+	return int64(total)
+}
+
+// UsageChange is a user whose stored bytes were corrected by ReconcileUsage
+type UsageChange struct {
+	UserID uint
+	Email  string
+	Before int64
+	After  int64
+}
+
+// usageGorm keeps the per user storage counters. Every image counts the
+// whole of its blob against its gallery's owner, even when the file is
+// shared with other images, until it is purged from the trash.
+type usageGorm struct {
+	db *gorm.DB
+}
+
+// galleryOwner is the user whose quota the gallery's images count against
+func (ug *usageGorm) galleryOwner(galleryID uint) (*User, error) {
+	var user User
+	err := first(ug.db.Joins("JOIN galleries ON galleries.user_id = users.id").Where("galleries.id = ?", galleryID), &user)
+	if err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+// recompute sets every user's StorageUsed to the bytes of the blobs their
+// galleries' images use, including the trash. A counter that changed while
+// it ran is left alone.
+func (ug *usageGorm) recompute() ([]UsageChange, error) {
+	var rows []UsageChange
+	err := ug.db.Raw(`SELECT users.id AS user_id, users.email AS email, users.storage_used AS before, COALESCE(SUM(blobs.bytes), 0) AS after
+		FROM users
+		LEFT JOIN galleries ON galleries.user_id = users.id
+		LEFT JOIN images ON images.gallery_id = galleries.id
+		LEFT JOIN blobs ON blobs.key = images.key
+		WHERE users.deleted_at IS NULL
+		GROUP BY users.id, users.email, users.storage_used
+		ORDER BY users.id`).Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+
+	var changes []UsageChange
+	for _, row := range rows {
+		if row.Before == row.After {
+			continue
+		}
+		res := ug.db.Model(&User{}).Where("id = ? AND storage_used = ?", row.UserID, row.Before).
+			UpdateColumn("storage_used", row.After)
+		if res.Error != nil {
+			return changes, res.Error
+		}
+		if res.RowsAffected == 1 {
+			changes = append(changes, row)
+		}
+	}
+	return changes, nil
+}
+
+// chargeImage adds the bytes of the image's blob to its gallery owner's
+// usage, or with sign -1 removes them
+func chargeImage(tx *gorm.DB, image *Image, sign int) error {
+	return tx.Exec(`UPDATE users SET storage_used = storage_used + ? * COALESCE((SELECT bytes FROM blobs WHERE key = ?), 0)
+		WHERE id = (SELECT user_id FROM galleries WHERE id = ?)`, sign, image.Key, image.GalleryID).Error
+}
+
+// chargeUpload charges a new image like chargeImage, but only while its
+// owner stays within their quota with headroom bytes to spare; checking in
+// the same statement keeps concurrent uploads from all fitting
+func chargeUpload(tx *gorm.DB, image *Image, headroom int64) error {
+	quota, args := quotaSQL()
+	blobBytes := "COALESCE((SELECT bytes FROM blobs WHERE key = ?), 0)"
+	res := tx.Exec(`UPDATE users SET storage_used = storage_used + `+blobBytes+`
+		WHERE id = (SELECT user_id FROM galleries WHERE id = ?)
+		AND storage_used + `+blobBytes+` + ? <= `+quota,
+		append([]interface{}{image.Key, image.GalleryID, image.Key, headroom}, args...)...)
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return ErrQuotaExceeded
+	}
+	return nil
+}
+
+// quotaSQL is the quota of a users row's plan as an SQL expression and its arguments
+func quotaSQL() (string, []interface{}) {
+	plans := make([]string, 0, len(planQuotas))
+	for plan := range planQuotas {
+		plans = append(plans, string(plan))
+	}
+	sort.Strings(plans)
+
+	var b strings.Builder
+	var args []interface{}
+	b.WriteString("CASE plan")
+	for _, plan := range plans {
+		b.WriteString(" WHEN ? THEN ?")
+		args = append(args, plan, planQuotas[Plan(plan)])
+	}
+	b.WriteString(" ELSE 0 END")
+	return b.String(), args
+}
+
+// chargeVariants adds bytes of new variants of the blob to the owner of every
+// image using it
+func chargeVariants(tx *gorm.DB, key string, bytes int64) error {
+	return tx.Exec(`UPDATE users SET storage_used = storage_used + ? * (SELECT COUNT(*) FROM images
+			JOIN galleries ON galleries.id = images.gallery_id
+			WHERE images.key = ? AND galleries.user_id = users.id)
+		WHERE id IN (SELECT galleries.user_id FROM images JOIN galleries ON galleries.id = images.gallery_id
+			WHERE images.key = ?)`, bytes, key, key).Error
+}
+
+// releaseGalleryUsage removes the bytes of all the gallery's images from its
+// owner's usage; it must run before the images are purged
+func releaseGalleryUsage(tx *gorm.DB, galleryID uint) error {
+	return tx.Exec(`UPDATE users SET storage_used = storage_used - (SELECT COALESCE(SUM(blobs.bytes), 0) FROM images
+			JOIN blobs ON blobs.key = images.key WHERE images.gallery_id = ?)
+		WHERE id = (SELECT user_id FROM galleries WHERE id = ?)`, galleryID, galleryID).Error
+}
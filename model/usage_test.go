@@ -0,0 +1,138 @@
+package model
+
+import (
+	"bytes"
+	"context"
+	"testing"
+)
+
+func TestUploadsChargeTheGalleryOwner(t *testing.T) {
+	env := newTestEnv(t)
+	alice := env.user(t, "alice@example.com")
+	bob := env.user(t, "bob@example.com")
+	photo := jpegBytes(t, 400, 300, 1)
+
+	image := env.upload(t, env.gallery(t, alice, "Beach"), photo)
+	env.upload(t, env.gallery(t, bob, "Holiday"), photo)
+	original := env.blob(t, image.Key).Bytes
+	if original != int64(len(photo)) {
+		t.Fatalf("blob takes up %d bytes, want the original's %d", original, len(photo))
+	}
+	if got := env.storageUsed(t, alice); got != original {
+		t.Fatalf("alice uses %d bytes, want %d", got, original)
+	}
+
+	// both owners pay for the shared file in full, variants included
+	env.makeVariants(t)
+	total := env.blob(t, image.Key).Bytes
+	if total <= original {
+		t.Fatalf("blob takes up %d bytes after its variants, want more than %d", total, original)
+	}
+	for _, user := range []*User{alice, bob} {
+		if got := env.storageUsed(t, user); got != total {
+			t.Errorf("%s uses %d bytes, want %d", user.Email, got, total)
+		}
+	}
+}
+
+func TestQuotaCountsTheVariantsToCome(t *testing.T) {
+	env := newTestEnv(t)
+	alice := env.user(t, "alice@example.com")
+	beach := env.gallery(t, alice, "Beach")
+	photo := jpegBytes(t, 400, 300, 1)
+	size := int64(len(photo))
+	estimate := variantsEstimate(size, 400)
+	if estimate <= 0 {
+		t.Fatal("no estimate for an image with variants")
+	}
+
+	quota := planQuotas[PlanFree]
+	t.Cleanup(func() { planQuotas[PlanFree] = quota })
+
+	planQuotas[PlanFree] = size + estimate/2
+	if _, err := env.Image.Upload(beach.ID, "photo.jpg", bytes.NewReader(photo)); err != ErrQuotaExceeded {
+		t.Fatalf("upload leaving no room for variants = %v, want ErrQuotaExceeded", err)
+	}
+	planQuotas[PlanFree] = size + estimate
+	env.upload(t, beach, photo)
+}
+
+func TestReconcileUsageCorrectsDrift(t *testing.T) {
+	env := newTestEnv(t)
+	alice := env.user(t, "alice@example.com")
+	image := env.upload(t, env.gallery(t, alice, "Beach"), jpegBytes(t, 400, 300, 1))
+	env.makeVariants(t)
+	want := env.blob(t, image.Key).Bytes
+
+	err := env.db.Exec("UPDATE users SET storage_used = 7").Error
+	if err == nil {
+		err = env.db.Exec("UPDATE blobs SET bytes = 3").Error
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	changes, err := env.Image.ReconcileUsage(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changes) != 1 || changes[0].UserID != alice.ID || changes[0].Before != 7 || changes[0].After != want {
+		t.Fatalf("changes = %+v, want alice from 7 to %d", changes, want)
+	}
+	if got := env.blob(t, image.Key).Bytes; got != want {
+		t.Fatalf("blob bytes = %d after reconciling, want %d", got, want)
+	}
+	if changes, err := env.Image.ReconcileUsage(context.Background()); err != nil || len(changes) != 0 {
+		t.Fatalf("second run = %+v, %v, want no changes", changes, err)
+	}
+}
+
+func TestUploadReservationsCountAgainstTheQuota(t *testing.T) {
+	env := newTestEnv(t)
+	alice := env.user(t, "alice@example.com")
+	beach := env.gallery(t, alice, "Beach")
+	quota := planQuotas[PlanFree]
+	t.Cleanup(func() { planQuotas[PlanFree] = quota })
+	planQuotas[PlanFree] = 100
+
+	first := Upload{UserID: alice.ID, GalleryID: beach.ID, Filename: "a.jpg", Length: 60}
+	if err := env.Upload.Create(&first); err != nil {
+		t.Fatal(err)
+	}
+	second := Upload{UserID: alice.ID, GalleryID: beach.ID, Filename: "b.jpg", Length: 60}
+	if err := env.Upload.Create(&second); err != ErrQuotaExceeded {
+		t.Fatalf("upload past the space reserved = %v, want ErrQuotaExceeded", err)
+	}
+}
+
+func TestCreateChecksTheQuotaAsItCharges(t *testing.T) {
+	env := newTestEnv(t)
+	alice := env.user(t, "alice@example.com")
+	beach := env.gallery(t, alice, "Beach")
+	first := env.upload(t, beach, jpegBytes(t, 400, 300, 1))
+	used := env.storageUsed(t, alice)
+
+	quota := planQuotas[PlanFree]
+	t.Cleanup(func() { planQuotas[PlanFree] = quota })
+	planQuotas[PlanFree] = used
+
+	// another upload took the space after this one's first check
+	image := Image{GalleryID: beach.ID, Filename: "photo.jpg", Key: first.Key, Size: first.Size, Width: first.Width}
+	if err := env.Image.Create(&image); err != ErrQuotaExceeded {
+		t.Fatalf("Create past the quota = %v, want ErrQuotaExceeded", err)
+	}
+	if got := env.storageUsed(t, alice); got != used {
+		t.Fatalf("usage went from %d to %d on a refused image", used, got)
+	}
+	if got := env.imageRows(t, beach.ID); got != 1 {
+		t.Fatalf("%d image rows after a refused image, want 1", got)
+	}
+
+	planQuotas[PlanFree] = 2 * used
+	if err := env.Image.Create(&image); err != nil {
+		t.Fatalf("Create within the quota = %v", err)
+	}
+	if got := env.storageUsed(t, alice); got != 2*used {
+		t.Fatalf("usage is %d, want %d", got, 2*used)
+	}
+}
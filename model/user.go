@@ -4,12 +4,14 @@ import (
 	"regexp"
 	"strings"
 
+	"github.com/jhampac/picha/cache"
 	"github.com/jhampac/picha/hash"
 	"github.com/jhampac/picha/rand"
 	"github.com/jinzhu/gorm"
 
-	// driver for postgres gorm
+	// drivers for postgres and, in development, sqlite gorm
 	_ "github.com/jinzhu/gorm/dialects/postgres"
+	_ "github.com/jinzhu/gorm/dialects/sqlite"
 	"golang.org/x/crypto/bcrypt"
 )
 
@@ -79,6 +81,9 @@ type UserDB interface {
 	Create(user *User) error
 	Update(user *User) error
 	Delete(id uint) error
+
+	// UpdateLocale saves only the user's language preference
+	UpdateLocale(user *User, locale string) error
 }
 
 // User represents our customers
@@ -90,6 +95,14 @@ type User struct {
 	PasswordHash string `gorm:"not null"`
 	Remember     string `gorm:"-"`
 	RememberHash string `gorm:"not null;unique_index"`
+
+	// Locale is the preferred UI language, e.g. "fr"; empty means detect it per request
+	Locale string
+
+	// Plan sets the storage quota; StorageUsed is the bytes of originals and
+	// variants in the user's galleries, kept by the image queries
+	Plan        Plan  `gorm:"not null;default:'free'"`
+	StorageUsed int64 `gorm:"not null;default:0"`
 }
 
 // userService implements the UserService interface
@@ -117,13 +130,17 @@ func newUserValidator(orm UserDB, hmac hash.HMAC) *userValidator {
 	}
 }
 
-// NewUserService instantiates a new service with the provided connection information
-func NewUserService(db *gorm.DB) UserService {
-	ug := &userGorm{db}
+// NewUserService instantiates a new service with the provided connection
+// information; users found by remember token are kept in c unless it is nil
+func NewUserService(db *gorm.DB, c cache.Cache) UserService {
+	var ug UserDB = &userGorm{db}
+	if c != nil {
+		ug = &userCache{UserDB: ug, cache: c}
+	}
 	hmac := hash.NewHMAC(hmacSecretKey)
 	uv := newUserValidator(ug, hmac)
 
-	// interface chaining; validator first then to the gorm/db layer
+	// interface chaining; validator first then to the cache and gorm/db layers
 	return &userService{
 		UserDB: uv,
 	}
@@ -151,8 +168,6 @@ func (uv *userValidator) Create(user *User) error {
 	err := runUserValFns(user,
 		uv.passwordRequired,
 		uv.passwordMinLength,
-		uv.bcryptPassword,
-		uv.passwordHashRequired,
 		uv.setRememberIfUnset,
 		uv.rememberMinBytes,
 		uv.hmacRemember,
@@ -160,8 +175,11 @@ func (uv *userValidator) Create(user *User) error {
 		uv.requireEmail,
 		uv.normalizeEmail,
 		uv.emailFormat,
-		uv.emailIsAvail)
-
+		uv.setPlanIfUnset,
+		uv.planValid)
+	if err == nil {
+		err = uv.costlyValidations(user)
+	}
 	if err != nil {
 		return err
 	}
@@ -233,16 +251,16 @@ func (ug *userGorm) ByRemember(rememberHash string) (*User, error) {
 func (uv *userValidator) Update(user *User) error {
 	err := runUserValFns(user,
 		uv.passwordMinLength,
-		uv.bcryptPassword,
-		uv.passwordHashRequired,
 		uv.rememberMinBytes,
 		uv.hmacRemember,
 		uv.rememberHashRequired,
 		uv.requireEmail,
 		uv.normalizeEmail,
 		uv.emailFormat,
-		uv.emailIsAvail)
-
+		uv.planValid)
+	if err == nil {
+		err = uv.costlyValidations(user)
+	}
 	if err != nil {
 		return err
 	}
@@ -250,9 +268,16 @@ func (uv *userValidator) Update(user *User) error {
 	return uv.UserDB.Update(user)
 }
 
-// Update will update the provided user with all of the data in the provided user object from the validation layer
+// Update will update the provided user with all of the data in the provided user object from the validation layer;
+// storage usage is left out since only the image queries change it
 func (ug *userGorm) Update(user *User) error {
-	return ug.db.Save(user).Error
+	return ug.db.Omit("storage_used").Save(user).Error
+}
+
+// UpdateLocale writes the one column, leaving whatever else changed since the user was loaded
+func (ug *userGorm) UpdateLocale(user *User, locale string) error {
+	user.Locale = locale
+	return ug.db.Model(&User{}).Where("id = ?", user.ID).UpdateColumn("locale", locale).Error
 }
 
 // Delete validate the ID first then pass it to the next in chain
@@ -284,13 +309,28 @@ type userValFn func(*User) error
 
 // this is a lot like the functional programing I am used to in JavaScript
 // it is like pipe or flow. (...fns) => (x) => fns.reduce()
+// Field errors are collected into a ValidationError so every invalid field is
+// reported at once; any other error stops the chain.
 func runUserValFns(user *User, fns ...userValFn) error {
+	var verr ValidationError
 	for _, fn := range fns {
-		if err := fn(user); err != nil {
+		if err := fn(user); err != nil && !verr.add(err) {
 			return err
 		}
 	}
-	return nil
+	return verr.err()
+}
+
+// costlyValidations query the database and hash the password, so they only
+// run once the user passed every other check; the e-mail is looked up first
+// since a taken one makes hashing pointless
+func (uv *userValidator) costlyValidations(user *User) error {
+	if err := runUserValFns(user, uv.emailIsAvail); err != nil {
+		return err
+	}
+	return runUserValFns(user,
+		uv.bcryptPassword,
+		uv.passwordHashRequired)
 }
 
 func (uv *userValidator) bcryptPassword(user *User) error {
@@ -338,6 +378,20 @@ func (uv *userValidator) idGreaterThan(n uint) userValFn {
 	return fn
 }
 
+func (uv *userValidator) setPlanIfUnset(user *User) error {
+	if user.Plan == "" {
+		user.Plan = PlanFree
+	}
+	return nil
+}
+
+func (uv *userValidator) planValid(user *User) error {
+	if !user.Plan.Valid() {
+		return ErrPlanInvalid
+	}
+	return nil
+}
+
 func (uv *userValidator) normalizeEmail(user *User) error {
 	user.Email = strings.ToLower(user.Email)
 	user.Email = strings.TrimSpace(user.Email)
@@ -0,0 +1,109 @@
+package model
+
+import "strings"
+
+// errFieldInvalid is the public summary of a ValidationError
+const errFieldInvalid modelError = "model: please correct the errors below"
+
+// fieldErrors maps each validation error to the form field it is about. Errors
+// missing from here are not about user input and stop validation right away.
+var fieldErrors = map[modelError]string{
+	ErrEmailRequired:     "email",
+	ErrEmailInvalid:      "email",
+	ErrEmailTaken:        "email",
+	ErrPasswordRequired:  "password",
+	ErrPasswordTooShort:  "password",
+	ErrPasswordIncorrect: "password",
+	ErrTitleRequired:     "title",
+	ErrImageTitleTooLong: "title",
+	ErrCaptionTooLong:    "caption",
+	ErrAltTooLong:        "alt",
+	ErrVisibilityInvalid: "visibility",
+	ErrTagInvalid:        "tags",
+	ErrTooManyTags:       "tags",
+	ErrRoleInvalid:       "role",
+	ErrInviteSelf:        "email",
+
+	ErrShareExpiryRequired: "expires_on",
+	ErrShareExpiryPast:     "expires_on",
+	ErrMaxViewsInvalid:     "max_views",
+}
+
+// FieldError is a validation failure of a single field; Code is the underlying model error
+type FieldError struct {
+	Field string
+	Code  modelError
+}
+
+// ValidationError collects the first failure of every invalid field
+type ValidationError struct {
+	Errors []FieldError
+}
+
+func (e ValidationError) Error() string {
+	msgs := make([]string, len(e.Errors))
+	for i, fe := range e.Errors {
+		msgs[i] = fe.Field + ": " + string(fe.Code)
+	}
+	return "model: validation failed: " + strings.Join(msgs, "; ")
+}
+
+// Public implements the PublicError interface in the view package
+func (e ValidationError) Public() string {
+	if len(e.Errors) == 1 {
+		return e.Errors[0].Code.Public()
+	}
+	return errFieldInvalid.Public()
+}
+
+// FieldErrors returns the public message for each invalid field, keyed by form field name
+func (e ValidationError) FieldErrors() map[string]string {
+	m := make(map[string]string, len(e.Errors))
+	for _, fe := range e.Errors {
+		m[fe.Field] = fe.Code.Public()
+	}
+	return m
+}
+
+// Has reports whether code is one of the collected errors
+func (e ValidationError) Has(code modelError) bool {
+	for _, fe := range e.Errors {
+		if fe.Code == code {
+			return true
+		}
+	}
+	return false
+}
+
+// add records err if it is a field error and reports whether it was one;
+// only the first error of each field is kept
+func (e *ValidationError) add(err error) bool {
+	code, ok := err.(modelError)
+	if !ok {
+		return false
+	}
+	field, ok := fieldErrors[code]
+	if !ok {
+		return false
+	}
+	for _, fe := range e.Errors {
+		if fe.Field == field {
+			return true
+		}
+	}
+	e.Errors = append(e.Errors, FieldError{Field: field, Code: code})
+	return true
+}
+
+// err returns nil when nothing was collected so callers can keep comparing against nil
+func (e *ValidationError) err() error {
+	if len(e.Errors) == 0 {
+		return nil
+	}
+	return *e
+}
+
+// NewValidationError reports code as a failure of field, e.g. for checks done outside a validator
+func NewValidationError(field string, code modelError) ValidationError {
+	return ValidationError{Errors: []FieldError{{Field: field, Code: code}}}
+}
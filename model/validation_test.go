@@ -0,0 +1,100 @@
+package model
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/jhampac/picha/hash"
+)
+
+func TestValidationErrorCollectsTheFirstErrorOfEachField(t *testing.T) {
+	var verr ValidationError
+	if verr.err() != nil {
+		t.Fatal("err() of an empty ValidationError is not nil")
+	}
+	for _, err := range []error{ErrEmailInvalid, ErrPasswordTooShort, ErrEmailTaken} {
+		if !verr.add(err) {
+			t.Fatalf("add(%v) = false, want a field error", err)
+		}
+	}
+	for _, err := range []error{ErrNotFound, errors.New("connection reset")} {
+		if verr.add(err) {
+			t.Fatalf("add(%v) = true, want it to stop validation", err)
+		}
+	}
+
+	want := []FieldError{{Field: "email", Code: ErrEmailInvalid}, {Field: "password", Code: ErrPasswordTooShort}}
+	if len(verr.Errors) != len(want) {
+		t.Fatalf("collected %v, want %v", verr.Errors, want)
+	}
+	for i := range want {
+		if verr.Errors[i] != want[i] {
+			t.Fatalf("collected %v, want %v", verr.Errors, want)
+		}
+	}
+	if verr.Has(ErrEmailTaken) || !verr.Has(ErrEmailInvalid) {
+		t.Fatalf("Has reports %v wrongly", verr.Errors)
+	}
+	fields := verr.FieldErrors()
+	if fields["email"] != ErrEmailInvalid.Public() || fields["password"] != ErrPasswordTooShort.Public() {
+		t.Fatalf("FieldErrors = %v", fields)
+	}
+	if verr.Public() != errFieldInvalid.Public() {
+		t.Fatalf("Public of two errors = %q, want %q", verr.Public(), errFieldInvalid.Public())
+	}
+	if one := NewValidationError("email", ErrEmailTaken); one.Public() != ErrEmailTaken.Public() {
+		t.Fatalf("Public of one error = %q, want %q", one.Public(), ErrEmailTaken.Public())
+	}
+}
+
+func TestUserValidationReportsEveryInvalidField(t *testing.T) {
+	env := newTestEnv(t)
+	err := env.User.Create(&User{Email: "not an email", Password: "short"})
+	verr, ok := err.(ValidationError)
+	if !ok || !verr.Has(ErrEmailInvalid) || !verr.Has(ErrPasswordTooShort) || len(verr.Errors) != 2 {
+		t.Fatalf("Create = %v, want the e-mail and password errors", err)
+	}
+}
+
+// countingUserDB counts the lookups by e-mail done by emailIsAvail
+type countingUserDB struct {
+	UserDB
+	byEmail int
+}
+
+func (c *countingUserDB) ByEmail(email string) (*User, error) {
+	c.byEmail++
+	return c.UserDB.ByEmail(email)
+}
+
+func TestUserValidationSkipsCostlyChecksOfInvalidUsers(t *testing.T) {
+	env := newTestEnv(t)
+	env.user(t, "alice@example.com")
+	db := &countingUserDB{UserDB: &userGorm{env.db}}
+	uv := newUserValidator(db, hash.NewHMAC(hmacSecretKey))
+
+	invalid := User{Email: "not an email", Password: "password123"}
+	if err := uv.Create(&invalid); err == nil {
+		t.Fatal("created a user with an invalid e-mail")
+	}
+	if db.byEmail != 0 || invalid.PasswordHash != "" {
+		t.Fatalf("looked the e-mail up %d times and hashed the password to %q for an invalid user", db.byEmail, invalid.PasswordHash)
+	}
+
+	taken := User{Email: "alice@example.com", Password: "password123"}
+	err := uv.Create(&taken)
+	if verr, ok := err.(ValidationError); !ok || !verr.Has(ErrEmailTaken) {
+		t.Fatalf("Create with a taken e-mail = %v, want ErrEmailTaken", err)
+	}
+	if db.byEmail != 1 || taken.PasswordHash != "" {
+		t.Fatalf("looked the e-mail up %d times and hashed the password to %q for a taken e-mail", db.byEmail, taken.PasswordHash)
+	}
+
+	valid := User{Email: "bob@example.com", Password: "password123"}
+	if err := uv.Create(&valid); err != nil {
+		t.Fatal(err)
+	}
+	if valid.PasswordHash == "" {
+		t.Fatal("the password of a valid user was not hashed")
+	}
+}
@@ -40,3 +40,11 @@ func NBytes(base64String string) (int, error) {
 	}
 	return len(b), nil
 }
+
+// RequestIDBytes is the size of the ids attached to each request
+const RequestIDBytes = 12
+
+// RequestID generates an id used to correlate log lines for one request
+func RequestID() (string, error) {
+	return String(RequestIDBytes)
+}
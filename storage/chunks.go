@@ -0,0 +1,75 @@
+package storage
+
+import (
+	"errors"
+	"io"
+	"os"
+)
+
+// ErrOffsetMismatch is returned when a chunk does not start where the file ends
+var ErrOffsetMismatch = errors.New("storage: offset does not match the stored size")
+
+// Chunks keeps files that are written piece by piece, such as resumable
+// uploads, in a directory on the local filesystem
+type Chunks struct {
+	disk Disk
+}
+
+// NewChunks creates the root directory if needed and returns a *Chunks store
+func NewChunks(root string) (*Chunks, error) {
+	if err := os.MkdirAll(root, 0755); err != nil {
+		return nil, err
+	}
+	return &Chunks{disk: Disk{Root: root}}, nil
+}
+
+// Append writes r to the end of key, which must currently be offset bytes
+// long, and returns how many bytes were written. Whatever was written before
+// r failed stays, so a client can resume after it. Appends to one key must
+// not overlap; callers hold a lock on it meanwhile.
+func (c *Chunks) Append(key string, offset int64, r io.Reader) (int64, error) {
+	path, err := c.disk.path(key)
+	if err != nil {
+		return 0, err
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE, 0644)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return 0, err
+	}
+	if info.Size() != offset {
+		return 0, ErrOffsetMismatch
+	}
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		return 0, err
+	}
+	n, err := io.Copy(f, r)
+	if cerr := f.Sync(); err == nil {
+		err = cerr
+	}
+	return n, err
+}
+
+// Truncate cuts key back to size bytes
+func (c *Chunks) Truncate(key string, size int64) error {
+	path, err := c.disk.path(key)
+	if err != nil {
+		return err
+	}
+	return os.Truncate(path, size)
+}
+
+// Open returns a reader for key; the caller must close it
+func (c *Chunks) Open(key string) (io.ReadCloser, error) {
+	return c.disk.Open(key)
+}
+
+// Delete removes key; deleting a missing key is not an error
+func (c *Chunks) Delete(key string) error {
+	return c.disk.Delete(key)
+}
@@ -0,0 +1,128 @@
+package storage
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ErrInvalidKey is returned for keys that are empty or would escape the store
+var ErrInvalidKey = errors.New("storage: invalid key")
+
+// Store persists file contents under slash separated keys such as "galleries/1/cat.jpg"
+type Store interface {
+	Put(key string, r io.Reader) (int64, error)
+	Open(key string) (io.ReadCloser, error)
+	Delete(key string) error
+
+	// Size returns how many bytes key takes up; a missing key is an fs.ErrNotExist error
+	Size(key string) (int64, error)
+
+	// Check returns an error if the store cannot currently be written to
+	Check() error
+}
+
+// Disk is a Store rooted at a directory on the local filesystem
+type Disk struct {
+	Root string
+}
+
+// NewDisk creates the root directory if needed and returns a *Disk store
+func NewDisk(root string) (*Disk, error) {
+	if err := os.MkdirAll(root, 0755); err != nil {
+		return nil, err
+	}
+	return &Disk{Root: root}, nil
+}
+
+// Put writes the contents of r to key, replacing any existing file
+func (d *Disk) Put(key string, r io.Reader) (int64, error) {
+	path, err := d.path(key)
+	if err != nil {
+		return 0, err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return 0, err
+	}
+
+	// write to a temp file first so readers never see a partial file
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".put-*")
+	if err != nil {
+		return 0, err
+	}
+	n, err := io.Copy(tmp, r)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return 0, err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		os.Remove(tmp.Name())
+		return 0, err
+	}
+	return n, nil
+}
+
+// Open returns a reader for key; the caller must close it
+func (d *Disk) Open(key string) (io.ReadCloser, error) {
+	path, err := d.path(key)
+	if err != nil {
+		return nil, err
+	}
+	return os.Open(path)
+}
+
+// Delete removes key; deleting a missing key is not an error
+func (d *Disk) Delete(key string) error {
+	path, err := d.path(key)
+	if err != nil {
+		return err
+	}
+	err = os.Remove(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+// Size stats the file of key
+func (d *Disk) Size(key string) (int64, error) {
+	path, err := d.path(key)
+	if err != nil {
+		return 0, err
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return 0, err
+	}
+	return info.Size(), nil
+}
+
+// Check writes and removes a probe file in the root directory
+func (d *Disk) Check() error {
+	f, err := os.CreateTemp(d.Root, ".check-*")
+	if err != nil {
+		return err
+	}
+	name := f.Name()
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Remove(name)
+}
+
+// path maps a key to a file below Root, rejecting anything that escapes it
+func (d *Disk) path(key string) (string, error) {
+	if key == "" || strings.Contains(key, "\\") {
+		return "", ErrInvalidKey
+	}
+	clean := filepath.Clean(filepath.FromSlash(key))
+	if filepath.IsAbs(clean) || clean == ".." || strings.HasPrefix(clean, ".."+string(filepath.Separator)) {
+		return "", ErrInvalidKey
+	}
+	return filepath.Join(d.Root, clean), nil
+}
@@ -0,0 +1,104 @@
+package view
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io/fs"
+	"net/http"
+	"path"
+	"strings"
+	"sync"
+)
+
+// assetHashLen is how many hex characters of the SHA-256 go into a filename
+const assetHashLen = 12
+
+// assets backs the "asset" template func once SetAssets is called
+var assets *Assets
+
+// SetAssets makes templates link to a through {{asset "css/app.css"}}
+func SetAssets(a *Assets) {
+	assets = a
+}
+
+func assetPath(name string) string {
+	if assets == nil {
+		return name
+	}
+	return assets.Path(name)
+}
+
+// Assets serves the files in a directory under content-hashed names, so they
+// can be cached forever: css/app.css is linked as /assets/css/app.3f2a9c01d4e7.css
+type Assets struct {
+	fsys   fs.FS
+	prefix string
+
+	mu     sync.Mutex
+	hashes map[string]string
+}
+
+// NewAssets serves the files of dir within fsys below the URL prefix, e.g. "/assets/"
+func NewAssets(fsys fs.FS, dir, prefix string) (*Assets, error) {
+	sub, err := fs.Sub(fsys, dir)
+	if err != nil {
+		return nil, err
+	}
+	return &Assets{
+		fsys:   sub,
+		prefix: prefix,
+		hashes: make(map[string]string),
+	}, nil
+}
+
+// Path returns the URL of the asset called name; unknown assets are linked unhashed and will 404
+func (a *Assets) Path(name string) string {
+	name = strings.TrimPrefix(name, "/")
+	sum, err := a.hash(name)
+	if err != nil {
+		return a.prefix + name
+	}
+	ext := path.Ext(name)
+	return a.prefix + strings.TrimSuffix(name, ext) + "." + sum + ext
+}
+
+// hash caches content hashes, except in Reload mode where files change underneath us
+func (a *Assets) hash(name string) (string, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if sum, ok := a.hashes[name]; ok && !Reload {
+		return sum, nil
+	}
+
+	b, err := fs.ReadFile(a.fsys, name)
+	if err != nil {
+		return "", err
+	}
+	h := sha256.Sum256(b)
+	sum := hex.EncodeToString(h[:])[:assetHashLen]
+	a.hashes[name] = sum
+	return sum, nil
+}
+
+// ServeHTTP serves hashed asset URLs with long-lived cache headers. Requests
+// whose hash does not match the current content are not found.
+func (a *Assets) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	hashed := strings.TrimPrefix(r.URL.Path, a.prefix)
+	ext := path.Ext(hashed)
+	base := strings.TrimSuffix(hashed, ext)
+	dot := strings.LastIndex(base, ".")
+	if dot < 0 {
+		http.NotFound(w, r)
+		return
+	}
+	name, sum := base[:dot]+ext, base[dot+1:]
+
+	current, err := a.hash(name)
+	if err != nil || current != sum {
+		http.NotFound(w, r)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+	http.ServeFileFS(w, r, a.fsys, name)
+}
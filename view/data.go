@@ -1,6 +1,6 @@
 package view
 
-import "log"
+import "log/slog"
 
 // PublicError is used to distinguish between user and system errors
 type PublicError interface {
@@ -8,10 +8,21 @@ type PublicError interface {
 	Public() string
 }
 
+// FieldErrorer is implemented by errors that carry a public message per form field
+type FieldErrorer interface {
+	FieldErrors() map[string]string
+}
+
 // Data is the top level structure that views expect for data
 type Data struct {
 	Alert *Alert
 	Yield interface{}
+
+	// Flashes are the alerts queued by Flash before a redirect; Render fills them in
+	Flashes []Alert
+
+	// Fields holds an error message per form field, shown by the "field" partial
+	Fields map[string]string
 }
 
 // SetAlert sets the Alert field on Data
@@ -20,8 +31,13 @@ func (d *Data) SetAlert(err error) {
 
 	if pErr, ok := err.(PublicError); ok {
 		msg = pErr.Public()
+		if fErr, ok := err.(FieldErrorer); ok {
+			for field, fieldMsg := range fErr.FieldErrors() {
+				d.AddFieldError(field, fieldMsg)
+			}
+		}
 	} else {
-		log.Println(err)
+		slog.Error("rendering generic alert", "error", err)
 		msg = AlertMsgGeneric
 	}
 	d.Alert = &Alert{
@@ -30,6 +46,14 @@ func (d *Data) SetAlert(err error) {
 	}
 }
 
+// AlertSuccess provides a method to create success messages
+func (d *Data) AlertSuccess(msg string) {
+	d.Alert = &Alert{
+		Level:   AlertLvlSuccess,
+		Message: msg,
+	}
+}
+
 // AlertError provides a method to create custom alert messages
 func (d *Data) AlertError(msg string) {
 	d.Alert = &Alert{
@@ -37,3 +61,11 @@ func (d *Data) AlertError(msg string) {
 		Message: msg,
 	}
 }
+
+// AddFieldError shows msg next to the form field called field
+func (d *Data) AddFieldError(field, msg string) {
+	if d.Fields == nil {
+		d.Fields = make(map[string]string)
+	}
+	d.Fields[field] = msg
+}
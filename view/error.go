@@ -0,0 +1,55 @@
+package view
+
+import (
+	"log/slog"
+	"net/http"
+	"sync"
+
+	"github.com/jhampac/picha/context"
+)
+
+// ErrorData is yielded to the error page templates
+type ErrorData struct {
+	Status    int
+	Title     string
+	Message   string
+	RequestID string
+}
+
+var (
+	errorOnce sync.Once
+	errorView *View
+)
+
+// Error replies to the request with a styled error page. It mirrors
+// http.Error and falls back to it if the error template cannot be parsed.
+func Error(w http.ResponseWriter, r *http.Request, msg string, code int) {
+	errorOnce.Do(func() {
+		v, err := parse("appcontainer", "static/error")
+		if err != nil {
+			slog.Error("parsing error page", "error", err)
+		}
+		// in Reload mode a broken template can still be fixed while running
+		if err == nil || Reload {
+			errorView = v
+		}
+	})
+	if errorView == nil {
+		http.Error(w, msg, code)
+		return
+	}
+
+	errorView.RenderStatus(w, r, code, Data{
+		Yield: NewErrorData(r, msg, code),
+	})
+}
+
+// NewErrorData fills in the title for code and the ID of the request
+func NewErrorData(r *http.Request, msg string, code int) ErrorData {
+	return ErrorData{
+		Status:    code,
+		Title:     http.StatusText(code),
+		Message:   msg,
+		RequestID: context.RequestID(r.Context()),
+	}
+}
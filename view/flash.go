@@ -0,0 +1,140 @@
+package view
+
+import (
+	"crypto/hmac"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jhampac/picha/hash"
+)
+
+const (
+	flashCookie = "flash"
+
+	// maxFlashes keeps the cookie well below browser size limits
+	maxFlashes = 5
+
+	// flashTTL is how long queued alerts wait for the next page; the expiry
+	// is signed too, so a flash cookie replayed later shows nothing
+	flashTTL = 5 * time.Minute
+)
+
+// flashKey signs the flash cookie so alerts cannot be forged by other sites
+// or users; it is set from the config by SetFlashKey
+var flashKey string
+
+// SetFlashKey sets the HMAC key used to sign flash cookies
+func SetFlashKey(key string) {
+	flashKey = key
+}
+
+// Flash queues alerts to be shown on the next rendered page, typically after a redirect
+func Flash(w http.ResponseWriter, r *http.Request, alerts ...Alert) {
+	queued := append(pendingFlashes(w, r), alerts...)
+	if len(queued) > maxFlashes {
+		queued = queued[len(queued)-maxFlashes:]
+	}
+
+	b, err := json.Marshal(queued)
+	if err != nil {
+		return
+	}
+	expires := time.Now().Add(flashTTL)
+	payload := base64.URLEncoding.EncodeToString(b) + "." + strconv.FormatInt(expires.Unix(), 10)
+	http.SetCookie(w, &http.Cookie{
+		Name:     flashCookie,
+		Value:    payload + "." + signFlash(payload),
+		Path:     "/",
+		Expires:  expires,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// RedirectAlert queues alert and redirects to urlStr
+func RedirectAlert(w http.ResponseWriter, r *http.Request, urlStr string, code int, alert Alert) {
+	Flash(w, r, alert)
+	http.Redirect(w, r, urlStr, code)
+}
+
+// popFlashes returns the queued alerts and clears the cookie
+func popFlashes(w http.ResponseWriter, r *http.Request) []Alert {
+	alerts := readFlashes(r)
+	if _, err := r.Cookie(flashCookie); err == nil {
+		http.SetCookie(w, &http.Cookie{
+			Name:     flashCookie,
+			Path:     "/",
+			MaxAge:   -1,
+			HttpOnly: true,
+		})
+	}
+	return alerts
+}
+
+// pendingFlashes returns the alerts queued so far, including those of an
+// earlier Flash call in this response, and drops that call's cookie so only
+// one flash cookie is sent.
+func pendingFlashes(w http.ResponseWriter, r *http.Request) []Alert {
+	header := w.Header()
+	var kept []string
+	var pending *http.Cookie
+	for _, line := range header.Values("Set-Cookie") {
+		if c, err := http.ParseSetCookie(line); err == nil && c.Name == flashCookie {
+			pending = c
+			continue
+		}
+		kept = append(kept, line)
+	}
+	if pending == nil {
+		return readFlashes(r)
+	}
+	header.Del("Set-Cookie")
+	for _, line := range kept {
+		header.Add("Set-Cookie", line)
+	}
+	return decodeFlashes(pending)
+}
+
+// readFlashes decodes the flash cookie of the request
+func readFlashes(r *http.Request) []Alert {
+	cookie, err := r.Cookie(flashCookie)
+	if err != nil {
+		return nil
+	}
+	return decodeFlashes(cookie)
+}
+
+// decodeFlashes ignores cookies that were tampered with or expired
+func decodeFlashes(cookie *http.Cookie) []Alert {
+	i := strings.LastIndex(cookie.Value, ".")
+	if i < 0 {
+		return nil
+	}
+	payload, sig := cookie.Value[:i], cookie.Value[i+1:]
+	if !hmac.Equal([]byte(sig), []byte(signFlash(payload))) {
+		return nil
+	}
+	encoded, expires, _ := strings.Cut(payload, ".")
+	unix, err := strconv.ParseInt(expires, 10, 64)
+	if err != nil || time.Now().Unix() >= unix {
+		return nil
+	}
+	b, err := base64.URLEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil
+	}
+	var alerts []Alert
+	if err := json.Unmarshal(b, &alerts); err != nil {
+		return nil
+	}
+	return alerts
+}
+
+// signFlash builds a new HMAC per call since hash.HMAC is not safe for concurrent use
+func signFlash(payload string) string {
+	return hash.NewHMAC(flashKey).Hash(payload)
+}
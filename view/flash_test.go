@@ -0,0 +1,84 @@
+package view
+
+import (
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func init() {
+	SetFlashKey("test-flash-key")
+}
+
+// flashCookieOf queues alerts the way a handler does and returns the cookie sent
+func flashCookieOf(t *testing.T, alerts ...Alert) *http.Cookie {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	Flash(rec, httptest.NewRequest("GET", "/", nil), alerts...)
+	for _, c := range rec.Result().Cookies() {
+		if c.Name == flashCookie {
+			return c
+		}
+	}
+	t.Fatal("Flash set no cookie")
+	return nil
+}
+
+func readFlashCookie(value string) []Alert {
+	r := httptest.NewRequest("GET", "/", nil)
+	r.AddCookie(&http.Cookie{Name: flashCookie, Value: value})
+	return readFlashes(r)
+}
+
+func TestFlashRoundTrip(t *testing.T) {
+	saved := Alert{Level: AlertLvlSuccess, Message: "Gallery saved"}
+	cookie := flashCookieOf(t, saved)
+	if !cookie.Expires.After(time.Now()) || cookie.Expires.After(time.Now().Add(flashTTL)) {
+		t.Errorf("cookie expires at %v, want within %v", cookie.Expires, flashTTL)
+	}
+	alerts := readFlashCookie(cookie.Value)
+	if len(alerts) != 1 || alerts[0].Message != saved.Message || alerts[0].Level != saved.Level {
+		t.Fatalf("read %+v, want %+v", alerts, saved)
+	}
+}
+
+func TestFlashIgnoresForgedCookies(t *testing.T) {
+	value := flashCookieOf(t, Alert{Level: AlertLvlSuccess, Message: "Gallery saved"}).Value
+	forged := flashCookieOf(t, Alert{Level: AlertLvlError, Message: "Your account was suspended"}).Value
+	i := strings.LastIndex(value, ".")
+	payload, sig := value[:i], value[i+1:]
+
+	expired := base64.URLEncoding.EncodeToString([]byte(`[{"Level":"success","Message":"Gallery saved"}]`)) +
+		"." + strconv.FormatInt(time.Now().Add(-time.Second).Unix(), 10)
+
+	tests := []struct {
+		name  string
+		value string
+	}{
+		{"unsigned", strings.SplitN(payload, ".", 2)[0]},
+		{"empty signature", payload + "."},
+		{"no expiry", strings.SplitN(payload, ".", 2)[0] + "." + signFlash(strings.SplitN(payload, ".", 2)[0])},
+		{"signature of another cookie", forged[:strings.LastIndex(forged, ".")] + "." + sig},
+		{"payload changed", "W10" + payload[3:] + "." + sig},
+		{"expiry moved", strings.SplitN(payload, ".", 2)[0] + ".9999999999." + sig},
+		{"expired", expired + "." + signFlash(expired)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if alerts := readFlashCookie(tt.value); alerts != nil {
+				t.Fatalf("read %+v from a forged cookie", alerts)
+			}
+		})
+	}
+
+	// the same cookie is not accepted under another key
+	SetFlashKey("another-key")
+	defer SetFlashKey("test-flash-key")
+	if alerts := readFlashCookie(value); alerts != nil {
+		t.Fatalf("read %+v from a cookie signed with another key", alerts)
+	}
+}
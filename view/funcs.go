@@ -0,0 +1,209 @@
+package view
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"html/template"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/csrf"
+	"github.com/gorilla/mux"
+	"github.com/jhampac/picha/context"
+	"github.com/jhampac/picha/i18n"
+	"github.com/microcosm-cc/bluemonday"
+	"github.com/yuin/goldmark"
+)
+
+// funcMap is registered on every view before its files are parsed. Funcs
+// that depend on the request are placeholders replaced in Render.
+var funcMap = template.FuncMap{
+	"asset":     assetPath,
+	"urlFor":    urlFor,
+	"timeAgo":   timeAgo(i18n.Default),
+	"bytes":     byteSize(i18n.Default),
+	"pluralize": pluralize,
+	"srcset":    srcset,
+	"markdown":  markdown,
+	"dict":      dict,
+	"csrfField": func() (template.HTML, error) {
+		return "", errors.New("view: csrfField is only available while rendering")
+	},
+	"t": func(msg string, args ...interface{}) string {
+		return i18n.T(i18n.Default, msg, args...)
+	},
+	"locale": func() string {
+		return i18n.Default
+	},
+	"fieldError": func(field string) string {
+		return ""
+	},
+}
+
+// requestFuncs binds the request and data dependent funcs for one render
+func requestFuncs(r *http.Request, vd Data) template.FuncMap {
+	locale := context.Locale(r.Context())
+	return template.FuncMap{
+		"csrfField": func() template.HTML {
+			return csrf.TemplateField(r)
+		},
+		"t": func(msg string, args ...interface{}) string {
+			return i18n.T(locale, msg, args...)
+		},
+		"locale": func() string {
+			return locale
+		},
+		"timeAgo": timeAgo(locale),
+		"bytes":   byteSize(locale),
+		"fieldError": func(field string) string {
+			return vd.Fields[field]
+		},
+	}
+}
+
+// router backs urlFor once SetRouter is called
+var router *mux.Router
+
+// SetRouter lets templates build URLs from route names with {{urlFor "show_gallery" .ID}}
+func SetRouter(r *mux.Router) {
+	router = r
+}
+
+// urlFor fills the route's variables in the order they appear in its path
+func urlFor(name string, args ...interface{}) (string, error) {
+	if router == nil {
+		return "", errors.New("view: urlFor used before SetRouter")
+	}
+	route := router.Get(name)
+	if route == nil {
+		return "", fmt.Errorf("view: no route named %q", name)
+	}
+	vars, err := route.GetVarNames()
+	if err != nil {
+		return "", err
+	}
+	if len(args) != len(vars) {
+		return "", fmt.Errorf("view: route %q takes %d arguments, got %d", name, len(vars), len(args))
+	}
+
+	pairs := make([]string, 0, 2*len(vars))
+	for i, v := range vars {
+		pairs = append(pairs, v, fmt.Sprint(args[i]))
+	}
+	u, err := route.URL(pairs...)
+	if err != nil {
+		return "", err
+	}
+	return u.String(), nil
+}
+
+// timeAgo formats times relative to now in locale, e.g. "just now", "5 minutes ago" or "2006-01-02" past a month
+func timeAgo(locale string) func(time.Time) string {
+	ago := func(n int, singular, plural string) string {
+		if n == 1 {
+			return i18n.T(locale, singular, n)
+		}
+		return i18n.T(locale, plural, n)
+	}
+	return func(t time.Time) string {
+		d := time.Since(t)
+		switch {
+		case d < time.Minute:
+			return i18n.T(locale, "just now")
+		case d < time.Hour:
+			return ago(int(d/time.Minute), "%d minute ago", "%d minutes ago")
+		case d < 24*time.Hour:
+			return ago(int(d/time.Hour), "%d hour ago", "%d hours ago")
+		case d < 30*24*time.Hour:
+			return ago(int(d/(24*time.Hour)), "%d day ago", "%d days ago")
+		default:
+			return t.Format("2006-01-02")
+		}
+	}
+}
+
+// byteSize formats a number of bytes in locale with the largest unit that fits, e.g. "1.5 GB"
+func byteSize(locale string) func(int64) string {
+	units := []struct {
+		size int64
+		msg  string
+	}{
+		{1 << 30, "%s GB"},
+		{1 << 20, "%s MB"},
+		{1 << 10, "%s KB"},
+	}
+	return func(n int64) string {
+		for _, u := range units {
+			if n >= u.size {
+				return i18n.T(locale, u.msg, strconv.FormatFloat(float64(n)/float64(u.size), 'f', 1, 64))
+			}
+		}
+		return i18n.T(locale, "%d bytes", n)
+	}
+}
+
+// pluralize returns e.g. "1 image" or "3 images"
+func pluralize(n int, singular, plural string) string {
+	if n == 1 {
+		return fmt.Sprintf("%d %s", n, singular)
+	}
+	return fmt.Sprintf("%d %s", n, plural)
+}
+
+// srcset builds an img srcset attribute with one ?w= candidate per width.
+// Widths are ints or []int, e.g. {{srcset $url .SrcsetWidths}}. It returns
+// a plain string so html/template still filters each URL.
+func srcset(url string, widths ...interface{}) (string, error) {
+	sep := "?"
+	if strings.Contains(url, "?") {
+		sep = "&"
+	}
+	var all []int
+	for _, w := range widths {
+		switch w := w.(type) {
+		case int:
+			all = append(all, w)
+		case []int:
+			all = append(all, w...)
+		default:
+			return "", fmt.Errorf("view: srcset width %v is not an int", w)
+		}
+	}
+	candidates := make([]string, len(all))
+	for i, w := range all {
+		candidates[i] = fmt.Sprintf("%s%sw=%d %dw", url, sep, w, w)
+	}
+	return strings.Join(candidates, ", "), nil
+}
+
+// markdownPolicy strips anything from rendered Markdown that is not safe
+// to show to other users, such as scripts and inline event handlers
+var markdownPolicy = bluemonday.UGCPolicy()
+
+// markdown renders user written Markdown to sanitized HTML
+func markdown(src string) (template.HTML, error) {
+	var buf bytes.Buffer
+	if err := goldmark.Convert([]byte(src), &buf); err != nil {
+		return "", err
+	}
+	return template.HTML(markdownPolicy.SanitizeBytes(buf.Bytes())), nil
+}
+
+// dict groups values for partials, e.g. {{template "field" (dict "Name" "email" "Label" "Email")}}
+func dict(kv ...interface{}) (map[string]interface{}, error) {
+	if len(kv)%2 != 0 {
+		return nil, errors.New("view: dict takes key value pairs")
+	}
+	m := make(map[string]interface{}, len(kv)/2)
+	for i := 0; i < len(kv); i += 2 {
+		k, ok := kv[i].(string)
+		if !ok {
+			return nil, fmt.Errorf("view: dict key %v is not a string", kv[i])
+		}
+		m[k] = kv[i+1]
+	}
+	return m, nil
+}
@@ -0,0 +1,76 @@
+package view
+
+import (
+	"net/http"
+	"net/url"
+	"strconv"
+)
+
+// Pagination is yielded to the "pagination" partial
+type Pagination struct {
+	Page    int
+	PerPage int
+	Total   int
+
+	// url is the current request URL; page links only change its page parameter
+	url url.URL
+}
+
+// NewPagination reads the page number from the ?page= parameter of r
+func NewPagination(r *http.Request, perPage, total int) Pagination {
+	page, err := strconv.Atoi(r.URL.Query().Get("page"))
+	if err != nil || page < 1 {
+		page = 1
+	}
+	p := Pagination{
+		Page:    page,
+		PerPage: perPage,
+		Total:   total,
+		url:     *r.URL,
+	}
+	if last := p.Pages(); p.Page > last && last > 0 {
+		p.Page = last
+	}
+	return p
+}
+
+// Pages is the number of pages needed to show Total items
+func (p Pagination) Pages() int {
+	if p.PerPage <= 0 {
+		return 1
+	}
+	return (p.Total + p.PerPage - 1) / p.PerPage
+}
+
+// Offset is the number of items before the current page, for LIMIT/OFFSET queries
+func (p Pagination) Offset() int {
+	return (p.Page - 1) * p.PerPage
+}
+
+// HasPrev reports whether there is a page before the current one
+func (p Pagination) HasPrev() bool {
+	return p.Page > 1
+}
+
+// HasNext reports whether there is a page after the current one
+func (p Pagination) HasNext() bool {
+	return p.Page < p.Pages()
+}
+
+// PrevURL links to the previous page
+func (p Pagination) PrevURL() string {
+	return p.pageURL(p.Page - 1)
+}
+
+// NextURL links to the next page
+func (p Pagination) NextURL() string {
+	return p.pageURL(p.Page + 1)
+}
+
+func (p Pagination) pageURL(page int) string {
+	u := p.url
+	q := u.Query()
+	q.Set("page", strconv.Itoa(page))
+	u.RawQuery = q.Encode()
+	return u.RequestURI()
+}
@@ -4,25 +4,56 @@ import (
 	"bytes"
 	"html/template"
 	"io"
+	"io/fs"
+	"log/slog"
 	"net/http"
-	"path/filepath"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jhampac/picha/context"
+	"github.com/jhampac/picha/i18n"
 )
 
 var (
+	// FS holds the templates; LayoutDir and TemplateDir are slash separated paths within it
+	FS fs.FS = os.DirFS(".")
+
 	// LayoutDir path to template layouts
 	LayoutDir string = "templates/layouts/"
 
+	// PartialDir path to partials shared by every page, such as form fields and pagination
+	PartialDir string = "templates/partials/"
+
 	// TemplateDir path to template directory
 	TemplateDir string = "templates/"
 
 	// TemplateExt is the extension for templates
 	TemplateExt string = ".gohtml"
+
+	// Reload makes views re-parse their files whenever one of them changes.
+	// It is meant for development; parse errors are shown in the browser instead of panicking.
+	Reload bool
 )
 
 // Alert is data used to render alerts in templates
 type Alert struct {
 	Level   string
 	Message string
+
+	// Args fill in the verbs of Message once it has been translated
+	Args []interface{} `json:",omitempty"`
+
+	// Action is an optional button of the alert, such as an undo
+	Action *AlertAction `json:",omitempty"`
+}
+
+// AlertAction is a button that posts to URL
+type AlertAction struct {
+	Label string
+	URL   string
 }
 
 const (
@@ -30,61 +61,211 @@ const (
 	AlertLvlWarning = "warning"
 	AlertLvlInfo    = "info"
 	AlertLvlSuccess = "success"
-	AlertMsgGeneric = "Something went wrong. Please try again, and contact usif the problem persists."
+	AlertMsgGeneric = "Something went wrong. Please try again, and contact us if the problem persists."
 )
 
 // View represents a view created by combining n... amount of templates
 type View struct {
 	Template *template.Template
 	Layout   string
+
+	// files are the page templates; layouts are globbed again on every parse
+	files []string
+
+	// only used when Reload is set
+	mu       sync.Mutex
+	modTimes map[string]time.Time
+	parseErr error
 }
 
 // New instantiates a *View type and returns it
 func New(layout string, files ...string) *View {
+	v, err := parse(layout, files...)
+	if err != nil && !Reload {
+		panic(err)
+	}
+	if err != nil {
+		slog.Error("parsing templates", "files", v.files, "error", err)
+	}
+	return v
+}
+
+// parse is New without the panic, for views built while serving requests.
+// The returned *View is never nil so it can be re-parsed once the files are fixed.
+func parse(layout string, files ...string) (*View, error) {
 	addTemplatePath(files)
 	addTemplateExt(files)
-	files = append(files, layoutFiles()...)
-	t, err := template.ParseFiles(files...)
+	v := &View{
+		Layout: layout,
+		files:  files,
+	}
+	v.parseErr = v.parse()
+	return v, v.parseErr
+}
+
+// parse (re)builds the template from the page files and the current layouts
+func (v *View) parse() error {
+	files := append(append([]string{}, v.files...), layoutFiles()...)
+	modTimes := make(map[string]time.Time, len(files))
+	for _, f := range files {
+		if info, err := fs.Stat(FS, f); err == nil {
+			modTimes[f] = info.ModTime()
+		}
+	}
+	v.modTimes = modTimes
+
+	t, err := template.New("").Funcs(funcMap).ParseFS(FS, files...)
 	if err != nil {
-		panic(err)
+		return err
+	}
+	v.Template = t
+	return nil
+}
+
+// stale reports whether a file was edited, added or removed since the last parse
+func (v *View) stale() bool {
+	files := append(append([]string{}, v.files...), layoutFiles()...)
+	if len(files) != len(v.modTimes) {
+		return true
+	}
+	for _, f := range files {
+		info, err := fs.Stat(FS, f)
+		if err != nil {
+			return true
+		}
+		if seen, ok := v.modTimes[f]; !ok || !info.ModTime().Equal(seen) {
+			return true
+		}
+	}
+	return false
+}
+
+// template returns the template to execute, re-parsing it first in Reload mode
+func (v *View) template() (*template.Template, error) {
+	if !Reload {
+		return v.Template, nil
 	}
 
-	return &View{
-		Template: t,
-		Layout:   layout,
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if v.parseErr != nil || v.stale() {
+		v.parseErr = v.parse()
 	}
+	return v.Template, v.parseErr
 }
 
 // Render executes a template and writes it to io.Writer
-func (v *View) Render(w http.ResponseWriter, data interface{}) {
+func (v *View) Render(w http.ResponseWriter, r *http.Request, data interface{}) {
+	v.RenderStatus(w, r, http.StatusOK, data)
+}
+
+// RenderStatus is Render with a status code other than 200 OK
+func (v *View) RenderStatus(w http.ResponseWriter, r *http.Request, status int, data interface{}) {
 	w.Header().Set("Content-Type", "text/html")
 
-	switch data.(type) {
+	var vd Data
+	switch d := data.(type) {
 	case Data:
+		vd = d
 	default:
-		data = Data{
+		vd = Data{
 			Yield: data,
 		}
 	}
+	vd.Flashes = append(vd.Flashes, popFlashes(w, r)...)
+	translateAlerts(&vd, context.Locale(r.Context()))
+
+	t, err := v.template()
+	if err != nil {
+		renderParseError(w, err)
+		return
+	}
+	// clone so the request bound funcs of concurrent renders do not collide
+	t, err = t.Clone()
+	if err != nil {
+		http.Error(w, "Something went wrong", http.StatusInternalServerError)
+		return
+	}
+	t.Funcs(requestFuncs(r, vd))
 
 	buf := &bytes.Buffer{}
-	err := v.Template.ExecuteTemplate(buf, v.Layout, data)
+	err = t.ExecuteTemplate(buf, v.Layout, vd)
 	if err != nil {
+		if Reload {
+			renderParseError(w, err)
+			return
+		}
 		http.Error(w, "Something went wrong", http.StatusInternalServerError)
 		return
 	}
 
+	w.WriteHeader(status)
 	io.Copy(w, buf)
 }
 
+// translateAlerts replaces the alerts and field errors of vd with translated copies
+func translateAlerts(vd *Data, locale string) {
+	if vd.Alert != nil {
+		a := translateAlert(*vd.Alert, locale)
+		vd.Alert = &a
+	}
+	flashes := make([]Alert, len(vd.Flashes))
+	for i, a := range vd.Flashes {
+		flashes[i] = translateAlert(a, locale)
+	}
+	vd.Flashes = flashes
+
+	fields := make(map[string]string, len(vd.Fields))
+	for field, msg := range vd.Fields {
+		fields[field] = i18n.T(locale, msg)
+	}
+	vd.Fields = fields
+}
+
+func translateAlert(a Alert, locale string) Alert {
+	a.Message = i18n.T(locale, a.Message, a.Args...)
+	if a.Action != nil {
+		action := *a.Action
+		action.Label = i18n.T(locale, action.Label)
+		a.Action = &action
+	}
+	return a
+}
+
 func (v *View) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	v.Render(w, nil)
+	v.Render(w, r, nil)
+}
+
+// parseErrorTemplate is deliberately independent of the layouts, which may be what is broken
+var parseErrorTemplate = template.Must(template.New("parse_error").Parse(`<!DOCTYPE html>
+<html lang="en">
+    <head>
+        <meta charset="utf-8">
+        <title>Template error</title>
+    </head>
+    <body style="font-family: monospace; padding: 15px;">
+        <h1 style="color: red;">Template error</h1>
+        <pre style="white-space: pre-wrap;">{{.}}</pre>
+        <p>Fix the template and reload the page.</p>
+    </body>
+</html>`))
+
+// renderParseError shows template errors in the browser; only reachable in Reload mode
+func renderParseError(w http.ResponseWriter, err error) {
+	slog.Error("rendering templates", "error", err)
+	w.WriteHeader(http.StatusInternalServerError)
+	parseErrorTemplate.Execute(w, err.Error())
 }
 
+// layoutFiles returns the layouts and partials parsed along with every page
 func layoutFiles() []string {
-	files, err := filepath.Glob(LayoutDir + "*" + TemplateExt)
-	if err != nil {
-		panic(err)
+	var files []string
+	for _, dir := range []string{LayoutDir, PartialDir} {
+		matches, err := fs.Glob(FS, dir+"*"+TemplateExt)
+		if err != nil {
+			panic(err)
+		}
+		files = append(files, matches...)
 	}
 	return files
 }
@@ -101,3 +282,19 @@ func addTemplateExt(files []string) {
 		files[i] = f + TemplateExt
 	}
 }
+
+// Check parses every page template together with the layouts, returning the
+// first error instead of panicking like New does
+func Check() error {
+	layouts := layoutFiles()
+	return fs.WalkDir(FS, path.Clean(TemplateDir), func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() || path.Ext(p) != TemplateExt || strings.HasPrefix(p, LayoutDir) || strings.HasPrefix(p, PartialDir) {
+			return nil
+		}
+		_, err = template.New("").Funcs(funcMap).ParseFS(FS, append([]string{p}, layouts...)...)
+		return err
+	})
+}